@@ -2,14 +2,23 @@ package main
 
 import (
 	"bufio"
+	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	compiler "github.com/wingerjc/tableman-golang/pkg/compile"
+	"github.com/wingerjc/tableman-golang/pkg/export"
 	"github.com/wingerjc/tableman-golang/pkg/program"
+	"github.com/wingerjc/tableman-golang/pkg/tracery"
 )
 
+// traceryPackName is the pack name for imported Tracery grammars.
+const traceryPackName = "tracery"
+
 // App is a standalone command line app that processes an inpute string
 // and places results on the output stream.
 type App struct {
@@ -19,7 +28,10 @@ type App struct {
 	compiler    *compiler.Compiler
 	interactive bool
 	echo        bool
-	CLIPrefix   string
+	locale      string
+	// coverage is whether row picks are counted.
+	coverage  bool
+	CLIPrefix string
 }
 
 // NewApp ceates a new CLI app from the given configuration.
@@ -48,6 +60,11 @@ func NewApp(opt *programOptions) (*App, error) {
 		return nil, fmt.Errorf("could not create compiler: %w", err)
 	}
 	app.compiler = c
+	app.compiler.SetWarningHandler(func(msg string) {
+		app.P("Warning: %s\n", msg)
+	})
+	app.locale = opt.Locale
+	app.coverage = opt.Coverage
 	if len(opt.InputFile) > 0 {
 		if err := app.loadProgram(opt.InputFile); err != nil {
 			return nil, err
@@ -103,6 +120,58 @@ func (app *App) CLILoop() error {
 				}
 				app.P("Could not load program '%s': %s\n", rest, err.Error())
 			}
+		// Save or restore deck, random and history state.
+		case "save":
+			if err := app.saveState(strings.TrimSpace(rest)); err != nil {
+				if !app.interactive {
+					return err
+				}
+				app.P("Could not save state: %s\n", err.Error())
+			}
+		case "restore":
+			if err := app.restoreState(strings.TrimSpace(rest)); err != nil {
+				if !app.interactive {
+					return err
+				}
+				app.P("Could not restore state: %s\n", err.Error())
+			}
+		// Convert Tracery grammars to and from table packs.
+		case "tracery-import":
+			if err := app.importTracery(strings.Fields(rest)); err != nil {
+				if !app.interactive {
+					return err
+				}
+				app.P("Could not import tracery grammar: %s\n", err.Error())
+			}
+		case "tracery-export":
+			if err := app.exportTracery(strings.Fields(rest)); err != nil {
+				if !app.interactive {
+					return err
+				}
+				app.P("Could not export tracery grammar: %s\n", err.Error())
+			}
+		// Publish the loaded tables as JSON, Markdown or HTML.
+		case "export":
+			if err := app.exportProgram(strings.TrimSpace(rest)); err != nil {
+				if !app.interactive {
+					return err
+				}
+				app.P("Could not export tables: %s\n", err.Error())
+			}
+		// Count the rows picked and report them.
+		case "coverage":
+			if err := app.coverageCommand(strings.TrimSpace(rest)); err != nil {
+				if !app.interactive {
+					return err
+				}
+				app.P("Could not report coverage: %s\n", err.Error())
+			}
+		// Set the locale for table variants and grammar functions.
+		case "locale":
+			app.locale = strings.TrimSpace(rest)
+			if app.prog != nil {
+				app.prog.SetLocale(app.locale)
+			}
 		default:
 			if app.interactive {
 				app.P("Could not understand command '%s'\n", first)
@@ -117,11 +186,173 @@ func (app *App) CLILoop() error {
 }
 
 func (app *App) loadProgram(fname string) error {
-	newProg, err := app.compiler.CompileFile(fname)
+	newProg, err := loadPack(app.compiler, strings.TrimSpace(fname))
+	if err != nil {
+		return err
+	}
+	app.setProgram(newProg)
+	return nil
+}
+
+// setProgram makes a program the one statements run against. It uses a seeded
+// random source so `save` keeps its random sequence.
+func (app *App) setProgram(prog *program.Program) {
+	prog.SetLocale(app.locale)
+	prog.SetCoverage(app.coverage)
+	prog.SetRandom(program.NewSeededRandSource(time.Now().UnixNano()))
+	app.prog = prog
+}
+
+// importTracery loads a Tracery grammar as the program, `tracery-import <grammar> [<pack>]`
+// also writes the converted pack source.
+func (app *App) importTracery(args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return fmt.Errorf("usage: tracery-import <grammar.json> [<pack.tman>]")
+	}
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+	code, diags, err := tracery.Import(data, traceryPackName)
+	if err != nil {
+		return err
+	}
+	app.printDiagnostics(diags)
+	newProg, err := app.compiler.CompileString(code)
 	if err != nil {
 		return err
 	}
-	app.prog = newProg
+	if len(args) == 2 {
+		if err := os.WriteFile(args[1], []byte(code), 0644); err != nil {
+			return err
+		}
+	}
+	app.setProgram(newProg)
+	return nil
+}
+
+// exportTracery converts a pack file, `tracery-export <pack> <grammar>`.
+func (app *App) exportTracery(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: tracery-export <pack.tman> <grammar.json>")
+	}
+	code, err := os.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+	data, diags, err := tracery.Export(string(code))
+	if err != nil {
+		return err
+	}
+	app.printDiagnostics(diags)
+	return os.WriteFile(args[1], data, 0644)
+}
+
+// exportProgram writes the tables of the loaded program, `export <file>` with
+// the format picked by the file extension.
+func (app *App) exportProgram(fname string) error {
+	if app.prog == nil {
+		return fmt.Errorf("no program loaded")
+	}
+	var data []byte
+	var err error
+	switch strings.ToLower(filepath.Ext(fname)) {
+	case ".json":
+		data, err = export.JSON(app.prog)
+	case ".md":
+		data, err = export.Markdown(app.prog)
+	case ".html", ".htm":
+		data, err = export.HTML(app.prog)
+	default:
+		return fmt.Errorf("usage: export <tables.json|tables.md|tables.html>")
+	}
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fname, data, 0644)
+}
+
+// coverageCommand runs `coverage on|off` to start counting row picks from zero
+// or stop, `coverage` to print the counts and `coverage <file.json>` to write
+// them as JSON.
+func (app *App) coverageCommand(arg string) error {
+	switch arg {
+	case "on", "off":
+		app.coverage = arg == "on"
+		if app.prog != nil {
+			app.prog.SetCoverage(app.coverage)
+		}
+		return nil
+	}
+	if app.prog == nil {
+		return fmt.Errorf("no program loaded")
+	}
+	if !app.coverage {
+		return fmt.Errorf("coverage is off, turn it on with 'coverage on' or the -coverage flag")
+	}
+	report := app.prog.Coverage()
+	if len(arg) > 0 {
+		data, err := json.Marshal(report)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(arg, data, 0644)
+	}
+	for _, t := range report {
+		name := t.Pack + "." + t.Table
+		if len(t.Lang) > 0 {
+			name += " (" + t.Lang + ")"
+		}
+		app.P("%s: %d of %d rows picked\n", name, t.Covered(), len(t.Rows))
+		for _, r := range t.Rows {
+			row := fmt.Sprintf("%d", r.Row)
+			if len(r.Label) > 0 {
+				row += " " + r.Label
+			}
+			modes := make([]string, 0, len(r.Hits))
+			for mode, n := range r.Hits {
+				modes = append(modes, fmt.Sprintf("%s %d", mode, n))
+			}
+			sort.Strings(modes)
+			if len(modes) > 0 {
+				app.P("  %s: %d (%s)\n", row, r.Total, strings.Join(modes, ", "))
+			} else {
+				app.P("  %s: 0\n", row)
+			}
+		}
+	}
+	return nil
+}
+
+func (app *App) printDiagnostics(diags []*tracery.Diagnostic) {
+	for _, d := range diags {
+		app.P("Warning: %s\n", d)
+	}
+}
+
+func (app *App) saveState(fname string) error {
+	if app.prog == nil {
+		return fmt.Errorf("no program loaded")
+	}
+	data, err := app.prog.Snapshot()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(fname, data, 0644)
+}
+
+func (app *App) restoreState(fname string) error {
+	if app.prog == nil {
+		return fmt.Errorf("no program loaded")
+	}
+	data, err := os.ReadFile(fname)
+	if err != nil {
+		return err
+	}
+	if err := app.prog.Restore(data); err != nil {
+		return err
+	}
+	app.locale = app.prog.Locale()
 	return nil
 }
 
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// runScript runs CLI commands against a pack and returns the output lines.
+func runScript(assert *assert.Assertions, dir string, pack string, commands ...string) []string {
+	script := filepath.Join(dir, "commands.script")
+	out := filepath.Join(dir, "out.txt")
+	assert.NoError(os.WriteFile(script, []byte(strings.Join(commands, "\n")), 0644))
+	app, err := NewApp(&programOptions{
+		InputFile:  pack,
+		ScriptFile: script,
+		OutputFile: out,
+	})
+	assert.NoError(err)
+	assert.NoError(app.CLILoop())
+	assert.NoError(app.Flush())
+	data, err := os.ReadFile(out)
+	assert.NoError(err)
+	return strings.Split(strings.TrimSpace(string(data)), "\n")
+}
+
+func TestSaveRestoreCommands(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	pack := filepath.Join(dir, "cards.tman")
+	assert.NoError(os.WriteFile(pack, []byte(`TablePack: cards
+
+TableDef: suit
+"hearts"
+"spades"
+"clubs"
+"diamonds"
+`), 0644))
+	state := filepath.Join(dir, "state.json")
+
+	saved := runScript(assert, dir, pack,
+		"e { !suit(deck) }",
+		fmt.Sprintf("save %s", state),
+		"e { !suit(deck) }",
+		"e { 1d1000? }",
+		"e { !suit(deck) }",
+	)
+	assert.Len(saved, 4)
+
+	// A new run continues the deck and the random sequence from the file.
+	restored := runScript(assert, dir, pack,
+		fmt.Sprintf("restore %s", state),
+		"e { !suit(deck) }",
+		"e { 1d1000? }",
+		"e { !suit(deck) }",
+	)
+	assert.Equal(saved[1:], restored)
+
+	// A state from another pack is rejected.
+	other := filepath.Join(dir, "other.tman")
+	assert.NoError(os.WriteFile(other, []byte("TablePack: other\n\nTableDef: suit\n\"hearts\"\n"), 0644))
+	app, err := NewApp(&programOptions{InputFile: other})
+	assert.NoError(err)
+	assert.Error(app.restoreState(state))
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	compiler "github.com/wingerjc/tableman-golang/pkg/compile"
+	"github.com/wingerjc/tableman-golang/pkg/program"
+)
+
+// runBuild writes pack artifacts, `tableman build [flags] <pack.tman>...`.
+// Each artifact is written next to its pack unless -out is given.
+func runBuild(args []string) error {
+	flags := flag.NewFlagSet("build", flag.ContinueOnError)
+	out := flags.String("out", "", "File to write the artifact to, only for a single pack.")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: %s build [flags] <pack.tman>...\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err == flag.ErrHelp {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("no pack files given")
+	}
+	if len(*out) > 0 && flags.NArg() > 1 {
+		return fmt.Errorf("-out can only be used with a single pack")
+	}
+	c, err := compiler.NewCompiler()
+	if err != nil {
+		return err
+	}
+	c.SetWarningHandler(func(msg string) {
+		fmt.Printf("Warning: %s\n", msg)
+	})
+	for _, fname := range flags.Args() {
+		target := *out
+		if len(target) == 0 {
+			target = strings.TrimSuffix(fname, filepath.Ext(fname)) + compiler.ArtifactExt
+		}
+		data, err := c.Build(fname, target)
+		if err != nil {
+			return fmt.Errorf("could not build '%s': %w", fname, err)
+		}
+		if err := ioutil.WriteFile(target, data, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadPack compiles a pack file, or loads an artifact written by
+// `tableman build` if the file has the artifact extension.
+func loadPack(c *compiler.Compiler, fname string) (*program.Program, error) {
+	if strings.EqualFold(filepath.Ext(fname), compiler.ArtifactExt) {
+		return c.LoadArtifactFile(fname)
+	}
+	return c.CompileFile(fname)
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	compiler "github.com/wingerjc/tableman-golang/pkg/compile"
+	"github.com/wingerjc/tableman-golang/pkg/docgen"
+)
+
+// runDoc builds a documentation site, `tableman doc [flags] <pack.tman|dir>...`.
+func runDoc(args []string) error {
+	opts := docgen.NewOptions()
+	flags := flag.NewFlagSet("doc", flag.ContinueOnError)
+	out := flags.String("out", "site", "Directory to write the site to.")
+	flags.Int64Var(&opts.Seed, "seed", docgen.DefaultSeed, "Seed for example rolls.")
+	flags.IntVar(&opts.Examples, "examples", docgen.DefaultExamples, "Number of example rolls for each table.")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: %s doc [flags] <pack.tman|dir>...\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err == flag.ErrHelp {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("no pack files or directories given")
+	}
+	c, err := compiler.NewCompiler()
+	if err != nil {
+		return err
+	}
+	site, err := docgen.Build(c, flags.Args(), opts)
+	if err != nil {
+		return err
+	}
+	return site.Write(*out)
+}
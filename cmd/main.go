@@ -2,9 +2,34 @@ package main
 
 import (
 	"log"
+	"os"
 )
 
 func main() {
+	// Build a documentation site for packs.
+	if len(os.Args) > 1 && os.Args[1] == "doc" {
+		if err := runDoc(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Write compiled pack artifacts.
+	if len(os.Args) > 1 && os.Args[1] == "build" {
+		if err := runBuild(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Run the pack tests.
+	if len(os.Args) > 1 && os.Args[1] == "test" {
+		if err := runTest(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	opt := readFlags()
 
 	// Run as a web server
@@ -12,6 +37,7 @@ func main() {
 		cfg := NewServerConfig()
 		cfg.packConfigPath = opt.Web.PackConfig
 		cfg.staticFilePath = opt.Web.StaticPath
+		cfg.coverage = opt.Web.Coverage
 
 		s, err := NewServer(cfg)
 		if err != nil {
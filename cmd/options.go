@@ -11,6 +11,8 @@ func readFlags() *programOptions {
 	flag.BoolVar(&result.Interactive, "interact", true, "Whether to print command prompt.")
 	flag.BoolVar(&result.Echo, "echo", false, "Whether to echo each commmand to output.")
 	flag.StringVar(&result.CLIPrefix, "prefix", "$ ", "The prefix for command line input")
+	flag.StringVar(&result.Locale, "locale", "", "Locale for table variants and grammar functions, e.g. 'de'.")
+	flag.BoolVar(&result.Coverage, "coverage", false, "Count the table rows picked, see the coverage command.")
 
 	// Web server flags
 	flag.BoolVar(&result.Web.RunWeb, "web", false, "Run the program as a web server.")
@@ -19,6 +21,7 @@ func readFlags() *programOptions {
 	flag.StringVar(&result.Web.KeyFile, "web-keyfile", "", "Keyfile for TLS, web-certfile must also be defined")
 	flag.StringVar(&result.Web.PackConfig, "web-packs", "", "Path to config file for packs to load.")
 	flag.StringVar(&result.Web.StaticPath, "web-static-path", "", "Local path to static files to serve.")
+	flag.BoolVar(&result.Web.Coverage, "web-coverage", false, "Count the table rows picked and serve them at /metrics.")
 
 	flag.Parse()
 	return result
@@ -32,6 +35,8 @@ type programOptions struct {
 	Interactive bool
 	Echo        bool
 	CLIPrefix   string
+	Locale      string
+	Coverage    bool
 }
 
 type WebOptions struct {
@@ -44,4 +49,6 @@ type WebOptions struct {
 	PackConfig string
 	// Local path for static file serving.
 	StaticPath string
+	// Whether to count row picks for the metrics endpoint.
+	Coverage bool
 }
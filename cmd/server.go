@@ -23,6 +23,8 @@ type ServerConfig struct {
 	KeyFile        string
 	packConfigPath string
 	staticFilePath string
+	// coverage is whether row picks are counted for /metrics.
+	coverage bool
 }
 
 func NewServerConfig() *ServerConfig {
@@ -84,13 +86,17 @@ func (s *Server) load(packs *web.PackWebConfig) error {
 		if err != nil {
 			return err
 		}
+		s.compiler.SetWarningHandler(func(msg string) {
+			fmt.Printf("Warning: %s\n", msg)
+		})
 	}
 
 	for _, p := range packs.Packs {
-		prog, err := s.compiler.CompileFile(p.Path)
+		prog, err := loadPack(s.compiler, p.Path)
 		if err != nil {
 			return err
 		}
+		prog.SetCoverage(s.cfg.coverage)
 		s.packs[p.Name] = prog
 		s.loadedPacks = append(s.loadedPacks, p)
 	}
@@ -105,6 +111,8 @@ func (s *Server) register() {
 	mux.HandleFunc("/session", s.handleSession())
 	mux.HandleFunc("/pack", s.handlePacks())
 	mux.HandleFunc("/eval", s.handleEval())
+	mux.HandleFunc("/state", s.handleState())
+	mux.HandleFunc("/metrics", s.handleMetrics())
 
 	if len(s.cfg.staticFilePath) > 0 {
 		pathStr, _ := filepath.Abs(s.cfg.staticFilePath)
@@ -197,7 +205,7 @@ func (s *Server) handleEval() func(http.ResponseWriter, *http.Request) {
 			if !s.LoadPack(rw, sid, req.Pack) {
 				return
 			}
-			res, err := s.sessions.Eval(sid, req.Pack, expr)
+			res, err := s.sessions.Eval(sid, req.Pack, expr, req.Locale)
 			if err != nil {
 				result.RuntimeError = err.Error()
 				rw.WriteHeader(500)
@@ -215,6 +223,73 @@ func (s *Server) handleEval() func(http.ResponseWriter, *http.Request) {
 	}
 }
 
+func (s *Server) handleState() func(http.ResponseWriter, *http.Request) {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		// GET exports the deck, random and history state of the session.
+		case http.MethodGet:
+			sid := s.sessionAuth(rw, r)
+			state, err := s.sessions.Snapshot(sid)
+			if err != nil {
+				errOut(rw, err)
+				return
+			}
+			data, err := json.Marshal(state)
+			if err != nil {
+				errOut(rw, err)
+				return
+			}
+			rw.Write(data)
+		// PUT loads the packs in the given state and restores it.
+		case http.MethodPut:
+			sid := s.sessionAuth(rw, r)
+			req := &web.SessionStateDTO{}
+			if err := decode(r, req); err != nil {
+				errOut(rw, err)
+				return
+			}
+			if err := s.sessions.Restore(sid, req, s.packs); err != nil {
+				rw.WriteHeader(400)
+				errFmt(rw, err.Error())
+				return
+			}
+			rw.WriteHeader(200)
+		default:
+			rw.WriteHeader(405)
+		}
+	}
+}
+
+// handleMetrics reports the rows picked in each pack by every session, the
+// copies of a pack in sessions share its counts.
+func (s *Server) handleMetrics() func(http.ResponseWriter, *http.Request) {
+	return func(rw http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			if !s.cfg.coverage {
+				rw.WriteHeader(404)
+				errFmt(rw, "Coverage is off, start the server with -web-coverage")
+				return
+			}
+			result := make([]*web.PackCoverageDTO, 0, len(s.loadedPacks))
+			for _, p := range s.loadedPacks {
+				result = append(result, &web.PackCoverageDTO{
+					Pack:   p.Name,
+					Tables: s.packs[p.Name].Coverage(),
+				})
+			}
+			data, err := json.Marshal(result)
+			if err != nil {
+				errOut(rw, err)
+				return
+			}
+			rw.Write(data)
+		default:
+			rw.WriteHeader(405)
+		}
+	}
+}
+
 func (s *Server) LoadPack(rw http.ResponseWriter, sid string, pack string) bool {
 	prog, ok := s.packs[pack]
 	if !ok {
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/wingerjc/tableman-golang/cmd/web"
+	compiler "github.com/wingerjc/tableman-golang/pkg/compile"
+	"github.com/wingerjc/tableman-golang/pkg/program"
+)
+
+func testServer(assert *assert.Assertions) *Server {
+	c, err := compiler.NewCompiler()
+	assert.NoError(err)
+	prog, err := c.CompileString(`TablePack: cards
+
+	TableDef: suit
+	"hearts"
+	"spades"
+	"clubs"
+	"diamonds"`)
+	assert.NoError(err)
+	return &Server{
+		cfg:      NewServerConfig(),
+		packs:    map[string]*program.Program{"cards": prog},
+		compiler: c,
+		sessions: web.NewSessionSet(10, time.Hour),
+	}
+}
+
+// call sends a JSON request to a handler as the session in the cookie, or a
+// new session if it's nil.
+func call(handler http.HandlerFunc, method string, body interface{}, cookie *http.Cookie) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	req := httptest.NewRequest(method, "/", bytes.NewReader(data))
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rw := httptest.NewRecorder()
+	handler(rw, req)
+	return rw
+}
+
+func sessionCookie(rw *httptest.ResponseRecorder) *http.Cookie {
+	for _, c := range rw.Result().Cookies() {
+		if c.Name == SessionIDCookie {
+			return c
+		}
+	}
+	return nil
+}
+
+func TestStateEndpoint(t *testing.T) {
+	assert := assert.New(t)
+	s := testServer(assert)
+	eval := s.handleEval()
+	state := s.handleState()
+	draw := &web.EvalDTO{Pack: "cards", Expr: `{ !suit(deck) }`}
+
+	rw := call(eval, http.MethodPost, draw, nil)
+	assert.Equal(200, rw.Code)
+	cookie := sessionCookie(rw)
+	assert.NotNil(cookie)
+
+	rw = call(state, http.MethodGet, nil, cookie)
+	assert.Equal(200, rw.Code)
+	saved := rw.Body.Bytes()
+
+	expected := make([]string, 0)
+	for i := 0; i < 3; i++ {
+		rw = call(eval, http.MethodPost, draw, cookie)
+		assert.Equal(200, rw.Code)
+		res := &web.EvalResultDTO{}
+		assert.NoError(json.Unmarshal(rw.Body.Bytes(), res))
+		expected = append(expected, res.Result)
+	}
+
+	// A new session continues the deck from the saved state.
+	snapshot := &web.SessionStateDTO{}
+	assert.NoError(json.Unmarshal(saved, snapshot))
+	rw = call(state, http.MethodPut, snapshot, nil)
+	assert.Equal(200, rw.Code)
+	restored := sessionCookie(rw)
+	for _, e := range expected {
+		rw = call(eval, http.MethodPost, draw, restored)
+		res := &web.EvalResultDTO{}
+		assert.NoError(json.Unmarshal(rw.Body.Bytes(), res))
+		assert.Equal(e, res.Result)
+	}
+
+	// Unknown packs are rejected.
+	snapshot.Packs["missing"] = snapshot.Packs["cards"]
+	rw = call(state, http.MethodPut, snapshot, restored)
+	assert.Equal(400, rw.Code)
+	assert.Contains(rw.Body.String(), "no pack set named missing")
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	compiler "github.com/wingerjc/tableman-golang/pkg/compile"
+	"github.com/wingerjc/tableman-golang/pkg/packtest"
+)
+
+// runTest runs pack tests, `tableman test [flags] [file.tmantest|dir]...`.
+// The current directory is searched when no paths are given.
+func runTest(args []string) error {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	verbose := flags.Bool("v", false, "Print passing tests too.")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "usage: %s test [flags] [file.tmantest|dir]...\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err == flag.ErrHelp {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	fnames, err := packtest.Discover(paths)
+	if err != nil {
+		return err
+	}
+	if len(fnames) == 0 {
+		return fmt.Errorf("no %s files found", packtest.TestExt)
+	}
+	c, err := compiler.NewCompiler()
+	if err != nil {
+		return err
+	}
+
+	passed, failed := 0, 0
+	for _, fname := range fnames {
+		f, err := packtest.Load(fname)
+		if err != nil {
+			fmt.Printf("FAIL %s\n    %s\n", fname, err.Error())
+			failed++
+			continue
+		}
+		for _, r := range packtest.Run(c, f) {
+			if r.Passed() {
+				passed++
+				if *verbose {
+					fmt.Printf("PASS %s: %s\n", r.File, r.Name)
+				}
+				continue
+			}
+			failed++
+			fmt.Printf("FAIL %s: %s\n", r.File, r.Name)
+			for _, msg := range r.Failures {
+				fmt.Printf("    %s\n", strings.ReplaceAll(msg, "\n", "\n    "))
+			}
+		}
+	}
+	fmt.Printf("%d passed, %d failed\n", passed, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d tests failed", failed)
+	}
+	return nil
+}
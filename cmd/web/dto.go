@@ -1,5 +1,11 @@
 package web
 
+import (
+	"encoding/json"
+
+	"github.com/wingerjc/tableman-golang/pkg/program"
+)
+
 type ErrorDTO struct {
 	Error string `json:"errorMessage"`
 }
@@ -24,9 +30,14 @@ func NewPackDefDTO(name string, title string) *PackDefDTO {
 	}
 }
 
+type SessionStateDTO struct {
+	Packs map[string]json.RawMessage `json:"packs"`
+}
+
 type EvalDTO struct {
-	Expr string `json:"expression"`
-	Pack string `json:"pack"`
+	Expr   string `json:"expression"`
+	Pack   string `json:"pack"`
+	Locale string `json:"locale,omitempty"`
 }
 
 type EvalResultDTO struct {
@@ -35,3 +46,8 @@ type EvalResultDTO struct {
 	CompileError string `json:"compile-error,omitempty"`
 	RuntimeError string `json:"runtime-error,omitempty"`
 }
+
+type PackCoverageDTO struct {
+	Pack   string                   `json:"pack"`
+	Tables []*program.TableCoverage `json:"tables"`
+}
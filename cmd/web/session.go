@@ -1,6 +1,7 @@
 package web
 
 import (
+	"encoding/json"
 	"fmt"
 	"sync"
 	"time"
@@ -70,14 +71,34 @@ func (ss *SessionSet) AddPack(sid string, key string, pack *program.Program) err
 	return nil
 }
 
-func (ss *SessionSet) Eval(sid string, key string, expr program.Evallable) (string, error) {
+func (ss *SessionSet) Eval(sid string, key string, expr program.Evallable, locale string) (string, error) {
 	ss.RLock()
 	defer ss.RUnlock()
 	s, ok := ss.sessions[sid]
 	if !ok {
 		return "", fmt.Errorf("invalid session ID %s", sid)
 	}
-	return s.Eval(key, expr)
+	return s.Eval(key, expr, locale)
+}
+
+func (ss *SessionSet) Snapshot(sid string) (*SessionStateDTO, error) {
+	ss.RLock()
+	defer ss.RUnlock()
+	s, ok := ss.sessions[sid]
+	if !ok {
+		return nil, fmt.Errorf("invalid session ID %s", sid)
+	}
+	return s.Snapshot()
+}
+
+func (ss *SessionSet) Restore(sid string, state *SessionStateDTO, packs map[string]*program.Program) error {
+	ss.RLock()
+	defer ss.RUnlock()
+	s, ok := ss.sessions[sid]
+	if !ok {
+		return fmt.Errorf("invalid session ID %s", sid)
+	}
+	return s.Restore(state, packs)
 }
 
 func (ss *SessionSet) Contains(sid string) bool {
@@ -93,6 +114,7 @@ type Session struct {
 	packMu   sync.RWMutex
 	packs    map[string]*program.Program
 	history  *program.RollHistory
+	recent   *program.RecentPicks
 }
 
 func NewSession() *Session {
@@ -100,6 +122,7 @@ func NewSession() *Session {
 		accessed: timeNow(),
 		packs:    make(map[string]*program.Program),
 		history:  program.NewRollHistory(),
+		recent:   program.NewRecentPicks(),
 	}
 }
 
@@ -115,15 +138,28 @@ func (s *Session) Accessed() time.Time {
 	return s.accessed
 }
 
+// AddPack adds a pack to the session, a pack already loaded under the key is
+// kept so its decks carry on between evaluations.
 func (s *Session) AddPack(key string, pack *program.Program) {
 	s.Touch()
 	s.packMu.Lock()
-	pack.SetHistory(s.history)
 	defer s.packMu.Unlock()
+	if _, ok := s.packs[key]; ok {
+		return
+	}
+	s.addPack(key, pack)
+}
+
+// addPack adds a pack sharing the session history, the caller holds packMu.
+// Packs use a seeded random source so their state can be saved.
+func (s *Session) addPack(key string, pack *program.Program) {
+	pack.SetHistory(s.history)
+	pack.SetRecentPicks(s.recent)
+	pack.SetRandom(program.NewSeededRandSource(timeNow().UnixNano()))
 	s.packs[key] = pack
 }
 
-func (s *Session) Eval(packKey string, expr program.Evallable) (string, error) {
+func (s *Session) Eval(packKey string, expr program.Evallable, locale string) (string, error) {
 	s.Touch()
 	s.packMu.RLock()
 	defer s.packMu.RUnlock()
@@ -132,7 +168,7 @@ func (s *Session) Eval(packKey string, expr program.Evallable) (string, error) {
 		return "", fmt.Errorf("table set named %s not loaded", packKey)
 	}
 
-	res, err := p.Eval(expr)
+	res, err := p.EvalLocale(expr, locale)
 	if err != nil {
 		return "", err
 	}
@@ -141,3 +177,56 @@ func (s *Session) Eval(packKey string, expr program.Evallable) (string, error) {
 	}
 	return res.StringVal(), nil
 }
+
+// Snapshot returns the state of every pack loaded in the session.
+func (s *Session) Snapshot() (*SessionStateDTO, error) {
+	s.Touch()
+	s.packMu.RLock()
+	defer s.packMu.RUnlock()
+	result := &SessionStateDTO{
+		Packs: make(map[string]json.RawMessage),
+	}
+	for k, p := range s.packs {
+		data, err := p.Snapshot()
+		if err != nil {
+			return nil, err
+		}
+		result.Packs[k] = data
+	}
+	return result, nil
+}
+
+// Restore restores pack state from Snapshot. Packs not loaded in the session
+// are copied from packs first. Every pack's state is checked before any is
+// restored, so a bad state leaves the session as it was.
+func (s *Session) Restore(state *SessionStateDTO, packs map[string]*program.Program) error {
+	s.Touch()
+	s.packMu.Lock()
+	defer s.packMu.Unlock()
+	targets := make(map[string]*program.Program)
+	added := make(map[string]*program.Program)
+	for k, data := range state.Packs {
+		p, ok := s.packs[k]
+		if !ok {
+			loaded, ok := packs[k]
+			if !ok {
+				return fmt.Errorf("no pack set named %s", k)
+			}
+			p = loaded.Copy()
+			added[k] = p
+		}
+		if err := p.CheckSnapshot(data); err != nil {
+			return fmt.Errorf("could not restore table set %s: %w", k, err)
+		}
+		targets[k] = p
+	}
+	for k, p := range added {
+		s.addPack(k, p)
+	}
+	for k, p := range targets {
+		if err := p.Restore(state.Packs[k]); err != nil {
+			return fmt.Errorf("could not restore table set %s: %w", k, err)
+		}
+	}
+	return nil
+}
@@ -1,10 +1,13 @@
 package web
 
 import (
+	"encoding/json"
 	"testing"
 	"time"
 
 	"github.com/stretchr/testify/assert"
+	compiler "github.com/wingerjc/tableman-golang/pkg/compile"
+	"github.com/wingerjc/tableman-golang/pkg/program"
 )
 
 // type utilData struct {
@@ -57,3 +60,81 @@ func TestSessionDropping(t *testing.T) {
 	assert.NotContains(set.sessions, third)
 	assert.Contains(set.sessions, second)
 }
+
+func TestSessionAvoidRepeat(t *testing.T) {
+	assert := assert.New(t)
+
+	rows := []*program.TableRow{
+		program.NewTableRow("", make([]*program.Range, 0), 1, 1, false, program.NewString("first", true)),
+		program.NewTableRow("", make([]*program.Range, 0), 1, 1, false, program.NewString("second", true)),
+	}
+	tags := map[string]string{program.AvoidRepeatTag: "1"}
+	table := program.NewTable("name", tags, rows)
+	pack := program.NewTablePack("key", "names", map[string]*program.Table{"name": table})
+	prog := program.NewProgram(program.TableMap{program.RootPack: pack})
+	roll, err := program.NewTableCall(program.RootPack, "", "name", make([]program.Evallable, 0))
+	assert.NoError(err)
+
+	// Packs are reloaded for each eval, the recent rolls live in the session.
+	s := NewSession()
+	last := ""
+	for i := 0; i < 10; i++ {
+		s.AddPack("names", prog.Copy())
+		res, err := s.Eval("names", roll, "")
+		assert.NoError(err)
+		assert.NotEqual(last, res)
+		last = res
+	}
+}
+
+func TestSessionRestore(t *testing.T) {
+	assert := assert.New(t)
+	c, err := compiler.NewCompiler()
+	assert.NoError(err)
+	prog, err := c.CompileString(`TablePack: cards
+
+	TableDef: suit
+	"hearts"
+	"spades"
+	"clubs"`)
+	assert.NoError(err)
+	draw, err := c.CompileExpression(`{ !suit(deck) }`)
+	assert.NoError(err)
+	packs := map[string]*program.Program{"cards": prog}
+
+	s := NewSession()
+	s.AddPack("cards", prog.Copy())
+	first, err := s.Eval("cards", draw, "")
+	assert.NoError(err)
+	state, err := s.Snapshot()
+	assert.NoError(err)
+	expected := make([]string, 0)
+	for i := 0; i < 2; i++ {
+		res, err := s.Eval("cards", draw, "")
+		assert.NoError(err)
+		expected = append(expected, res)
+	}
+	assert.NotContains(expected, first)
+
+	// A new session without the pack loaded continues from the state.
+	restored := NewSession()
+	assert.NoError(restored.Restore(state, packs))
+	for _, e := range expected {
+		res, err := restored.Eval("cards", draw, "")
+		assert.NoError(err)
+		assert.Equal(e, res)
+	}
+
+	// Bad state changes nothing, even for the packs that could be restored.
+	bad := &SessionStateDTO{Packs: map[string]json.RawMessage{
+		"cards":   state.Packs["cards"],
+		"missing": state.Packs["cards"],
+	}}
+	fresh := NewSession()
+	assert.Error(fresh.Restore(bad, packs))
+	assert.Empty(fresh.packs)
+	bad.Packs = map[string]json.RawMessage{"cards": json.RawMessage(`{"version": 99}`)}
+	assert.Error(restored.Restore(bad, packs))
+	_, err = restored.Eval("cards", draw, "")
+	assert.Error(err, "the deck stays empty")
+}
@@ -0,0 +1,191 @@
+package compiler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/wingerjc/tableman-golang/pkg/program"
+)
+
+const (
+	// ArtifactVersion is the version of the artifact format written by Build.
+	// The compiled program in it has its own version, program.EncodingVersion,
+	// both are checked when an artifact is loaded.
+	ArtifactVersion = 3
+	// ArtifactExt is the extension of pack artifacts.
+	ArtifactExt = ".tmanc"
+
+	artifactMagic = "tableman-artifact"
+)
+
+// ErrStaleArtifact is returned for artifacts that must be rebuilt, because
+// they were written by another version or their source files changed.
+var ErrStaleArtifact = errors.New("stale artifact")
+
+// artifact is a compiled pack with its imports, written as JSON after a header
+// line with the artifact and program format versions. The program is loaded
+// as it was compiled, without parsing or compiling the sources again.
+type artifact struct {
+	// Root is the directory of the root pack, relative to the artifact.
+	Root string
+	// Sources are the pack, import and table data files relative to Root.
+	Sources []*artifactSource
+	Program json.RawMessage
+}
+
+// artifactSource is a file the program was compiled from.
+type artifactSource struct {
+	Name string
+	// Key is the hash of the file when the artifact was built.
+	Key string
+}
+
+// Build compiles a pack file, its imports and table data files into an
+// artifact LoadArtifact reads without compiling. target is the path the
+// artifact will be written to, the sources are found relative to it when it
+// is loaded.
+func (c *Compiler) Build(fileName string, target string) ([]byte, error) {
+	absolutePath, err := filepath.Abs(fileName)
+	if err != nil {
+		return nil, err
+	}
+	absoluteTarget, err := filepath.Abs(target)
+	if err != nil {
+		return nil, err
+	}
+	pack, err := c.loadFile(absolutePath)
+	if err != nil {
+		return nil, err
+	}
+	files, err := c.loadImports(pack)
+	if err != nil {
+		return nil, err
+	}
+	prog, err := c.link(files)
+	if err != nil {
+		return nil, err
+	}
+	encoded, err := prog.Encode()
+	if err != nil {
+		return nil, fmt.Errorf("could not write artifact for '%s': %w", fileName, err)
+	}
+
+	packDir := filepath.Dir(absolutePath)
+	root, err := artifactPath(filepath.Dir(absoluteTarget), packDir)
+	if err != nil {
+		return nil, err
+	}
+	a := &artifact{Root: root, Program: encoded}
+	addSource := func(fname string, key string) error {
+		name, err := artifactPath(packDir, fname)
+		if err != nil {
+			return err
+		}
+		a.Sources = append(a.Sources, &artifactSource{Name: name, Key: key})
+		return nil
+	}
+	for _, f := range files {
+		if err := addSource(f.fname, f.key); err != nil {
+			return nil, err
+		}
+		for _, d := range f.data {
+			if err := addSource(d.fname, d.key); err != nil {
+				return nil, err
+			}
+		}
+	}
+	body, err := json.Marshal(a)
+	if err != nil {
+		return nil, fmt.Errorf("could not write artifact for '%s': %w", fileName, err)
+	}
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s %d %d\n", artifactMagic, ArtifactVersion, program.EncodingVersion)
+	buf.Write(body)
+	return buf.Bytes(), nil
+}
+
+// LoadArtifact loads the compiled pack in an artifact written by Build. If dir,
+// the directory of the artifact, is given the sources are read to check they
+// haven't changed since the build, ErrStaleArtifact is returned if they have.
+// Missing sources can't be checked and are reported as warnings.
+func (c *Compiler) LoadArtifact(data []byte, dir string) (*program.Program, error) {
+	r := bufio.NewReader(bytes.NewReader(data))
+	var magic string
+	var version, encoding int
+	if _, err := fmt.Fscanf(r, "%s %d %d\n", &magic, &version, &encoding); err != nil || magic != artifactMagic {
+		if magic == artifactMagic {
+			return nil, fmt.Errorf("%w: unknown artifact header", ErrStaleArtifact)
+		}
+		return nil, fmt.Errorf("not a pack artifact")
+	}
+	if version != ArtifactVersion {
+		return nil, fmt.Errorf("%w: artifact version %d, expected %d", ErrStaleArtifact, version, ArtifactVersion)
+	}
+	if encoding != program.EncodingVersion {
+		return nil, fmt.Errorf("%w: program version %d, expected %d", ErrStaleArtifact, encoding, program.EncodingVersion)
+	}
+	a := &artifact{}
+	if err := json.NewDecoder(r).Decode(a); err != nil {
+		return nil, fmt.Errorf("could not read artifact: %w", err)
+	}
+	if len(dir) > 0 {
+		root := filepath.Join(dir, filepath.FromSlash(a.Root))
+		for _, s := range a.Sources {
+			if err := c.checkSource(filepath.Join(root, filepath.FromSlash(s.Name)), s.Key); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return program.DecodeProgram(a.Program)
+}
+
+// LoadArtifactFile reads an artifact and loads it like LoadArtifact, checking
+// its sources haven't changed.
+func (c *Compiler) LoadArtifactFile(fileName string) (*program.Program, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := filepath.Abs(filepath.Dir(fileName))
+	if err != nil {
+		return nil, err
+	}
+	prog, err := c.LoadArtifact(data, dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not load '%s': %w", fileName, err)
+	}
+	return prog, nil
+}
+
+// artifactPath returns a path relative to dir, with forward slashes so the
+// artifact can be used on other systems.
+func artifactPath(dir string, fname string) (string, error) {
+	rel, err := filepath.Rel(dir, fname)
+	if err != nil {
+		return "", fmt.Errorf("could not write artifact path for '%s': %w", fname, err)
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// checkSource checks a source file still has the hash it was built with, a
+// missing source is reported as a warning.
+func (c *Compiler) checkSource(fname string, key string) error {
+	code, err := ioutil.ReadFile(fname)
+	if errors.Is(err, fs.ErrNotExist) {
+		c.warn(fmt.Sprintf("artifact source '%s' is missing, it can't be checked for changes", fname))
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not check source '%s': %w", fname, err)
+	}
+	if makeKey(string(code)) != key {
+		return fmt.Errorf("%w: '%s' changed", ErrStaleArtifact, fname)
+	}
+	return nil
+}
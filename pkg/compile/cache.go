@@ -0,0 +1,271 @@
+package compiler
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/wingerjc/tableman-golang/pkg/parser"
+	"github.com/wingerjc/tableman-golang/pkg/program"
+)
+
+// cache keeps parsed files and compiled packs between compilations, so a pack
+// imported by many packs is only parsed and compiled once. Files are read again
+// when their modification time or size changes, parse trees and packs are kept
+// by the hash of everything they're made from. Only what's made from the
+// current source of files read by path is kept, entries for an old source are
+// dropped when a file changes. Safe for concurrent use.
+type cache struct {
+	mu sync.Mutex
+	// files are the source hashes of files by path.
+	files map[string]*cachedFile
+	// parsed are parse trees by source hash.
+	parsed map[string]*parser.TableFile
+	// data are table data files by path.
+	data map[string]*cachedData
+	// packs are compiled packs by the hash of their sources and imports.
+	packs map[string]*cachedPack
+	// sources are the source hashes each cached pack is compiled from.
+	sources map[string][]string
+}
+
+type cachedFile struct {
+	modTime time.Time
+	size    int64
+	key     string
+}
+
+type cachedData struct {
+	cachedFile
+	rows []map[string]string
+}
+
+// cachedPack is a compiled pack and the warnings found compiling it.
+type cachedPack struct {
+	pack     *program.TablePack
+	warnings []string
+}
+
+func newCache() *cache {
+	result := &cache{}
+	result.reset()
+	return result
+}
+
+// reset drops everything cached, the caller holds the lock.
+func (c *cache) reset() {
+	c.files = make(map[string]*cachedFile)
+	c.parsed = make(map[string]*parser.TableFile)
+	c.data = make(map[string]*cachedData)
+	c.packs = make(map[string]*cachedPack)
+	c.sources = make(map[string][]string)
+}
+
+// ClearCache drops every parsed file and compiled pack kept by the compiler.
+func (c *Compiler) ClearCache() {
+	c.cache.mu.Lock()
+	defer c.cache.mu.Unlock()
+	c.cache.reset()
+}
+
+// unchanged returns whether a file still has the modification time and size
+// it had when it was cached.
+func (f *cachedFile) unchanged(info os.FileInfo) bool {
+	return f != nil && f.modTime.Equal(info.ModTime()) && f.size == info.Size()
+}
+
+func newCachedFile(info os.FileInfo, key string) *cachedFile {
+	return &cachedFile{modTime: info.ModTime(), size: info.Size(), key: key}
+}
+
+// fileKey returns the source hash of an unchanged file.
+func (c *cache) fileKey(fname string, info os.FileInfo) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	f := c.files[fname]
+	if !f.unchanged(info) {
+		return "", false
+	}
+	return f.key, true
+}
+
+func (c *cache) setFile(fname string, info os.FileInfo, key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	old := c.files[fname]
+	c.files[fname] = newCachedFile(info, key)
+	if old != nil && old.key != key {
+		c.prune()
+	}
+}
+
+// parse returns the parse tree for code, parsing it if it isn't cached.
+func (c *cache) parse(p *parser.TableFileParser, key string, code string) (*parser.TableFile, error) {
+	c.mu.Lock()
+	parsed, ok := c.parsed[key]
+	c.mu.Unlock()
+	if ok {
+		return parsed, nil
+	}
+	parsed, err := p.Parse(code)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.parsed[key] = parsed
+	return parsed, nil
+}
+
+// parsedFile returns the parse tree of a file that's been parsed before.
+func (c *cache) parsedFile(key string) (*parser.TableFile, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	parsed, ok := c.parsed[key]
+	return parsed, ok
+}
+
+// readData reads a table data file if it changed since it was cached.
+func (c *cache) readData(fname string, name string) (*dataTable, error) {
+	info, err := os.Stat(fname)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	cached, ok := c.data[fname]
+	c.mu.Unlock()
+	if ok && cached.unchanged(info) {
+		return &dataTable{name: name, fname: fname, key: cached.key, rows: cached.rows}, nil
+	}
+	data, err := readTableData(fname, name)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	old := c.data[fname]
+	c.data[fname] = &cachedData{cachedFile: *newCachedFile(info, data.key), rows: data.rows}
+	if old != nil && old.key != data.key {
+		c.prune()
+	}
+	return data, nil
+}
+
+// pack returns a copy of a compiled pack and the warnings found compiling it.
+func (c *cache) pack(key string) (*program.TablePack, []string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cached, ok := c.packs[key]
+	if !ok {
+		return nil, nil, false
+	}
+	return cached.pack.Copy(), cached.warnings, true
+}
+
+// addPack keeps a copy of a compiled pack, so programs don't share state, with
+// the warnings found compiling it. Packs compiled from sources that aren't
+// current files, like strings, aren't kept.
+func (c *cache) addPack(key packKey, pack *program.TablePack, warnings []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	live := c.liveKeys()
+	for _, s := range key.sources {
+		if !live[s] {
+			return
+		}
+	}
+	c.packs[key.key] = &cachedPack{pack: pack.Copy(), warnings: warnings}
+	c.sources[key.key] = key.sources
+}
+
+// liveKeys returns the source hashes of the files read by path, the caller
+// holds the lock.
+func (c *cache) liveKeys() map[string]bool {
+	result := make(map[string]bool, len(c.files)+len(c.data))
+	for _, f := range c.files {
+		result[f.key] = true
+	}
+	for _, d := range c.data {
+		result[d.key] = true
+	}
+	return result
+}
+
+// prune drops parse trees and packs made from sources no file has any more,
+// the caller holds the lock.
+func (c *cache) prune() {
+	live := c.liveKeys()
+	for k := range c.parsed {
+		if !live[k] {
+			delete(c.parsed, k)
+		}
+	}
+	for k, sources := range c.sources {
+		for _, s := range sources {
+			if !live[s] {
+				delete(c.packs, k)
+				delete(c.sources, k)
+				break
+			}
+		}
+	}
+}
+
+// packKey is the cache key of a compiled pack and the source hashes it is
+// compiled from.
+type packKey struct {
+	key     string
+	sources []string
+}
+
+// packKeys returns the cache key of each file's compiled pack. A pack depends
+// on its source and data files and on every pack it imports, directly or not,
+// since tables can extend tables from them.
+func packKeys(files []*readTable) map[*readTable]packKey {
+	byKey := make(map[string]*readTable)
+	for _, f := range files {
+		byKey[f.key] = f
+	}
+	result := make(map[*readTable]packKey)
+	for _, f := range files {
+		seen := map[string]bool{f.key: true}
+		queue := []*readTable{f}
+		parts := make([]string, 0)
+		sources := make([]string, 0)
+		for len(queue) > 0 {
+			t := queue[0]
+			queue = queue[1:]
+			parts = append(parts, fileSignature(t))
+			sources = append(sources, t.key)
+			for _, d := range t.data {
+				sources = append(sources, d.key)
+			}
+			for _, key := range t.keys {
+				if imported, ok := byKey[key]; ok && !seen[key] {
+					seen[key] = true
+					queue = append(queue, imported)
+				}
+			}
+		}
+		// The pack itself stays first, its imports are sorted.
+		sort.Strings(parts[1:])
+		result[f] = packKey{key: makeKey(strings.Join(parts, "\n")), sources: sources}
+	}
+	return result
+}
+
+// fileSignature describes what a file is compiled from: its source, the
+// package names it uses and its table data.
+func fileSignature(f *readTable) string {
+	parts := []string{f.key}
+	for alias, key := range f.keys {
+		parts = append(parts, "import "+alias+"="+key)
+	}
+	for _, d := range f.data {
+		parts = append(parts, "data "+d.name+"="+d.key)
+	}
+	sort.Strings(parts[1:])
+	return strings.Join(parts, " ")
+}
@@ -3,9 +3,14 @@ package compiler
 import (
 	"crypto/md5"
 	"encoding/hex"
+	"fmt"
 	"io/ioutil"
 	"math/rand"
+	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/wingerjc/tableman-golang/pkg/parser"
@@ -13,9 +18,14 @@ import (
 )
 
 // A Compiler that can parse files, string table files, or expressions to executable programs.
+// Parsed files and compiled packs are cached between compilations.
 type Compiler struct {
 	parser     *parser.TableFileParser
 	exprParser *parser.ExpressionParser
+	cache      *cache
+
+	warnMu sync.Mutex
+	warnFn func(string)
 }
 
 // NewCompiler creates a new compiler for use.
@@ -31,9 +41,31 @@ func NewCompiler() (*Compiler, error) {
 	return &Compiler{
 		parser:     p,
 		exprParser: exprParser,
+		cache:      newCache(),
+		warnFn:     func(string) {},
 	}, nil
 }
 
+// SetWarningHandler sets a function called with each warning found while
+// compiling, like dice that can roll past the rows of a table. Warnings are
+// discarded if the handler is nil. Packs taken from the cache give the warnings
+// found when they were compiled again. The handler isn't called concurrently.
+func (c *Compiler) SetWarningHandler(fn func(string)) {
+	if fn == nil {
+		fn = func(string) {}
+	}
+	c.warnMu.Lock()
+	defer c.warnMu.Unlock()
+	c.warnFn = fn
+}
+
+// warn calls the warning handler.
+func (c *Compiler) warn(msg string) {
+	c.warnMu.Lock()
+	defer c.warnMu.Unlock()
+	c.warnFn(msg)
+}
+
 // CompileFile compiles the file with the passed path.
 func (c *Compiler) CompileFile(fileName string) (*program.Program, error) {
 	absolutePath, err := filepath.Abs(fileName)
@@ -61,24 +93,50 @@ func (c *Compiler) CompileString(code string) (*program.Program, error) {
 }
 
 func (c *Compiler) compile(pack *readTable) (*program.Program, error) {
+	files, err := c.loadImports(pack)
+	if err != nil {
+		return nil, err
+	}
+	return c.link(files)
+}
+
+// loadImports loads the files a pack imports and the table data files of each,
+// the pack is first in the result.
+func (c *Compiler) loadImports(pack *readTable) ([]*readTable, error) {
+	// Load every file first so tables can extend tables from any pack.
 	tableq := make([]*readTable, 0)
 	tableq = append(tableq, pack)
-	tableDefs := make(program.TableMap)
-	parsed := pack.parsed
-	first := true
+	files := make([]*readTable, 0)
+	loaded := make(map[string]bool)
 	for len(tableq) > 0 {
 		// pop table
 		t := tableq[0]
 		tableq = tableq[1:]
 
 		// skip processed tables
-		if _, ok := tableDefs[t.key]; ok {
+		if loaded[t.key] {
 			continue
 		}
+		loaded[t.key] = true
+		files = append(files, t)
 		// Setup name <-> key conversion, qualified and non-qualified tables point to this file.
 		keys := make(nameMap)
 		keys[""] = t.key
-		keys[parsed.Header.Name.FullName()] = t.key
+		keys[t.parsed.Header.Name.FullName()] = t.key
+		t.keys = keys
+
+		// Read table data files next to the pack.
+		for _, d := range t.parsed.Header.Data {
+			fname, err := getFileName(t.fname, d.File())
+			if err != nil {
+				return nil, err
+			}
+			data, err := c.cache.readData(fname, d.TableName())
+			if err != nil {
+				return nil, err
+			}
+			t.data = append(t.data, data)
+		}
 
 		// Queue up imports
 		for _, i := range t.parsed.Header.Imports {
@@ -101,7 +159,7 @@ func (c *Compiler) compile(pack *readTable) (*program.Program, error) {
 			}
 
 			// don't add if already enqueued
-			_, queued := tableDefs[tr.key]
+			queued := loaded[tr.key]
 			for _, x := range tableq {
 				queued = queued || (x.key == tr.key)
 			}
@@ -109,16 +167,38 @@ func (c *Compiler) compile(pack *readTable) (*program.Program, error) {
 				tableq = append(tableq, tr)
 			}
 		}
+	}
+	return files, nil
+}
 
-		// compile file
-		pack, err := compileTableFile(t.parsed, t.key, keys)
-		if err != nil {
-			return nil, err
+// link compiles loaded files into a program, the first file is the root pack.
+func (c *Compiler) link(files []*readTable) (*program.Program, error) {
+	tables := newTableResolver(files)
+	tableDefs := make(program.TableMap)
+	cacheKeys := packKeys(files)
+	for i, t := range files {
+		// compile file, unless it was compiled with the same imports before
+		pack, warnings, ok := c.cache.pack(cacheKeys[t].key)
+		if ok {
+			for _, w := range warnings {
+				c.warn(w)
+			}
+		} else {
+			warnings = make([]string, 0)
+			var err error
+			pack, err = compileTableFile(t, tables, func(msg string) {
+				warnings = append(warnings, msg)
+				c.warn(msg)
+			})
+			if err != nil {
+				return nil, err
+			}
+			pack.SetAliases(t.keys)
+			c.cache.addPack(cacheKeys[t], pack, warnings)
 		}
 		tableDefs[t.key] = pack
 		// keep specialtrack of the root pack for execution.
-		if first {
-			first = false
+		if i == 0 {
 			tableDefs[program.RootPack] = pack
 		}
 	}
@@ -137,41 +217,206 @@ func (c *Compiler) CompileExpression(code string) (program.Evallable, error) {
 	return compileExpression(parsed, keys)
 }
 
-func compileTableFile(parsed *parser.TableFile, key string, tableKeys nameMap) (*program.TablePack, error) {
+func compileTableFile(file *readTable, resolver *tableResolver, warn func(string)) (*program.TablePack, error) {
+	parsed := file.parsed
 	tables := make(map[string]*program.Table)
+	variants := make(map[string]map[string]*program.Table)
+	lastVariant := make(map[string]*program.Table)
 	for _, t := range parsed.Tables {
-		compiledTable, err := compileTable(t, tableKeys)
+		compiledTable, err := resolver.table(file, t)
+		if err != nil {
+			return nil, err
+		}
+		if missing := compiledTable.UncoveredDice(); len(missing) > 0 {
+			warn(fmt.Sprintf("table '%s' in '%s' has no rows for dice rolls %s",
+				compiledTable.Name(), parsed.Header.Name.FullName(), joinInts(missing)))
+		}
+		name := compiledTable.Name()
+		lang, isVariant := compiledTable.Tag(program.LangTag)
+		if !isVariant {
+			if _, ok := tables[name]; ok {
+				warn(fmt.Sprintf("table '%s' in '%s' is defined more than once, the last definition is used",
+					name, parsed.Header.Name.FullName()))
+			}
+			tables[name] = compiledTable
+			continue
+		}
+		lang = strings.ToLower(lang)
+		if _, ok := variants[name]; !ok {
+			variants[name] = make(map[string]*program.Table)
+		}
+		lastVariant[name] = compiledTable
+		if _, ok := variants[name][lang]; ok {
+			return nil, fmt.Errorf("table '%s' has more than one '%s' variant", name, lang)
+		}
+		variants[name][lang] = compiledTable
+	}
+	for _, d := range file.data {
+		compiledTable, err := resolver.dataTable(file, d)
 		if err != nil {
 			return nil, err
 		}
-		tables[compiledTable.Name()] = compiledTable
+		if _, ok := tables[d.name]; ok {
+			return nil, fmt.Errorf("table '%s' defined more than once", d.name)
+		}
+		tables[d.name] = compiledTable
 	}
-	return program.NewTablePack(key, parsed.Header.Name.FullName(), tables), nil
+	pack := program.NewTablePack(file.key, parsed.Header.Name.FullName(), tables)
+	for name, langs := range variants {
+		// Without an untagged table the last declared variant is the fallback,
+		// like the last definition of an untagged table is used.
+		if _, ok := tables[name]; !ok {
+			tables[name] = lastVariant[name]
+		}
+		for lang, t := range langs {
+			pack.AddVariant(name, lang, t)
+		}
+	}
+	return pack, nil
 }
 
+// loadFile reads and parses a file, a file that hasn't changed since it was
+// last loaded isn't read again.
 func (c *Compiler) loadFile(fname string) (*readTable, error) {
+	info, err := os.Stat(fname)
+	if err != nil {
+		return nil, err
+	}
+	if key, ok := c.cache.fileKey(fname, info); ok {
+		if parsed, ok := c.cache.parsedFile(key); ok {
+			return &readTable{
+				fname:  fname,
+				parsed: parsed,
+				key:    key,
+			}, nil
+		}
+	}
 	f, err := ioutil.ReadFile(fname)
 	if err != nil {
 		return nil, err
 	}
 	code := string(f)
-	parsed, err := c.parser.Parse(code)
+	key := makeKey(code)
+	parsed, err := c.cache.parse(c.parser, key, code)
 	if err != nil {
 		return nil, err
 	}
+	c.cache.setFile(fname, info, key)
 	return &readTable{
 		fname:  fname,
 		parsed: parsed,
-		key:    makeKey(code),
+		key:    key,
 	}, nil
 }
 
 type nameMap map[string]string
 
+func joinInts(vals []int) string {
+	strs := make([]string, len(vals))
+	for i, v := range vals {
+		strs[i] = strconv.Itoa(v)
+	}
+	return strings.Join(strs, ", ")
+}
+
 type readTable struct {
 	fname  string
 	parsed *parser.TableFile
 	key    string
+	keys   nameMap
+	data   []*dataTable
+}
+
+// tableResolver compiles tables when they're first needed, so a table can be
+// compiled before the pack it's in when another table extends it.
+type tableResolver struct {
+	files     map[string]*readTable
+	compiled  map[*parser.Table]*program.Table
+	resolving map[*parser.Table]bool
+	data      map[*dataTable]*program.Table
+}
+
+func newTableResolver(files []*readTable) *tableResolver {
+	result := &tableResolver{
+		files:     make(map[string]*readTable),
+		compiled:  make(map[*parser.Table]*program.Table),
+		resolving: make(map[*parser.Table]bool),
+		data:      make(map[*dataTable]*program.Table),
+	}
+	for _, f := range files {
+		result.files[f.key] = f
+	}
+	return result
+}
+
+// table compiles a table from the given file, only once.
+func (r *tableResolver) table(file *readTable, t *parser.Table) (*program.Table, error) {
+	if compiled, ok := r.compiled[t]; ok {
+		return compiled, nil
+	}
+	if r.resolving[t] {
+		return nil, fmt.Errorf("table '%s' extends itself", t.Header.Name)
+	}
+	r.resolving[t] = true
+	defer delete(r.resolving, t)
+	compiled, err := compileTable(t, file.keys, func(key string, name string) (*program.Table, error) {
+		return r.lookup(key, name)
+	})
+	if err != nil {
+		return nil, err
+	}
+	r.compiled[t] = compiled
+	return compiled, nil
+}
+
+// dataTable compiles a table from a data file of the given file, only once.
+func (r *tableResolver) dataTable(file *readTable, d *dataTable) (*program.Table, error) {
+	if compiled, ok := r.data[d]; ok {
+		return compiled, nil
+	}
+	compiled, err := compileDataTable(d, file.keys)
+	if err != nil {
+		return nil, err
+	}
+	r.data[d] = compiled
+	return compiled, nil
+}
+
+// lookup finds a table by name in the pack with the given key, the same table
+// the pack has under the name: the last definition without a language tag, or
+// the last variant if there is none. Tables from data files are found last.
+func (r *tableResolver) lookup(key string, name string) (*program.Table, error) {
+	file, ok := r.files[key]
+	if !ok {
+		return nil, fmt.Errorf("no pack with key '%s'", key)
+	}
+	var found *parser.Table
+	for _, t := range file.parsed.Tables {
+		if t.Header.Name != name {
+			continue
+		}
+		if found == nil || !hasTag(t, program.LangTag) || hasTag(found, program.LangTag) {
+			found = t
+		}
+	}
+	if found == nil {
+		for _, d := range file.data {
+			if d.name == name {
+				return r.dataTable(file, d)
+			}
+		}
+		return nil, fmt.Errorf("pack '%s' has no table '%s'", file.parsed.Header.Name.FullName(), name)
+	}
+	return r.table(file, found)
+}
+
+func hasTag(t *parser.Table, key string) bool {
+	for _, tag := range t.Header.Tags {
+		if tag.Key.String() == key {
+			return true
+		}
+	}
+	return false
 }
 
 func makeKey(code string) string {
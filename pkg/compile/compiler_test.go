@@ -1,9 +1,13 @@
 package compiler
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -127,3 +131,834 @@ func TestImportedTableCalls(t *testing.T) {
 	assert.True(result.MatchType(program.StringResult))
 	assert.Equal("2", result.StringVal())
 }
+
+func TestImportedPackName(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	// An imported pack calls its own tables by its full name.
+	pack2 := `TablePack: bar.baz
+
+	TableDef: second
+	{!bar.baz.third()}
+
+	TableDef: third
+	{3}`
+	pack1 := `TablePack: foo
+	Import: f"%s"
+
+	TableDef: first
+	{!bar.baz.second()}`
+	f2Name := filepath.Join(dir, "f2")
+	err := ioutil.WriteFile(f2Name, []byte(pack2), 0644)
+	assert.NoError(err)
+
+	c, err := NewCompiler()
+	assert.NoError(err)
+	prog, err := c.CompileString(fmt.Sprintf(pack1, f2Name))
+	if !assert.NoError(err) {
+		return
+	}
+	e, err := c.CompileExpression(`{ !first() }`)
+	assert.NoError(err)
+	result, err := prog.Eval(e)
+	assert.NoError(err)
+	assert.Equal("3", result.StringVal())
+}
+
+func TestLanguageVariants(t *testing.T) {
+	assert := assert.New(t)
+	c, err := NewCompiler()
+	assert.NoError(err)
+
+	prog, err := c.CompileString(`TablePack: greetings
+
+	TableDef: hello
+	"hello"
+
+	TableDef: hello
+	~ lang: de
+	"hallo"
+
+	TableDef: bye
+	~ lang: fr
+	"au revoir"
+
+	TableDef: bye
+	~ lang: de
+	"tschüss"
+
+	TableDef: grammar-article
+	~ lang: de
+	Katze: "eine"`)
+	assert.NoError(err)
+
+	expr, err := c.CompileExpression(`{ !hello() }`)
+	assert.NoError(err)
+	result, err := prog.Eval(expr)
+	assert.NoError(err)
+	assert.Equal("hello", result.StringVal())
+	result, err = prog.EvalLocale(expr, "de-DE")
+	assert.NoError(err)
+	assert.Equal("hallo", result.StringVal())
+	result, err = prog.EvalLocale(expr, "fr")
+	assert.NoError(err)
+	assert.Equal("hello", result.StringVal())
+
+	// With only variants defined the last one is the default.
+	expr, err = c.CompileExpression(`{ !bye() }`)
+	assert.NoError(err)
+	result, err = prog.Eval(expr)
+	assert.NoError(err)
+	assert.Equal("tschüss", result.StringVal())
+
+	prog.SetLocale("de")
+	expr, err = c.CompileExpression(`{ a(Katze) }`)
+	assert.NoError(err)
+	result, err = prog.Eval(expr)
+	assert.NoError(err)
+	assert.Equal("eine Katze", result.StringVal())
+
+	// Copies keep the locale, web sessions are copies.
+	result, err = prog.Copy().Eval(expr)
+	assert.NoError(err)
+	assert.Equal("eine Katze", result.StringVal())
+
+	// A table defined twice warns and the last definition wins.
+	warnings := make([]string, 0)
+	c.SetWarningHandler(func(w string) { warnings = append(warnings, w) })
+	prog, err = c.CompileString(`TablePack: dupes
+
+	TableDef: hello
+	"hello"
+
+	TableDef: hello
+	"hi"`)
+	assert.NoError(err)
+	assert.Equal([]string{"table 'hello' in 'dupes' is defined more than once, the last definition is used"}, warnings)
+	expr, err = c.CompileExpression(`{ !hello() }`)
+	assert.NoError(err)
+	result, err = prog.Eval(expr)
+	assert.NoError(err)
+	assert.Equal("hi", result.StringVal())
+
+	// A variant used as the default is one table in copies too, drawing from
+	// the default uses up the variant deck.
+	prog, err = c.CompileString(`TablePack: cards
+
+	TableDef: deck
+	~ lang: de
+	"Herz"
+	"Karo"`)
+	assert.NoError(err)
+	prog = prog.Copy()
+	expr, err = c.CompileExpression(`{ !deck(deck) }`)
+	assert.NoError(err)
+	_, err = prog.Eval(expr)
+	assert.NoError(err)
+	expr, err = c.CompileExpression(`{ !deck(deck, remaining) }`)
+	assert.NoError(err)
+	result, err = prog.EvalLocale(expr, "de")
+	assert.NoError(err)
+	assert.Equal(1, result.IntVal())
+}
+
+func TestTableInfo(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+	assert := assert.New(t)
+	dir := t.TempDir()
+	pack1 := `TablePack: foo
+	Import: f"%s" As: Other.Pack
+
+	TableDef: monsters
+	~ source: "Monster Manual"
+	w=2 zombie: "zombie" ~ undead
+	ghoul: "ghoul" ~ undead ~ hungry
+	orc: "orc"
+	"bat"
+
+	TableDef: lookup
+	{ !Other.Pack.second() }
+
+	TableDef: spirits
+	"ghost " [ "pale" | "dark" ] ~ undead
+
+	TableDef: encounter
+	w={@level}: "wolf"
+	w=2 when {gt(@level, 5)}: "dragon"
+	"bat"`
+	pack2 := `TablePack: bar.baz
+
+	TableDef: second
+	{ concat(str(exists("second")), str(exists("monsters")), str(rows("second"))) }`
+
+	f2Name := filepath.Join(dir, "f2")
+	err := ioutil.WriteFile(f2Name, []byte(pack2), 0644)
+	assert.NoError(err)
+	c, err := NewCompiler()
+	assert.NoError(err)
+	prog, err := c.CompileString(fmt.Sprintf(pack1, f2Name))
+	assert.NoError(err)
+
+	eval := func(code string) (*program.ExpressionResult, error) {
+		expr, err := c.CompileExpression(code)
+		assert.NoError(err)
+		return prog.Eval(expr)
+	}
+	testCases := map[string]string{
+		`{ tag("monsters", "source") }`:          "Monster Manual",
+		`{ tag("monsters", "nope") }`:            "",
+		`{ str(rows(monsters)) }`:                "4",
+		`{ labels(monsters) }`:                   "zombie, ghoul, orc",
+		`{ str(weight("monsters")) }`:            "5",
+		`{ str(exists("monsters")) }`:            "1",
+		`{ str(exists("foo.monsters")) }`:        "1",
+		`{ str(exists("Other.Pack.second")) }`:   "1",
+		`{ str(exists("Other.Pack.monsters")) }`: "0",
+		`{ str(exists("nope.monsters")) }`:       "0",
+		`{ str(rows("Other.Pack.second")) }`:     "1",
+		// Weight expressions and conditions are evaluated.
+		`{ @level=3; str(weight(encounter)) }`: "4",
+		`{ @level=7; str(weight(encounter)) }`: "10",
+		// Names in imported tables are found from the imported pack.
+		`{ !lookup() }`: "101",
+	}
+	for code, expected := range testCases {
+		result, err := eval(code)
+		assert.NoError(err, code)
+		assert.Equal(expected, result.StringVal(), code)
+	}
+	for _, code := range []string{`{ rows("nope") }`, `{ tag("nope", "source") }`, `{ weight(3) }`, `{ weight(encounter) }`, `{ @level=-1; weight(encounter) }`} {
+		_, err := eval(code)
+		assert.Error(err, code)
+	}
+
+	// Tag filters only roll tagged rows.
+	for i := 0; i < 20; i++ {
+		result, err := eval(`{ !monsters(roll, tag="undead") }`)
+		assert.NoError(err)
+		assert.Contains([]string{"zombie", "ghoul"}, result.StringVal())
+		result, err = eval(`{ !monsters(weighted, tag=hungry) }`)
+		assert.NoError(err)
+		assert.Equal("ghoul", result.StringVal())
+		result, err = eval(`{ !monsters(tag="undead") }`)
+		assert.NoError(err)
+		assert.Contains([]string{"zombie", "ghoul"}, result.StringVal())
+	}
+	// The filter only applies to the called table.
+	result, err := eval(`{ !spirits(roll, tag="undead") }`)
+	assert.NoError(err)
+	assert.Contains(result.StringVal(), "ghost ")
+	_, err = eval(`{ !monsters(roll, tag="dragon") }`)
+	assert.Error(err)
+	_, err = eval(`{ !monsters(label, orc, tag="undead") }`)
+	assert.Error(err)
+	_, err = c.CompileExpression(`{ !monsters(roll, kind="undead") }`)
+	assert.Error(err)
+	_, err = c.CompileExpression(`{ concat(tag="undead") }`)
+	assert.Error(err)
+}
+
+func TestExtendedTables(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+	assert := assert.New(t)
+	dir := t.TempDir()
+	pack1 := `TablePack: swamp
+	Import: f"%s" As: base
+
+	TableDef: encounters
+	Extends: base.encounters
+	Remove: wolf
+	Reweight: zombie w=5
+	~ region: swamp
+	bandit: "swamp bandit"
+	lizardman: "lizardman"
+
+	TableDef: copy
+	Extends: encounters
+
+	TableDef: names
+	Extends: base.names
+	Default: "nobody"`
+	pack2 := `TablePack: base
+
+	TableDef: encounters
+	~ region: any
+	~ source: "Core"
+	wolf: "wolf"
+	w=2 zombie: "zombie"
+	bandit: "bandit"
+	Default: "nothing"
+
+	TableDef: names
+	["a", "b"]`
+
+	f2Name := filepath.Join(dir, "f2")
+	err := ioutil.WriteFile(f2Name, []byte(pack2), 0644)
+	assert.NoError(err)
+	c, err := NewCompiler()
+	assert.NoError(err)
+	prog, err := c.CompileString(fmt.Sprintf(pack1, f2Name))
+	assert.NoError(err)
+
+	eval := func(code string) string {
+		expr, err := c.CompileExpression(code)
+		if !assert.NoError(err, code) {
+			return ""
+		}
+		result, err := prog.Eval(expr)
+		if !assert.NoError(err, code) {
+			return ""
+		}
+		return result.StringVal()
+	}
+	testCases := map[string]string{
+		`{ labels(encounters) }`:               "zombie, bandit, lizardman",
+		`{ str(rows(encounters)) }`:            "4",
+		`{ str(weight(encounters)) }`:          "8",
+		`{ !encounters(label, bandit) }`:       "swamp bandit",
+		`{ !encounters(label, wolf) }`:         "nothing",
+		`{ tag(encounters, region) }`:          "swamp",
+		`{ tag(encounters, source) }`:          "Core",
+		`{ labels(copy) }`:                     "zombie, bandit, lizardman",
+		`{ labels("base.encounters") }`:        "wolf, zombie, bandit",
+		`{ str(rows(names)) }`:                 "3",
+		`{ !names(label, missing) }`:           "nobody",
+		`{ str(weight("base.encounters")) }`:   "5",
+		`{ !encounters(label, "lizardman") }`:  "lizardman",
+		`{ str(exists("base.names")) }`:        "1",
+		`{ !copy(label, bandit) }`:             "swamp bandit",
+		`{ str(rows("base.names")) }`:          "2",
+		`{ tag("base.encounters", "region") }`: "any",
+		`{ str(exists("base.lizardman")) }`:    "0",
+		`{ !encounters(index, 1) }`:            "nothing",
+		`{ !names(index, 2) }`:                 "b",
+	}
+	for code, expected := range testCases {
+		assert.Equal(expected, eval(code), code)
+	}
+
+	// Variants can extend the default table of the same name.
+	prog, err = c.CompileString(`TablePack: greetings
+
+	TableDef: hello
+	hi: "hello"
+	bye: "goodbye"
+
+	TableDef: hello
+	Extends: hello
+	~ lang: de
+	hi: "hallo"`)
+	assert.NoError(err)
+	expr, err := c.CompileExpression(`{ concat(!hello(label, hi), " ", !hello(label, bye)) }`)
+	assert.NoError(err)
+	result, err := prog.EvalLocale(expr, "de")
+	assert.NoError(err)
+	assert.Equal("hallo goodbye", result.StringVal())
+	result, err = prog.Eval(expr)
+	assert.NoError(err)
+	assert.Equal("hello goodbye", result.StringVal())
+
+	// Extending a table defined more than once uses the definition the pack
+	// keeps, the last one without a language tag or else the last variant.
+	prog, err = c.CompileString(`TablePack: dupes
+
+	TableDef: hello
+	~ lang: de
+	hi: "hallo"
+
+	TableDef: hello
+	hi: "hello"
+
+	TableDef: hello
+	hi: "hi"
+
+	TableDef: hello
+	~ lang: fr
+	hi: "salut"
+
+	TableDef: bye
+	~ lang: de
+	bye: "tschüss"
+
+	TableDef: bye
+	~ lang: fr
+	bye: "au revoir"
+
+	TableDef: greet
+	Extends: hello
+
+	TableDef: leave
+	Extends: bye`)
+	assert.NoError(err)
+	expr, err = c.CompileExpression(`{ concat(!greet(label, hi), " ", !leave(label, bye), " ", !hello(label, hi), " ", !bye(label, bye)) }`)
+	assert.NoError(err)
+	result, err = prog.Eval(expr)
+	assert.NoError(err)
+	assert.Equal("hi au revoir hi au revoir", result.StringVal())
+
+	badPacks := []string{
+		// Extending a missing table.
+		`TablePack: bad
+
+		TableDef: a
+		Extends: nope`,
+		// Removing a missing row.
+		`TablePack: bad
+
+		TableDef: a
+		x: "x"
+
+		TableDef: b
+		Extends: a
+		Remove: y`,
+		// Reweighting a missing row.
+		`TablePack: bad
+
+		TableDef: a
+		x: "x"
+
+		TableDef: b
+		Extends: a
+		Reweight: y w=2`,
+		// Loops.
+		`TablePack: bad
+
+		TableDef: a
+		Extends: b
+
+		TableDef: b
+		Extends: a`,
+		// Removing without extending.
+		`TablePack: bad
+
+		TableDef: a
+		Remove: x
+		x: "x"`,
+		// No rows.
+		`TablePack: bad
+
+		TableDef: a
+		x: "x"
+
+		TableDef: b
+		Extends: a
+		Remove: x`,
+	}
+	for _, code := range badPacks {
+		_, err := c.CompileString(code)
+		assert.Error(err, code)
+	}
+}
+
+func TestTableData(t *testing.T) {
+	if testing.Short() {
+		t.Skip()
+	}
+	assert := assert.New(t)
+	dir := t.TempDir()
+	files := map[string]string{
+		"monsters.csv": "Label,Weight,Count,Range,Value\n" +
+			"orc,2,,1-3,\"an orc, angry\"\n" +
+			",,,,\n" +
+			"goblins,,4,\"4, 6\",{words(2)} goblins\n",
+		"loot.tsv": "label\tvalue\ngold\t{concat(\"gold \", \"{coins}\")}\n",
+		"npcs.json": `[{"label": "smith", "value": "the smith", "weight": 3},
+			{"value": "a {!monsters(label, orc)}", "range": 2}]`,
+		"weather.yaml": "- label: rain\n  value: rain\n  weight: 2\n" +
+			"- label: sun\n  value: \"{upper(sun)}\"\n",
+	}
+	for name, data := range files {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0644)
+		assert.NoError(err)
+	}
+	pack := fmt.Sprintf(`TablePack: data
+	TableData: f"%[1]s/monsters.csv"
+	TableData: f"%[1]s/loot.tsv" As: treasure
+	TableData: f"%[1]s/npcs.json"
+	TableData: f"%[1]s/weather.yaml"
+
+	TableDef: base
+	Extends: weather
+	fog: "fog"`, dir)
+	c, err := NewCompiler()
+	assert.NoError(err)
+	prog, err := c.CompileString(pack)
+	if !assert.NoError(err) {
+		return
+	}
+
+	eval := func(code string) string {
+		expr, err := c.CompileExpression(code)
+		if !assert.NoError(err, code) {
+			return ""
+		}
+		result, err := prog.Eval(expr)
+		if !assert.NoError(err, code) {
+			return ""
+		}
+		return result.StringVal()
+	}
+	testCases := map[string]string{
+		`{ !monsters(label, orc) }`:           "an orc, angry",
+		`{ !monsters(index, 6) }`:             "two goblins",
+		`{ str(weight(monsters)) }`:           "3",
+		`{ str(!monsters(deck, remaining)) }`: "5",
+		`{ !treasure(label, gold) }`:          "gold {coins}",
+		`{ !npcs(index, 2) }`:                 "a an orc, angry",
+		`{ str(weight(npcs)) }`:               "4",
+		`{ !weather(label, sun) }`:            "SUN",
+		`{ labels(base) }`:                    "rain, sun, fog",
+	}
+	for code, expected := range testCases {
+		assert.Equal(expected, eval(code), code)
+	}
+
+	badFiles := map[string]string{
+		"bad.csv":  "label,notes\na,b\n",
+		"bad.tsv":  "label\tvalue\tweight\na\tb\t0\n",
+		"bad.json": `[{"value": "a {!b("}]`,
+		"bad.yaml": "- value: a\n  range: 3-1\n",
+		// Crashed yaml.v3 before v3.0.1.
+		"crash.yaml": "0: [:!00 \xef",
+		"bad.txt":    "value\na\n",
+		"none.csv":   "label,value\n",
+	}
+	for name, data := range badFiles {
+		fname := filepath.Join(dir, name)
+		err := ioutil.WriteFile(fname, []byte(data), 0644)
+		assert.NoError(err)
+		_, err = c.CompileString(fmt.Sprintf("TablePack: bad\n\tTableData: f\"%s\"", fname))
+		assert.Error(err, name)
+	}
+	_, err = c.CompileString(fmt.Sprintf("TablePack: bad\n\tTableData: f\"%s/loot.tsv\"\n\n\tTableDef: loot\n\t\"x\"", dir))
+	assert.Error(err)
+}
+
+func TestArtifact(t *testing.T) {
+	assert := assert.New(t)
+	c, err := NewCompiler()
+	assert.NoError(err)
+
+	// The d20srd treasure tree gives the same rolls from source and artifact.
+	fname := filepath.Join("..", "..", "example", "d20srd", "treasure", "treasure.tman")
+	target := strings.TrimSuffix(fname, filepath.Ext(fname)) + ArtifactExt
+	data, err := c.Build(fname, target)
+	assert.NoError(err)
+	fromSource, err := c.CompileFile(fname)
+	assert.NoError(err)
+	fromArtifact, err := c.LoadArtifact(data, filepath.Dir(target))
+	assert.NoError(err)
+	assert.Equal(fromSource.PackCount(), fromArtifact.PackCount())
+	expr, err := c.CompileExpression("{!treasure()}")
+	assert.NoError(err)
+	fromSource.SetRandom(program.NewSeededRandSource(7))
+	fromArtifact.SetRandom(program.NewSeededRandSource(7))
+	for level := 1; level <= 20; level++ {
+		vars := map[string]*program.ExpressionResult{"level": program.NewIntResult(level)}
+		want, wantErr := fromSource.EvalWith(expr, vars)
+		got, err := fromArtifact.EvalWith(expr, vars)
+		assert.Equal(wantErr, err)
+		if err == nil {
+			assert.Equal(want.StringVal(), got.StringVal())
+		}
+	}
+
+	// Every kind of table and expression is the same after loading the artifact.
+	dir := t.TempDir()
+	packName := filepath.Join(dir, "pack.tman")
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "base.tman"), []byte(`TablePack: base
+TableDef: beasts
+wolf: "wolf"
+bear: "bear"`), 0644))
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "names.csv"), []byte("value\nAda\n"), 0644))
+	assert.NoError(ioutil.WriteFile(packName, []byte(`TablePack: p
+Import: f"./base.tman" As: base
+TableData: f"./names.csv"
+
+TableDef: door
+"a " [ "red" | w=3 "blue" | "green" ] " door"
+
+TableDef: cards
+~ auto-reshuffle: all
+[A: "Ace", K: "King"][" of "][C: "Clubs", w=2 H: "Hearts"]
+
+TableDef: weather
+~ dice: 2d6
+2-6: "rain"
+7: "clouds"
+8-12: "sun"
+
+TableDef: greek
+~ markov: 2
+"Achilles"
+"Agamemnon"
+"Andromache"
+"Ariadne"
+
+TableDef: encounter
+w={@level}: "a wolf"
+w=2 when {gt(@level, 5)}: "a dragon"
+"zombie" ~ undead
+
+TableDef: beasts
+Extends: base.beasts
+Remove: bear
+lion: "lion"
+
+TableDef: greeting
+~ lang: de
+"Hallo"
+
+TableDef: all
+{ concat(!door(), "|", !cards(deck), "|", !weather(dice), "|", !greek(), "|",
+  !encounter(), "|", !encounter(roll, tag="undead"), "|", !beasts(), "|",
+  !greeting(), "|", !names(), "|", [ "x" | "y" ], "|", 4d6h3.str?, "|",
+  repeat(2, 1d6.str?, ","), "|", words(add(@level, 2))) }`), 0644))
+	data, err = c.Build(packName, filepath.Join(dir, "pack"+ArtifactExt))
+	assert.NoError(err)
+	assert.NotContains(string(data), dir)
+	fromSource, err = c.CompileFile(packName)
+	assert.NoError(err)
+	fromArtifact, err = c.LoadArtifact(data, dir)
+	assert.NoError(err)
+	expr, err = c.CompileExpression("{!all()}")
+	assert.NoError(err)
+	fromSource.SetRandom(program.NewSeededRandSource(11))
+	fromArtifact.SetRandom(program.NewSeededRandSource(11))
+	for level := 1; level <= 8; level++ {
+		vars := map[string]*program.ExpressionResult{"level": program.NewIntResult(level)}
+		for _, locale := range []string{"", "de"} {
+			fromSource.SetLocale(locale)
+			fromArtifact.SetLocale(locale)
+			want, err := fromSource.EvalWith(expr, vars)
+			assert.NoError(err)
+			got, err := fromArtifact.EvalWith(expr, vars)
+			if assert.NoError(err) && want != nil {
+				assert.Equal(want.StringVal(), got.StringVal())
+			}
+		}
+	}
+
+	// Artifacts written to another directory with -out find their sources.
+	outName := filepath.Join(t.TempDir(), "out", "pack"+ArtifactExt)
+	assert.NoError(os.MkdirAll(filepath.Dir(outName), 0755))
+	data, err = c.Build(packName, outName)
+	assert.NoError(err)
+	assert.NoError(ioutil.WriteFile(outName, data, 0644))
+	prog, err := c.LoadArtifactFile(outName)
+	assert.NoError(err)
+	expr, err = c.CompileExpression("{!names()}")
+	assert.NoError(err)
+	result, err := prog.Eval(expr)
+	assert.NoError(err)
+	assert.Equal("Ada", result.StringVal())
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "names.csv"), []byte("value\nBo\n"), 0644))
+	_, err = c.LoadArtifactFile(outName)
+	assert.ErrorIs(err, ErrStaleArtifact)
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "base.tman"), []byte("TablePack: base"), 0644))
+	_, err = c.LoadArtifact(data, filepath.Dir(outName))
+	assert.ErrorIs(err, ErrStaleArtifact)
+
+	// Without a directory the sources aren't checked, the artifact has the
+	// program as it was built.
+	prog, err = c.LoadArtifact(data, "")
+	assert.NoError(err)
+	result, err = prog.Eval(expr)
+	assert.NoError(err)
+	assert.Equal("Ada", result.StringVal())
+
+	// Moving the tree keeps the artifact usable, missing sources are warnings.
+	dir = t.TempDir()
+	packName = filepath.Join(dir, "pack.tman")
+	assert.NoError(ioutil.WriteFile(packName, []byte(`TablePack: p
+TableData: f"./names.csv"
+TableDef: t
+{!names()}`), 0644))
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "names.csv"), []byte("value\nAda\n"), 0644))
+	data, err = c.Build(packName, filepath.Join(dir, "pack"+ArtifactExt))
+	assert.NoError(err)
+	moved := filepath.Join(t.TempDir(), "moved")
+	assert.NoError(os.Rename(dir, moved))
+	artifactName := filepath.Join(moved, "pack"+ArtifactExt)
+	assert.NoError(ioutil.WriteFile(artifactName, data, 0644))
+	warnings := make([]string, 0)
+	c.SetWarningHandler(func(msg string) {
+		warnings = append(warnings, msg)
+	})
+	_, err = c.LoadArtifactFile(artifactName)
+	assert.NoError(err)
+	assert.Empty(warnings)
+	assert.NoError(os.Remove(filepath.Join(moved, "names.csv")))
+	prog, err = c.LoadArtifactFile(artifactName)
+	assert.NoError(err)
+	assert.Len(warnings, 1)
+	assert.Contains(warnings[0], "names.csv")
+	expr, err = c.CompileExpression("{!t()}")
+	assert.NoError(err)
+	result, err = prog.Eval(expr)
+	assert.NoError(err)
+	assert.Equal("Ada", result.StringVal())
+
+	// Other artifact and program versions are stale.
+	header := fmt.Sprintf("artifact %d %d\n", ArtifactVersion, program.EncodingVersion)
+	assert.Contains(string(data), header)
+	_, err = c.LoadArtifact(bytes.Replace(data, []byte(header), []byte(fmt.Sprintf("artifact %d %d\n", ArtifactVersion-1, program.EncodingVersion)), 1), "")
+	assert.ErrorIs(err, ErrStaleArtifact)
+	_, err = c.LoadArtifact(bytes.Replace(data, []byte(header), []byte(fmt.Sprintf("artifact %d %d\n", ArtifactVersion, program.EncodingVersion+1)), 1), "")
+	assert.ErrorIs(err, ErrStaleArtifact)
+	_, err = c.LoadArtifact([]byte("tableman-artifact 1\n{}"), "")
+	assert.ErrorIs(err, ErrStaleArtifact)
+	_, err = c.LoadArtifact([]byte("TablePack: p"), "")
+	assert.Error(err)
+
+	dir = t.TempDir()
+	_, err = c.Build(filepath.Join(dir, "missing.tman"), filepath.Join(dir, "missing"+ArtifactExt))
+	assert.Error(err)
+}
+
+func TestCompilerCache(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	write := func(name string, code string) string {
+		fname := filepath.Join(dir, name)
+		assert.NoError(ioutil.WriteFile(fname, []byte(code), 0644))
+		return fname
+	}
+	write("util.tman", `TablePack: util
+TableData: f"./names.csv"
+TableDef: cards
+c=2: "ace"`)
+	write("names.csv", "value\nAda\n")
+	aName := write("a.tman", `TablePack: a
+Import: f"./util.tman"
+TableDef: a
+{!util.cards(deck)}
+TableDef: left
+{!util.cards(deck, remaining)}`)
+	bName := write("b.tman", `TablePack: b
+Import: f"./util.tman"
+TableDef: b
+{!util.names()}`)
+	c, err := NewCompiler()
+	assert.NoError(err)
+	eval := func(prog *program.Program, code string) string {
+		expr, err := c.CompileExpression(code)
+		assert.NoError(err)
+		result, err := prog.Eval(expr)
+		assert.NoError(err)
+		return result.StringVal()
+	}
+
+	// The shared import is parsed and compiled once.
+	a, err := c.CompileFile(aName)
+	assert.NoError(err)
+	b, err := c.CompileFile(bName)
+	assert.NoError(err)
+	assert.Len(c.cache.parsed, 3)
+	assert.Len(c.cache.packs, 3)
+	assert.Equal("Ada", eval(b, "{!b()}"))
+
+	// Programs don't share the state of cached packs.
+	assert.Equal("ace", eval(a, "{!a()}"))
+	again, err := c.CompileFile(aName)
+	assert.NoError(err)
+	assert.Equal("2", eval(again, "{!left()}"))
+	assert.Equal("1", eval(a, "{!left()}"))
+
+	// Changed source and data files are read again.
+	write("names.csv", "value\nBo\n")
+	b, err = c.CompileFile(bName)
+	assert.NoError(err)
+	assert.Equal("Bo", eval(b, "{!b()}"))
+	write("util.tman", `TablePack: util
+TableData: f"./names.csv"
+TableDef: cards
+c=2: "king"`)
+	a, err = c.CompileFile(aName)
+	assert.NoError(err)
+	assert.Equal("king", eval(a, "{!a()}"))
+
+	// Entries for old sources are dropped, b's pack used the old util.
+	assert.Len(c.cache.parsed, 3)
+	assert.Len(c.cache.packs, 2)
+	b, err = c.CompileFile(bName)
+	assert.NoError(err)
+	assert.Equal("Bo", eval(b, "{!b()}"))
+	assert.Len(c.cache.parsed, 3)
+	assert.Len(c.cache.packs, 3)
+
+	// Strings aren't cached, only the files they import.
+	for i := 0; i < 3; i++ {
+		_, err = c.CompileString(fmt.Sprintf("TablePack: s%d\nImport: f\"%s\"\nTableDef: s\n\"x\"", i, filepath.ToSlash(bName)))
+		assert.NoError(err)
+	}
+	assert.Len(c.cache.parsed, 3)
+	assert.Len(c.cache.packs, 3)
+
+	// The cache can be used from many goroutines.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(fname string) {
+			defer wg.Done()
+			_, err := c.CompileFile(fname)
+			assert.NoError(err)
+		}([]string{aName, bName}[i%2])
+	}
+	wg.Wait()
+
+	// Packs from the cache give the warnings found compiling them again.
+	warnings := make([]string, 0)
+	c.SetWarningHandler(func(msg string) {
+		warnings = append(warnings, msg)
+	})
+	diceName := write("dice.tman", `TablePack: dice
+TableDef: weather
+~ dice: 2d6
+2-11: "rain"`)
+	_, err = c.CompileFile(diceName)
+	assert.NoError(err)
+	_, err = c.CompileFile(diceName)
+	assert.NoError(err)
+	assert.Len(warnings, 2)
+	assert.Contains(warnings[0], "12")
+	assert.Equal(warnings[0], warnings[1])
+
+	c.ClearCache()
+	assert.Empty(c.cache.packs)
+}
+
+func TestConcurrentCompile(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	fname := filepath.Join(dir, "many.tman")
+	assert.NoError(ioutil.WriteFile(fname, []byte("TablePack: many\nTableDef: many\n"+
+		strings.Repeat("{ concat(str(1d6?), !many(label, x)) }\n", 200)), 0644))
+	c, err := NewCompiler()
+	assert.NoError(err)
+
+	// Parse trees that are cached but not compiled yet can be compiled by many
+	// goroutines at once, run with -race.
+	var wg sync.WaitGroup
+	for round := 0; round < 5; round++ {
+		c.ClearCache()
+		_, err = c.loadFile(fname)
+		assert.NoError(err)
+		start := make(chan bool)
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				_, err := c.CompileFile(fname)
+				assert.NoError(err)
+			}()
+		}
+		close(start)
+		wg.Wait()
+	}
+}
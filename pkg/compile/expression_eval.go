@@ -43,6 +43,8 @@ func compileValueExpr(node *parser.ValueExpr, packKeys nameMap) (program.Evallab
 		return compileTableCall(node, packKeys)
 	case parser.RollExprT:
 		return compileRollExpr(node.Roll)
+	case parser.InlineExprT:
+		return compileInlineTable(node.Inline, packKeys)
 	}
 	return nil, fmt.Errorf("unkown expression type %s", node.GetStringType())
 }
@@ -78,7 +80,26 @@ func compileRollExpr(node *parser.Roll) (program.Evallable, error) {
 }
 
 func compileTableCall(node *parser.ValueExpr, packKeys nameMap) (program.Evallable, error) {
-	params, err := getParams(node, packKeys)
+	var tag program.Evallable
+	positional := make([]*parser.CallParam, 0, len(node.Call.Params))
+	for _, x := range node.Call.Params {
+		if x.Name == nil {
+			positional = append(positional, x)
+			continue
+		}
+		if *x.Name != "tag" {
+			return nil, fmt.Errorf("table calls have no parameter named '%s'", *x.Name)
+		}
+		if tag != nil {
+			return nil, fmt.Errorf("table call parameter 'tag' set more than once")
+		}
+		expr, err := compileValueExpr(x.Value, packKeys)
+		if err != nil {
+			return nil, err
+		}
+		tag = expr
+	}
+	params, err := compileParams(positional, packKeys)
 	if err != nil {
 		return nil, err
 	}
@@ -87,18 +108,28 @@ func compileTableCall(node *parser.ValueExpr, packKeys nameMap) (program.Evallab
 	if !ok {
 		return nil, fmt.Errorf("could not find package '%s' did you forget or mistype an import?", packName)
 	}
-	return program.NewTableCall(
+	return program.NewFilteredTableCall(
 		key,
 		packName,
 		node.Call.Name.TableName(),
 		params,
+		tag,
 	)
 }
 
 func getParams(node *parser.ValueExpr, packKeys nameMap) ([]program.Evallable, error) {
-	res := make([]program.Evallable, 0, len(node.Call.Params))
 	for _, x := range node.Call.Params {
-		expr, err := compileValueExpr(x, packKeys)
+		if x.Name != nil {
+			return nil, fmt.Errorf("function '%s' has no parameter named '%s'", node.Call.Name.FullName(), *x.Name)
+		}
+	}
+	return compileParams(node.Call.Params, packKeys)
+}
+
+func compileParams(params []*parser.CallParam, packKeys nameMap) ([]program.Evallable, error) {
+	res := make([]program.Evallable, 0, len(params))
+	for _, x := range params {
+		expr, err := compileValueExpr(x.Value, packKeys)
 		if err != nil {
 			return nil, err
 		}
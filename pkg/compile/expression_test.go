@@ -85,7 +85,7 @@ func TestTableCallCompile(t *testing.T) {
 	c=2 15 first: "red"`
 	tParsed, err := tParser.Parse(tCode)
 	assert.NoError(err)
-	table, err := compileTable(tParsed, packKeys)
+	table, err := compileTable(tParsed, packKeys, nil)
 	assert.NoError(err)
 
 	tableMap := make(map[string]*program.Table)
@@ -150,6 +150,9 @@ func TestTableCallCompile(t *testing.T) {
 	assertTableCallRuntimeErr(code, ctx)
 
 	code = `{ !foo.color(deck, shuffle, nope) }`
+	assertTableCallRuntimeErr(code, ctx)
+
+	code = `{ !foo.color(deck, draw, 1, 2) }`
 	assertTableCallCompileErr(code, ctx)
 
 	code = `{ !foo.color(4) }`
@@ -196,6 +196,30 @@ func TestLowerFunc(t *testing.T) {
 	assertRuntimeFail(expr, p, assert)
 }
 
+func TestCapitalizeFunc(t *testing.T) {
+	p, assert := setupParser(t)
+
+	expr := `{ capitalize("élan vital") }`
+	result := shouldParseExpression(expr, p, assert)
+	assertString("Élan vital", result, assert)
+
+	expr = `{ capitalize_all("the old  owl") }`
+	result = shouldParseExpression(expr, p, assert)
+	assertString("The Old  Owl", result, assert)
+
+	expr = `{ capitalize("") }`
+	result = shouldParseExpression(expr, p, assert)
+	assertString("", result, assert)
+
+	// compiler error max 1 param
+	expr = `{ capitalize(foo, bar) }`
+	assertCompFail(expr, p, assert)
+
+	// runtime error, string only
+	expr = `{ capitalize_all( 7 ) }`
+	assertRuntimeFail(expr, p, assert)
+}
+
 func TestToStrFunc(t *testing.T) {
 	p, assert := setupParser(t)
 
@@ -463,6 +487,23 @@ func TestAndFunc(t *testing.T) {
 
 	expr = `{ and( foo, 0) }`
 	assertRuntimeFail(expr, p, assert)
+
+	expr = `{ and(1, foo) }`
+	assertRuntimeFail(expr, p, assert)
+
+	// Stops at the first false value without evaluating the rest.
+	expr = `{ and(0, foo) }`
+	result = shouldParseExpression(expr, p, assert)
+	assertInt(0, result, assert)
+
+	random := program.NewTestRandSource(4)
+	ctx := program.NewRootExecutionContext().SetRandom(random)
+	expr = `{ and(0, eq(1d6?, 4)) }`
+	result = shouldParseExprWithContext(expr, p, ctx, assert)
+	assertInt(0, result, assert)
+	expr = `{ 1d6? }`
+	result = shouldParseExprWithContext(expr, p, ctx, assert)
+	assertInt(4, result, assert)
 }
 
 func TestOrFunc(t *testing.T) {
@@ -490,8 +531,22 @@ func TestOrFunc(t *testing.T) {
 	expr = `{ or(1) }`
 	assertCompFail(expr, p, assert)
 
-	expr = `{ or(1, foo) }`
+	expr = `{ or(0, foo) }`
 	assertRuntimeFail(expr, p, assert)
+
+	// Stops at the first true value without evaluating the rest.
+	expr = `{ or(1, foo) }`
+	result = shouldParseExpression(expr, p, assert)
+	assertInt(1, result, assert)
+
+	random := program.NewTestRandSource(4)
+	ctx := program.NewRootExecutionContext().SetRandom(random)
+	expr = `{ or(1, eq(1d6?, 4)) }`
+	result = shouldParseExprWithContext(expr, p, ctx, assert)
+	assertInt(1, result, assert)
+	expr = `{ 1d6? }`
+	result = shouldParseExprWithContext(expr, p, ctx, assert)
+	assertInt(4, result, assert)
 }
 
 func TestNotFunc(t *testing.T) {
@@ -549,3 +604,381 @@ func TestIfFunc(t *testing.T) {
 	expr = `{ if(asdf, true, false) }`
 	assertRuntimeFail(expr, p, assert)
 }
+
+func TestArticleFunc(t *testing.T) {
+	p, assert := setupParser(t)
+
+	expr := `{ a(apple) }`
+	result := shouldParseExpression(expr, p, assert)
+	assertString("an apple", result, assert)
+
+	expr = `{ an(sword) }`
+	result = shouldParseExpression(expr, p, assert)
+	assertString("a sword", result, assert)
+
+	expr = `{ a("hour glass") }`
+	result = shouldParseExpression(expr, p, assert)
+	assertString("an hour glass", result, assert)
+
+	expr = `{ a(unicorn) }`
+	result = shouldParseExpression(expr, p, assert)
+	assertString("a unicorn", result, assert)
+
+	expr = `{ a(uninformed) }`
+	result = shouldParseExpression(expr, p, assert)
+	assertString("an uninformed", result, assert)
+
+	expr = `{ a(onerous) }`
+	result = shouldParseExpression(expr, p, assert)
+	assertString("an onerous", result, assert)
+
+	expr = `{ a(one) }`
+	result = shouldParseExpression(expr, p, assert)
+	assertString("a one", result, assert)
+
+	expr = `{ a("one-eyed giant") }`
+	result = shouldParseExpression(expr, p, assert)
+	assertString("a one-eyed giant", result, assert)
+
+	expr = `{ a(university) }`
+	result = shouldParseExpression(expr, p, assert)
+	assertString("a university", result, assert)
+
+	expr = `{ a() }`
+	assertCompFail(expr, p, assert)
+
+	expr = `{ a(5) }`
+	assertRuntimeFail(expr, p, assert)
+}
+
+func TestPluralFunc(t *testing.T) {
+	p, assert := setupParser(t)
+
+	expr := `{ plural(sword) }`
+	result := shouldParseExpression(expr, p, assert)
+	assertString("swords", result, assert)
+
+	expr = `{ plural(sword, 1) }`
+	result = shouldParseExpression(expr, p, assert)
+	assertString("sword", result, assert)
+
+	expr = `{ plural(box, 3) }`
+	result = shouldParseExpression(expr, p, assert)
+	assertString("boxes", result, assert)
+
+	expr = `{ plural(ruby, 2) }`
+	result = shouldParseExpression(expr, p, assert)
+	assertString("rubies", result, assert)
+
+	expr = `{ plural(Wolf, 2) }`
+	result = shouldParseExpression(expr, p, assert)
+	assertString("Wolves", result, assert)
+
+	expr = `{ plural("potion of healing", 2) }`
+	result = shouldParseExpression(expr, p, assert)
+	assertString("potions of healing", result, assert)
+
+	expr = `{ plural("old man") }`
+	result = shouldParseExpression(expr, p, assert)
+	assertString("old men", result, assert)
+
+	expr = `{ plural(sword, 1, 2) }`
+	assertCompFail(expr, p, assert)
+
+	expr = `{ plural(sword, two) }`
+	assertRuntimeFail(expr, p, assert)
+}
+
+func TestOrdinalFunc(t *testing.T) {
+	p, assert := setupParser(t)
+
+	testCases := map[string]string{
+		`{ ordinal(1) }`:   "1st",
+		`{ ordinal(2) }`:   "2nd",
+		`{ ordinal(3) }`:   "3rd",
+		`{ ordinal(4) }`:   "4th",
+		`{ ordinal(11) }`:  "11th",
+		`{ ordinal(13) }`:  "13th",
+		`{ ordinal(22) }`:  "22nd",
+		`{ ordinal(101) }`: "101st",
+	}
+	for expr, expect := range testCases {
+		result := shouldParseExpression(expr, p, assert)
+		assertString(expect, result, assert)
+	}
+
+	expr := `{ ordinal(first) }`
+	assertRuntimeFail(expr, p, assert)
+}
+
+func TestWordsFunc(t *testing.T) {
+	p, assert := setupParser(t)
+
+	testCases := map[string]string{
+		`{ words(0) }`:       "zero",
+		`{ words(7) }`:       "seven",
+		`{ words(42) }`:      "forty-two",
+		`{ words(90) }`:      "ninety",
+		`{ words(105) }`:     "one hundred five",
+		`{ words(-13) }`:     "minus thirteen",
+		`{ words(3000021) }`: "three million twenty-one",
+		// The smallest int has no positive int of the same size.
+		`{ words(-9223372036854775808) }`: "minus nine billion two hundred twenty-three million " +
+			"three hundred seventy-two thousand thirty-six billion eight hundred fifty-four million " +
+			"seven hundred seventy-five thousand eight hundred eight",
+	}
+	for expr, expect := range testCases {
+		result := shouldParseExpression(expr, p, assert)
+		assertString(expect, result, assert)
+	}
+
+	expr := `{ words(seven) }`
+	assertRuntimeFail(expr, p, assert)
+}
+
+func TestListEnFunc(t *testing.T) {
+	p, assert := setupParser(t)
+
+	expr := `{ list_en(a) }`
+	result := shouldParseExpression(expr, p, assert)
+	assertString("a", result, assert)
+
+	expr = `{ list_en(a, b) }`
+	result = shouldParseExpression(expr, p, assert)
+	assertString("a and b", result, assert)
+
+	expr = `{ list_en(a, b, 3) }`
+	result = shouldParseExpression(expr, p, assert)
+	assertString("a, b and 3", result, assert)
+
+	expr = `{ list_en() }`
+	assertCompFail(expr, p, assert)
+}
+
+func TestGrammarOverrideTables(t *testing.T) {
+	assert := assert.New(t)
+	c, err := NewCompiler()
+	assert.NoError(err)
+
+	prog, err := c.CompileString(`TablePack: house
+
+	TableDef: grammar-plural
+	sword: "swordses"
+
+	TableDef: grammar-article
+	unicorn: "an"`)
+	assert.NoError(err)
+
+	expr, err := c.CompileExpression(`{ concat(plural(sword), " ", a(unicorn)) }`)
+	assert.NoError(err)
+	result, err := prog.Eval(expr)
+	assert.NoError(err)
+	assertString("swordses an unicorn", result, assert)
+}
+
+func TestGermanGrammarFuncs(t *testing.T) {
+	p, assert := setupParser(t)
+	newCtx := func() *program.ExecutionContext {
+		return program.NewRootExecutionContext().SetLocale("de-AT")
+	}
+
+	testCases := map[string]string{
+		`{ ordinal(3) }`:           "3.",
+		`{ words(21) }`:            "einundzwanzig",
+		`{ words(101) }`:           "einhunderteins",
+		`{ words(2000000) }`:       "zwei Millionen",
+		`{ plural(Schwert, 2) }`:   "Schwerter",
+		`{ plural(Katze, 2) }`:     "Katzen",
+		`{ list(a, b, c) }`:        "a, b und c",
+		`{ list_en(a, b, c) }`:     "a, b and c",
+		`{ a(Apfel) }`:             "ein Apfel",
+		`{ plural(Schwert, 1) }`:   "Schwert",
+		`{ plural("alter Mann") }`: "alter Männer",
+		// The smallest int has no positive int of the same size.
+		`{ words(-9223372036854775808) }`: "minus neun Millionen " +
+			"zweihundertdreiundzwanzigtausenddreihundertzweiundsiebzig Millionen " +
+			"sechsunddreißigtausendachthundertvierundfünfzig Millionen " +
+			"siebenhundertfünfundsiebzigtausendachthundertacht",
+	}
+	for expr, expect := range testCases {
+		result := shouldParseExprWithContext(expr, p, newCtx(), assert)
+		assertString(expect, result, assert)
+	}
+}
+
+func TestRepeatFunc(t *testing.T) {
+	p, assert := setupParser(t)
+
+	expr := `{ repeat(3, "ab") }`
+	result := shouldParseExpression(expr, p, assert)
+	assertString("ababab", result, assert)
+
+	expr = `{ repeat(3, 1d6?, ", ") }`
+	random := program.NewTestRandSource(2, 5, 1)
+	result = shouldParseExprWithContext(expr, p, program.NewRootExecutionContext().SetRandom(random), assert)
+	assertString("2, 5, 1", result, assert)
+
+	expr = `{ repeat(0, "ab") }`
+	result = shouldParseExpression(expr, p, assert)
+	assertString("", result, assert)
+
+	expr = `{ repeat(3) }`
+	assertCompFail(expr, p, assert)
+
+	expr = `{ repeat(1, 2, 3, 4) }`
+	assertCompFail(expr, p, assert)
+
+	expr = `{ repeat(three, "ab") }`
+	assertRuntimeFail(expr, p, assert)
+
+	expr = `{ repeat(-1, "ab") }`
+	assertRuntimeFail(expr, p, assert)
+}
+
+func TestForFunc(t *testing.T) {
+	p, assert := setupParser(t)
+
+	expr := `{ for(@i, 1, 4, @i) }`
+	result := shouldParseExpression(expr, p, assert)
+	assertString("1234", result, assert)
+
+	expr = `{ for(@i, 1, 3, ordinal(@i), ", ") }`
+	result = shouldParseExpression(expr, p, assert)
+	assertString("1st, 2nd, 3rd", result, assert)
+
+	expr = `{ @n=2; for(@i, @n, 1, @i) }`
+	result = shouldParseExpression(expr, p, assert)
+	assertString("", result, assert)
+
+	// The loop variable doesn't leak out of the loop.
+	expr = `{ @i=9; concat(for(@i, 1, 2, @i), str(@i)) }`
+	result = shouldParseExpression(expr, p, assert)
+	assertString("129", result, assert)
+
+	expr = `{ for(i, 1, 2, @i) }`
+	assertCompFail(expr, p, assert)
+
+	expr = `{ for(@i, 1, 2) }`
+	assertCompFail(expr, p, assert)
+
+	expr = `{ for(@i, a, 2, @i) }`
+	assertRuntimeFail(expr, p, assert)
+
+	expr = `{ for(@i, 1, 20000, @i) }`
+	assertRuntimeFail(expr, p, assert)
+
+	// Bounds far enough apart to overflow still hit the limit.
+	expr = `{ for(@i, -9223372036854775807, 9223372036854775807, @i) }`
+	assertRuntimeFail(expr, p, assert)
+
+	expr = `{ for(@i, 9223372036854775806, 9223372036854775807, "x") }`
+	result = shouldParseExpression(expr, p, assert)
+	assertString("xx", result, assert)
+}
+
+func TestLoopListFuncs(t *testing.T) {
+	p, assert := setupParser(t)
+
+	expr := `{ for_list(@i, 1, 3, ordinal(@i)) }`
+	result := shouldParseExpression(expr, p, assert)
+	assertString("1st, 2nd and 3rd", result, assert)
+
+	expr = `{ repeat_list(3, 1d6?) }`
+	random := program.NewTestRandSource(2, 5, 1)
+	result = shouldParseExprWithContext(expr, p, program.NewRootExecutionContext().SetRandom(random), assert)
+	assertString("2, 5 and 1", result, assert)
+
+	expr = `{ repeat_list(2, "x") }`
+	result = shouldParseExprWithContext(expr, p, program.NewRootExecutionContext().SetLocale("de"), assert)
+	assertString("x und x", result, assert)
+
+	expr = `{ repeat_list(0, "x") }`
+	result = shouldParseExpression(expr, p, assert)
+	assertString("", result, assert)
+
+	expr = `{ repeat_list(2, "x", ", ") }`
+	assertCompFail(expr, p, assert)
+
+	expr = `{ for_list(@i, 1, 2, @i, ", ") }`
+	assertCompFail(expr, p, assert)
+}
+
+func TestSwitchFunc(t *testing.T) {
+	p, assert := setupParser(t)
+
+	expr := `{ @x=2; switch(@x, 1, one, 2, two, other) }`
+	result := shouldParseExpression(expr, p, assert)
+	assertString("two", result, assert)
+
+	expr = `{ @x=5; switch(@x, 1, one, 2, two, other) }`
+	result = shouldParseExpression(expr, p, assert)
+	assertString("other", result, assert)
+
+	expr = `{ switch(b, a, 1, b, 2) }`
+	result = shouldParseExpression(expr, p, assert)
+	assertInt(2, result, assert)
+
+	// Only the matched branch is evaluated.
+	random := program.NewTestRandSource(4)
+	expr = `{ switch(1, 1, 1d6?, 2, 1d8?, 1d10?) }`
+	result = shouldParseExprWithContext(expr, p, program.NewRootExecutionContext().SetRandom(random), assert)
+	assertInt(4, result, assert)
+
+	expr = `{ switch(1, 2) }`
+	assertCompFail(expr, p, assert)
+
+	expr = `{ switch(3, 1, one, 2, two) }`
+	assertRuntimeFail(expr, p, assert)
+}
+
+func TestCondFunc(t *testing.T) {
+	p, assert := setupParser(t)
+
+	expr := `{ @x=7; cond(lt(@x, 5), low, lt(@x, 10), mid, high) }`
+	result := shouldParseExpression(expr, p, assert)
+	assertString("mid", result, assert)
+
+	expr = `{ @x=70; cond(lt(@x, 5), low, lt(@x, 10), mid, high) }`
+	result = shouldParseExpression(expr, p, assert)
+	assertString("high", result, assert)
+
+	expr = `{ cond(1, yes) }`
+	result = shouldParseExpression(expr, p, assert)
+	assertString("yes", result, assert)
+
+	expr = `{ cond(1) }`
+	assertCompFail(expr, p, assert)
+
+	expr = `{ cond(0, yes) }`
+	assertRuntimeFail(expr, p, assert)
+
+	expr = `{ cond(maybe, yes, no) }`
+	assertRuntimeFail(expr, p, assert)
+}
+
+func TestTryFunc(t *testing.T) {
+	p, assert := setupParser(t)
+
+	expr := `{ try(5, 6) }`
+	result := shouldParseExpression(expr, p, assert)
+	assertInt(5, result, assert)
+
+	expr = `{ try(@missing, "fallback") }`
+	result = shouldParseExpression(expr, p, assert)
+	assertString("fallback", result, assert)
+
+	expr = `{ concat("a", try(add(x, 1), "b"), "c") }`
+	result = shouldParseExpression(expr, p, assert)
+	assertString("abc", result, assert)
+
+	expr = `{ try(try(@missing, @other), "last") }`
+	result = shouldParseExpression(expr, p, assert)
+	assertString("last", result, assert)
+
+	expr = `{ try(1) }`
+	assertCompFail(expr, p, assert)
+
+	// Errors in the fallback are not caught.
+	expr = `{ try(@missing, @other) }`
+	assertRuntimeFail(expr, p, assert)
+}
@@ -0,0 +1,287 @@
+package compiler
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/wingerjc/tableman-golang/pkg/parser"
+	"github.com/wingerjc/tableman-golang/pkg/program"
+	"gopkg.in/yaml.v3"
+)
+
+// Columns of table data files.
+const (
+	weightColumn = "weight"
+	countColumn  = "count"
+	rangeColumn  = "range"
+	labelColumn  = "label"
+	valueColumn  = "value"
+)
+
+var dataColumns = map[string]bool{
+	weightColumn: true,
+	countColumn:  true,
+	rangeColumn:  true,
+	labelColumn:  true,
+	valueColumn:  true,
+}
+
+// dataTable is a table read from a `TableData:` file, each row maps column
+// names to the text of the cell. Empty cells are left out.
+type dataTable struct {
+	name  string
+	fname string
+	// key is the hash of the file contents.
+	key  string
+	rows []map[string]string
+}
+
+// readTableData reads the rows of a csv, tsv, json or yaml file. Csv and tsv
+// files have a header row with the column names, json and yaml files are a
+// list of objects.
+func readTableData(fname string, name string) (*dataTable, error) {
+	f, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	var rows []map[string]string
+	switch strings.ToLower(filepath.Ext(fname)) {
+	case ".csv":
+		rows, err = readDelimited(f, ',')
+	case ".tsv":
+		rows, err = readDelimited(f, '\t')
+	case ".json":
+		raw := make([]map[string]interface{}, 0)
+		if err = json.Unmarshal(f, &raw); err == nil {
+			rows, err = dataCells(raw)
+		}
+	case ".yaml", ".yml":
+		raw := make([]map[string]interface{}, 0)
+		if err = yaml.Unmarshal(f, &raw); err == nil {
+			rows, err = dataCells(raw)
+		}
+	default:
+		return nil, fmt.Errorf("table data file '%s' must be a .csv, .tsv, .json or .yaml file", fname)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read table data '%s': %w", fname, err)
+	}
+	return &dataTable{
+		name:  name,
+		fname: fname,
+		key:   makeKey(string(f)),
+		rows:  rows,
+	}, nil
+}
+
+func readDelimited(data []byte, sep rune) ([]map[string]string, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.Comma = sep
+	// Spreadsheets often export quotes in cells without quoting the cell.
+	r.LazyQuotes = true
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	rows := make([]map[string]string, 0)
+	if len(records) == 0 {
+		return rows, nil
+	}
+	header := records[0]
+	for _, record := range records[1:] {
+		row := make(map[string]string)
+		for i, cell := range record {
+			if len(strings.TrimSpace(cell)) > 0 {
+				row[strings.ToLower(strings.TrimSpace(header[i]))] = cell
+			}
+		}
+		// Skip blank spreadsheet rows.
+		if len(row) > 0 {
+			rows = append(rows, row)
+		}
+	}
+	return rows, nil
+}
+
+func dataCells(raw []map[string]interface{}) ([]map[string]string, error) {
+	rows := make([]map[string]string, 0, len(raw))
+	for i, r := range raw {
+		row := make(map[string]string)
+		for k, v := range r {
+			column := strings.ToLower(strings.TrimSpace(k))
+			switch val := v.(type) {
+			case nil:
+				continue
+			case string:
+				row[column] = val
+			case int:
+				row[column] = strconv.Itoa(val)
+			case float64:
+				row[column] = strconv.FormatFloat(val, 'f', -1, 64)
+			case bool:
+				row[column] = strconv.FormatBool(val)
+			default:
+				return nil, fmt.Errorf("row %d column '%s' must be a string or a number", i+1, k)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// compileDataTable compiles the rows of a data file, values can have `{...}`
+// expressions between the text.
+func compileDataTable(d *dataTable, packKeys nameMap) (*program.Table, error) {
+	rows := make([]*program.TableRow, 0, len(d.rows))
+	for i, r := range d.rows {
+		row, err := compileDataRow(r, packKeys)
+		if err != nil {
+			return nil, fmt.Errorf("table data '%s' row %d: %w", d.fname, i+1, err)
+		}
+		rows = append(rows, row)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("table data '%s' has no rows", d.fname)
+	}
+	return program.NewTable(d.name, make(map[string]string), rows), nil
+}
+
+func compileDataRow(r map[string]string, packKeys nameMap) (*program.TableRow, error) {
+	for column := range r {
+		if !dataColumns[column] {
+			return nil, fmt.Errorf("unknown column '%s', columns can be weight, count, range, label and value", column)
+		}
+	}
+	text, ok := r[valueColumn]
+	if !ok {
+		return nil, fmt.Errorf("row has no value")
+	}
+	value, err := compileDataValue(text, packKeys)
+	if err != nil {
+		return nil, err
+	}
+	weight, err := dataNumber(r, weightColumn)
+	if err != nil {
+		return nil, err
+	}
+	count, err := dataNumber(r, countColumn)
+	if err != nil {
+		return nil, err
+	}
+	ranges, err := dataRanges(r[rangeColumn])
+	if err != nil {
+		return nil, err
+	}
+	row := program.NewTableRow(strings.TrimSpace(r[labelColumn]), ranges, weight, count, false, value)
+	return row.SetSource(&program.RowSource{Value: text}), nil
+}
+
+// dataNumber reads a weight or count column, 1 if it's empty.
+func dataNumber(r map[string]string, column string) (int, error) {
+	v, ok := r[column]
+	if !ok {
+		return 1, nil
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("%s must be a positive number, was '%s'", column, v)
+	}
+	return n, nil
+}
+
+// dataRanges reads a range column like "1-3, 5".
+func dataRanges(v string) ([]*program.Range, error) {
+	ranges := make([]*program.Range, 0)
+	for _, part := range strings.Split(v, ",") {
+		part = strings.TrimSpace(part)
+		if len(part) == 0 {
+			continue
+		}
+		bounds := strings.SplitN(part, "-", 2)
+		first, err := strconv.Atoi(strings.TrimSpace(bounds[0]))
+		last := first
+		if err == nil && len(bounds) == 2 {
+			last, err = strconv.Atoi(strings.TrimSpace(bounds[1]))
+		}
+		if err != nil || first < 1 || last < first {
+			return nil, fmt.Errorf("range '%s' must be a number or numbers like '1-3'", part)
+		}
+		ranges = append(ranges, program.NewRange(first, last))
+	}
+	return ranges, nil
+}
+
+var (
+	exprParser     *parser.ExpressionParser
+	exprParserErr  error
+	exprParserOnce sync.Once
+)
+
+// compileDataValue compiles the text of a value cell, `{...}` parts are expressions.
+func compileDataValue(text string, packKeys nameMap) (program.Evallable, error) {
+	exprParserOnce.Do(func() {
+		exprParser, exprParserErr = parser.GetExpressionParser()
+	})
+	if exprParserErr != nil {
+		return nil, exprParserErr
+	}
+	items := make([]program.Evallable, 0)
+	for len(text) > 0 {
+		open := strings.Index(text, "{")
+		if open < 0 {
+			items = append(items, program.NewString(text, false))
+			break
+		}
+		if open > 0 {
+			items = append(items, program.NewString(text[:open], false))
+		}
+		end, err := expressionEnd(text, open)
+		if err != nil {
+			return nil, err
+		}
+		parsed, err := exprParser.Parse(text[open : end+1])
+		if err != nil {
+			return nil, fmt.Errorf("could not parse '%s': %w", text[open:end+1], err)
+		}
+		e, err := compileExpression(parsed, packKeys)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, e)
+		text = text[end+1:]
+	}
+	return program.NewListExpression(items), nil
+}
+
+// expressionEnd finds the brace closing the expression opened at open,
+// skipping braces in strings.
+func expressionEnd(text string, open int) (int, error) {
+	depth := 0
+	inString := false
+	for i := open; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case inString && c == '\\':
+			i++
+		case inString:
+			inString = c != '"'
+		case c == '"':
+			inString = true
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+			if depth == 0 {
+				return i, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("unclosed '{' in '%s'", text)
+}
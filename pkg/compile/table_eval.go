@@ -1,40 +1,35 @@
 package compiler
 
 import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
 	"github.com/wingerjc/tableman-golang/pkg/parser"
 	"github.com/wingerjc/tableman-golang/pkg/program"
 )
 
-func compileTable(t *parser.Table, packKeys nameMap) (*program.Table, error) {
+// rangeStartTag sets the index of the first generated row, default 1.
+const rangeStartTag = "range-start"
+
+// parentLookup finds a compiled table by pack key and name for `Extends:`.
+type parentLookup func(key string, name string) (*program.Table, error)
+
+func compileTable(t *parser.Table, packKeys nameMap, parents parentLookup) (*program.Table, error) {
 	tags := make(map[string]string)
 	for _, tag := range t.Header.Tags {
 		tags[tag.Key.String()] = tag.Value.String()
 	}
+	if t.Generator != nil && len(t.Rows) > 0 {
+		return nil, fmt.Errorf("table '%s' can't have both a generator and rows", t.Header.Name)
+	}
 	rows := make([]*program.TableRow, 0)
-	if len(t.Rows) == 0 {
-		numSteps := len(t.Generator.Steps)
-		counts := make([]int, len(t.Generator.Steps))
-		rangeInt := 1
-		// Permute without recursion
-		for {
-			val := ""
-			for i := 0; i < numSteps; i++ {
-				val += t.Generator.Steps[i].StrVal(counts[i])
-			}
-			rows = append(rows, stringRow(val, rangeInt))
-			var i int
-			for i = 0; i < numSteps; i++ {
-				counts[i]++
-				if counts[i] == len(t.Generator.Steps[i].Values) {
-					counts[i] = 0
-				} else {
-					break
-				}
-			}
-			rangeInt++
-			if i == numSteps {
-				break
-			}
+	if t.Generator != nil {
+		var err error
+		rows, err = generateRows(t, tags, packKeys)
+		if err != nil {
+			return nil, err
 		}
 	} else {
 		for _, r := range t.Rows {
@@ -45,29 +40,205 @@ func compileTable(t *parser.Table, packKeys nameMap) (*program.Table, error) {
 			rows = append(rows, newRow)
 		}
 	}
-	return program.NewTable(t.Header.Name, tags, rows), nil
+	if t.Header.Extends != nil {
+		var err error
+		tags, rows, err = extendTable(t.Header, packKeys, parents, tags, rows)
+		if err != nil {
+			return nil, err
+		}
+	} else if len(t.Header.Remove) > 0 || len(t.Header.Reweight) > 0 {
+		return nil, fmt.Errorf("table '%s' can only remove or reweight rows when it extends a table", t.Header.Name)
+	}
+	if len(t.Header.Exclude) > 0 && t.Generator == nil {
+		return nil, fmt.Errorf("table '%s' can only exclude rows of a generator", t.Header.Name)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("table '%s' has no rows", t.Header.Name)
+	}
+
+	if v, ok := tags[program.AvoidRepeatTag]; ok {
+		if n, err := strconv.Atoi(v); err != nil || n < 0 {
+			return nil, fmt.Errorf("tag '%s' on table '%s' must be a number of 0 or more, was '%s'",
+				program.AvoidRepeatTag, t.Header.Name, v)
+		}
+	}
+	var dice *program.Roll
+	if v, ok := tags[program.DiceTag]; ok {
+		roll, err := compileDiceTag(v)
+		if err != nil {
+			return nil, fmt.Errorf("tag '%s' on table '%s': %w", program.DiceTag, t.Header.Name, err)
+		}
+		dice = roll
+	}
+	table := program.NewTable(t.Header.Name, tags, rows).SetDice(dice)
+	if _, ok := tags[program.MarkovTag]; ok {
+		opts, err := markovOptions(t.Header.Name, tags)
+		if err != nil {
+			return nil, err
+		}
+		if err := table.SetMarkov(opts); err != nil {
+			return nil, err
+		}
+	}
+	return table, nil
 }
 
-func stringRow(val string, rangeInt int) *program.TableRow {
-	rangeVal := make([]*program.Range, 1)
-	rangeVal[0] = program.NewRange(rangeInt, rangeInt)
-	return program.NewTableRow(
-		"",
-		rangeVal,
-		1,
-		1,
-		false,
-		program.NewString(val, false),
-	)
+// markovOptions reads the markov tags of a table, lengths default to 0.
+func markovOptions(name string, tags map[string]string) (program.MarkovOptions, error) {
+	opts := program.MarkovOptions{}
+	numbers := map[string]*int{
+		program.MarkovTag:    &opts.Order,
+		program.MinLengthTag: &opts.MinLength,
+		program.MaxLengthTag: &opts.MaxLength,
+	}
+	for tag, field := range numbers {
+		v, ok := tags[tag]
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return opts, fmt.Errorf("tag '%s' on table '%s' must be a number of 0 or more, was '%s'", tag, name, v)
+		}
+		*field = n
+	}
+	if v, ok := tags[program.NewOnlyTag]; ok {
+		switch v {
+		case "true":
+			opts.NewOnly = true
+		case "false":
+		default:
+			return opts, fmt.Errorf("tag '%s' on table '%s' must be true or false, was '%s'",
+				program.NewOnlyTag, name, v)
+		}
+	}
+	return opts, nil
 }
 
-func compileRow(r *parser.TableRow, packKeys nameMap) (*program.TableRow, error) {
+// extendTable starts from the rows and tags of the parent table, removes and
+// reweights parent rows, then replaces parent rows with the same label as a
+// new row or adds the new row. Tags other than the language are inherited.
+func extendTable(
+	h *parser.TableHeader,
+	packKeys nameMap,
+	parents parentLookup,
+	tags map[string]string,
+	rows []*program.TableRow,
+) (map[string]string, []*program.TableRow, error) {
+	if parents == nil {
+		return nil, nil, fmt.Errorf("table '%s' can't extend tables here", h.Name)
+	}
+	packName := h.Extends.PackageName()
+	key, ok := packKeys[packName]
+	if !ok {
+		return nil, nil, fmt.Errorf("could not find package '%s' did you forget or mistype an import?", packName)
+	}
+	parent, err := parents(key, h.Extends.TableName())
+	if err != nil {
+		return nil, nil, fmt.Errorf("table '%s' extends '%s': %w", h.Name, h.Extends.FullName(), err)
+	}
+
+	result := parent.Rows()
+	byLabel := make(map[string]int)
+	for i, r := range result {
+		if len(r.Label()) > 0 {
+			byLabel[r.Label()] = i
+		}
+	}
+	removed := make(map[int]bool)
+	for _, l := range h.Remove {
+		i, ok := byLabel[l.String()]
+		if !ok {
+			return nil, nil, fmt.Errorf("table '%s' removes row '%s' that '%s' doesn't have",
+				h.Name, l.String(), h.Extends.FullName())
+		}
+		removed[i] = true
+	}
+	for _, w := range h.Reweight {
+		i, ok := byLabel[w.Label.String()]
+		if !ok || removed[i] {
+			return nil, nil, fmt.Errorf("table '%s' reweights row '%s' that '%s' doesn't have",
+				h.Name, w.Label.String(), h.Extends.FullName())
+		}
+		result[i].SetWeight(w.Weight)
+	}
+	// A new default row replaces the parent default row.
+	for _, r := range rows {
+		if !r.Default() {
+			continue
+		}
+		for i, p := range result {
+			if p.Default() {
+				removed[i] = true
+			}
+		}
+	}
+	for _, r := range rows {
+		if i, ok := byLabel[r.Label()]; ok && len(r.Label()) > 0 && !removed[i] {
+			result[i] = r
+			continue
+		}
+		result = append(result, r)
+	}
+	merged := make([]*program.TableRow, 0, len(result))
+	for i, r := range result {
+		if !removed[i] {
+			merged = append(merged, r)
+		}
+	}
+
+	mergedTags := parent.Tags()
+	delete(mergedTags, program.LangTag)
+	for k, v := range tags {
+		mergedTags[k] = v
+	}
+	return mergedTags, merged, nil
+}
+
+var (
+	rollParser     *parser.RollParser
+	rollParserErr  error
+	rollParserOnce sync.Once
+)
+
+// compileDiceTag compiles a dice tag value like "2d6", the trailing '?' is optional.
+func compileDiceTag(v string) (*program.Roll, error) {
+	rollParserOnce.Do(func() {
+		rollParser, rollParserErr = parser.GetRollParser()
+	})
+	if rollParserErr != nil {
+		return nil, rollParserErr
+	}
+	v = strings.TrimSpace(v)
+	if !strings.HasSuffix(v, "?") {
+		v += "?"
+	}
+	parsed, err := rollParser.Parse(v)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse dice '%s': %w", v, err)
+	}
+	if parsed.Print {
+		return nil, fmt.Errorf("dice '%s' can't be cast to a string", v)
+	}
+	if len(parsed.RollCountAggrs) > 0 {
+		return nil, fmt.Errorf("dice '%s' can't use count aggregations", v)
+	}
+	roll, err := compileRollExpr(parsed)
+	if err != nil {
+		return nil, err
+	}
+	return roll.(*program.Roll), nil
+}
+
+func compileRowItems(values []*parser.RowItem, packKeys nameMap) (program.Evallable, error) {
 	var err error
 	items := make([]program.Evallable, 0)
-	for _, i := range r.Values {
+	for _, i := range values {
 		var e program.Evallable
 		if i.Expression != nil {
 			e, err = compileExpression(i.Expression, packKeys)
+		} else if i.Inline != nil {
+			e, err = compileInlineTable(i.Inline, packKeys)
 		} else {
 			e = program.NewString(i.String(), false)
 		}
@@ -76,7 +247,155 @@ func compileRow(r *parser.TableRow, packKeys nameMap) (*program.TableRow, error)
 		}
 		items = append(items, e)
 	}
-	value := program.NewListExpression(items)
+	return program.NewListExpression(items), nil
+}
+
+// rowItemsSource returns the source text of row items.
+func rowItemsSource(values []*parser.RowItem) string {
+	sources := make([]string, 0, len(values))
+	for _, i := range values {
+		sources = append(sources, parser.Source(i.Tokens))
+	}
+	return strings.Join(sources, " ")
+}
+
+func compileInlineTable(t *parser.InlineTable, packKeys nameMap) (program.Evallable, error) {
+	rows := make([]*program.TableRow, 0, len(t.Options))
+	for _, o := range t.Options {
+		value, err := compileRowItems(o.Values, packKeys)
+		if err != nil {
+			return nil, err
+		}
+		weight := 1
+		if o.Weight > 1 {
+			weight = o.Weight
+		}
+		rows = append(rows, program.NewTableRow("", make([]*program.Range, 0), weight, 1, false, value))
+	}
+	return program.NewInlineTable(rows), nil
+}
+
+// generateRows builds a row for every combination of the generator step values.
+// Weights and counts multiply, labels join and values are concatenated. Rows get
+// one index each in order, excluded combinations are skipped and use no index.
+func generateRows(t *parser.Table, tags map[string]string, packKeys nameMap) ([]*program.TableRow, error) {
+	rangeInt := 1
+	if v, ok := tags[rangeStartTag]; ok {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("tag '%s' on table '%s' must be a number, was '%s'",
+				rangeStartTag, t.Header.Name, v)
+		}
+		rangeInt = n
+	}
+	excluded := make(map[string]bool)
+	for _, l := range t.Header.Exclude {
+		excluded[l.String()] = false
+	}
+
+	steps := t.Generator.Steps
+	// Rows are only labelled if a value is given a label, so generators of
+	// plain strings stay unlabelled.
+	labelled := false
+	for _, step := range steps {
+		for _, v := range step.Values {
+			labelled = labelled || v.Label != nil
+		}
+	}
+	labels := make(map[string]bool)
+	values := make([][]program.Evallable, len(steps))
+	sources := make([][]string, len(steps))
+	for i, step := range steps {
+		values[i] = make([]program.Evallable, len(step.Values))
+		sources[i] = make([]string, len(step.Values))
+		for j, v := range step.Values {
+			if v.Expression == nil {
+				values[i][j] = program.NewString(v.String(), false)
+				sources[i][j] = *v.StringVal
+				continue
+			}
+			sources[i][j] = parser.Source(v.Expression.Tokens)
+			e, err := compileExpression(v.Expression, packKeys)
+			if err != nil {
+				return nil, err
+			}
+			values[i][j] = e
+		}
+	}
+
+	rows := make([]*program.TableRow, 0)
+	counts := make([]int, len(steps))
+	// Permute without recursion
+	for {
+		label := ""
+		weight, count := 1, 1
+		items := make([]program.Evallable, 0, len(steps))
+		source := make([]string, 0, len(steps))
+		for i, step := range steps {
+			v := step.Values[counts[i]]
+			label += v.LabelVal()
+			if v.Weight > 1 {
+				weight *= v.Weight
+			}
+			if v.Count > 1 {
+				count *= v.Count
+			}
+			items = append(items, values[i][counts[i]])
+			source = append(source, sources[i][counts[i]])
+		}
+		if _, ok := excluded[label]; ok {
+			excluded[label] = true
+		} else {
+			if !labelled {
+				label = ""
+			} else if len(label) > 0 {
+				if labels[label] {
+					return nil, fmt.Errorf("table '%s' generates more than one row labelled '%s'", t.Header.Name, label)
+				}
+				labels[label] = true
+			}
+			row := generatedRow(label, rangeInt, weight, count, items)
+			rows = append(rows, row.SetSource(&program.RowSource{Value: strings.Join(source, " ")}))
+			rangeInt++
+		}
+		var i int
+		for i = 0; i < len(steps); i++ {
+			counts[i]++
+			if counts[i] == len(steps[i].Values) {
+				counts[i] = 0
+			} else {
+				break
+			}
+		}
+		if i == len(steps) {
+			break
+		}
+	}
+	for _, l := range t.Header.Exclude {
+		if !excluded[l.String()] {
+			return nil, fmt.Errorf("table '%s' excludes row '%s' that isn't generated", t.Header.Name, l.String())
+		}
+	}
+	return rows, nil
+}
+
+func generatedRow(label string, rangeInt int, weight int, count int, items []program.Evallable) *program.TableRow {
+	rangeVal := make([]*program.Range, 1)
+	rangeVal[0] = program.NewRange(rangeInt, rangeInt)
+	var value program.Evallable
+	if len(items) == 1 {
+		value = items[0]
+	} else {
+		value = program.NewListExpression(items)
+	}
+	return program.NewTableRow(label, rangeVal, weight, count, false, value)
+}
+
+func compileRow(r *parser.TableRow, packKeys nameMap) (*program.TableRow, error) {
+	value, err := compileRowItems(r.Values, packKeys)
+	if err != nil {
+		return nil, err
+	}
 	label := ""
 	if r.Label != nil {
 		label = r.Label.String()
@@ -99,5 +418,35 @@ func compileRow(r *parser.TableRow, packKeys nameMap) (*program.TableRow, error)
 			}
 		}
 	}
-	return program.NewTableRow(label, rangeVal, weight, count, r.Default, value), nil
+	row := program.NewTableRow(label, rangeVal, weight, count, r.Default, value)
+	source := &program.RowSource{Value: rowItemsSource(r.Values)}
+	if r.WeightExpr != nil {
+		source.Weight = parser.Source(r.WeightExpr.Tokens)
+	}
+	if r.When != nil {
+		source.When = parser.Source(r.When.Tokens)
+	}
+	row.SetSource(source)
+	if r.WeightExpr != nil {
+		weightExpr, err := compileExpression(r.WeightExpr, packKeys)
+		if err != nil {
+			return nil, err
+		}
+		row.SetWeightExpr(weightExpr)
+	}
+	if r.When != nil {
+		when, err := compileExpression(r.When, packKeys)
+		if err != nil {
+			return nil, err
+		}
+		row.SetCondition(when)
+	}
+	if len(r.Tags) > 0 {
+		tags := make([]string, 0, len(r.Tags))
+		for _, t := range r.Tags {
+			tags = append(tags, t.String())
+		}
+		row.SetTags(tags)
+	}
+	return row, nil
 }
@@ -1,6 +1,7 @@
 package compiler
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -19,7 +20,7 @@ func parseRow(expr string, p *parser.RowParser, assert *assert.Assertions) *prog
 func parseTable(expr string, p *parser.TableParser, assert *assert.Assertions) *program.Table {
 	parsed, err := p.Parse(expr)
 	assert.NoError(err)
-	table, err := compileTable(parsed, defaultNameMap)
+	table, err := compileTable(parsed, defaultNameMap, nil)
 	assert.NoError(err)
 	return table
 }
@@ -213,6 +214,7 @@ func TestIndexRoll(t *testing.T) {
 	testCases := []int{9, 8, 14, 128}
 	testExpect := []string{"5", "1", "3", "2"}
 	table := parseTable(expr, p, assert)
+	table.SetCoverage(true)
 
 	for i, val := range testCases {
 		row, err := table.IndexRoll(val)
@@ -221,6 +223,8 @@ func TestIndexRoll(t *testing.T) {
 		assert.NoError(err)
 		assert.Equal(testExpect[i], result.StringVal())
 	}
+	assert.Equal([]int{1, 1, 1, 0, 1}, table.Hits())
+	assert.Equal(1, table.Rows()[1].HitsBy(program.IndexMode))
 
 	expr = `TableDef: bar
 	{999}`
@@ -291,6 +295,8 @@ func TestGeneratedTable(t *testing.T) {
 	table := parseTable(expr, p, assert)
 	assert.Equal(52, table.RowCount())
 	assert.Equal(52, table.TotalCount())
+	// Without a labelled value the rows have no labels.
+	assert.Empty(table.Labels())
 
 	for i, val := range testCases {
 		rand.AddMore(val)
@@ -307,3 +313,659 @@ func TestGeneratedTable(t *testing.T) {
 	assert.NoError(err)
 	assert.Equal("A of Diamonds", result.StringVal())
 }
+
+func TestGeneratorOptions(t *testing.T) {
+	assert := assert.New(t)
+	p, err := parser.GetTableParser()
+	assert.NoError(err)
+
+	ctx := program.NewRootExecutionContext()
+
+	expr := `TableDef: cards
+	Exclude: JokerC, JokerS
+	~ range-start: 11
+	[w=2 A: "Ace", "2", c=2 Joker: {concat("Jo", "ker")}]["": " of "]
+	[C: "Clubs", S: "Spades", {"Hearts"}]`
+	table := parseTable(expr, p, assert)
+	assert.Equal(7, table.RowCount())
+	assert.Equal(10, table.TotalWeight())
+	assert.Equal(8, table.TotalCount())
+
+	testCases := []string{"AC", "2S", "A", "Joker"}
+	testExpect := []string{"Ace of Clubs", "2 of Spades", "Ace of Hearts", "Joker of Hearts"}
+	for i, val := range testCases {
+		row, err := table.LabelRoll(val)
+		assert.NoError(err)
+		result, err := program.EvaluateExpression(row, ctx)
+		assert.NoError(err)
+		assert.Equal(testExpect[i], result.StringVal())
+	}
+	row, err := table.IndexRoll(16)
+	assert.NoError(err)
+	result, err := program.EvaluateExpression(row, ctx)
+	assert.NoError(err)
+	assert.Equal("2 of Hearts", result.StringVal())
+
+	badTables := []string{
+		`TableDef: foo
+		Exclude: c
+		["a", "b"]`,
+		`TableDef: foo
+		Exclude: a
+		"a"`,
+		`TableDef: foo
+		~ range-start: x
+		["a", "b"]`,
+		// Joined labels must be unique.
+		`TableDef: foo
+		[a: "x", "ab"]["b", ""]`,
+		`TableDef: foo
+		[A: "Ace"][{1}, {2}]`,
+	}
+	for _, expr := range badTables {
+		parsed, err := p.Parse(expr)
+		assert.NoError(err)
+		_, err = compileTable(parsed, defaultNameMap, nil)
+		assert.Error(err)
+	}
+}
+
+func TestTryMissingLabel(t *testing.T) {
+	assert := assert.New(t)
+	c, err := NewCompiler()
+	assert.NoError(err)
+
+	prog, err := c.CompileString(`TablePack: try
+
+	TableDef: color
+	r: "red"
+	b: "blue"`)
+	assert.NoError(err)
+
+	expr, err := c.CompileExpression(`{ try(!color(label, g), "grey") }`)
+	assert.NoError(err)
+	result, err := prog.Eval(expr)
+	assert.NoError(err)
+	assert.Equal("grey", result.StringVal())
+
+	expr, err = c.CompileExpression(`{ try(!color(label, b), "grey") }`)
+	assert.NoError(err)
+	result, err = prog.Eval(expr)
+	assert.NoError(err)
+	assert.Equal("blue", result.StringVal())
+}
+
+func TestDeckOperations(t *testing.T) {
+	assert := assert.New(t)
+	c, err := NewCompiler()
+	assert.NoError(err)
+
+	prog, err := c.CompileString(`TablePack: cards
+
+	TableDef: single
+	c=3 a: "ace"
+
+	TableDef: pair
+	a: "ace"
+	k: "king"
+
+	TableDef: auto
+	~ auto-reshuffle: discard
+	a: "ace"`)
+	assert.NoError(err)
+
+	eval := func(code string) (*program.ExpressionResult, error) {
+		expr, err := c.CompileExpression(code)
+		assert.NoError(err)
+		return prog.Eval(expr)
+	}
+	shouldEval := func(code string, expected string) {
+		result, err := eval(code)
+		assert.NoError(err)
+		if result != nil {
+			assert.Equal(expected, result.StringVal())
+		}
+	}
+	shouldCount := func(code string, expected int) {
+		result, err := eval(code)
+		assert.NoError(err)
+		if result != nil {
+			assert.Equal(expected, result.IntVal())
+		}
+	}
+
+	shouldCount(`{ !single(deck, remaining) }`, 3)
+	shouldEval(`{ !single(deck, draw, 2) }`, "ace, ace")
+	shouldCount(`{ !single(deck, remaining) }`, 1)
+
+	// Named decks have their own state.
+	shouldCount(`{ !single("deck:bob", remaining) }`, 3)
+	shouldEval(`{ !single("deck:bob") }`, "ace")
+	shouldCount(`{ !single("deck:bob", remaining) }`, 2)
+	shouldCount(`{ !single(deck, remaining) }`, 1)
+
+	// Discard pile and returning cards.
+	shouldEval(`{ !single(deck, discard, a) }`, "")
+	shouldCount(`{ !single(deck, discards) }`, 1)
+	shouldEval(`{ !single(deck, reshuffle) }`, "")
+	shouldCount(`{ !single(deck, discards) }`, 0)
+	shouldCount(`{ !single(deck, remaining) }`, 2)
+	shouldEval(`{ !single(deck, return, a) }`, "")
+	shouldCount(`{ !single(deck, remaining) }`, 3)
+	_, err = eval(`{ !single(deck, return, a) }`)
+	assert.Error(err)
+	_, err = eval(`{ !single(deck, discard, a) }`)
+	assert.Error(err)
+	_, err = eval(`{ !single(deck, draw, 4) }`)
+	assert.Error(err)
+	shouldCount(`{ !single(deck, remaining) }`, 3)
+
+	// A draw that runs out leaves the deck as it was.
+	shouldEval(`{ !single(deck, draw) }`, "ace")
+	shouldEval(`{ !single(deck, discard, a) }`, "")
+	_, err = eval(`{ !single(deck, draw, 3) }`)
+	assert.Error(err)
+	shouldCount(`{ !single(deck, remaining) }`, 2)
+	shouldCount(`{ !single(deck, discards) }`, 1)
+	shouldEval(`{ !single(deck, shuffle) }`, "ace")
+	shouldCount(`{ !single(deck, remaining) }`, 2)
+
+	// Peeking doesn't draw and the next draw is the peeked card.
+	for i := 0; i < 10; i++ {
+		peeked, err := eval(`{ !pair(deck, peek) }`)
+		assert.NoError(err)
+		shouldCount(`{ !pair(deck, remaining) }`, 2)
+		shouldEval(`{ !pair(deck, draw) }`, peeked.StringVal())
+		shouldEval(`{ !pair(deck, return, `+peeked.StringVal()[:1]+`) }`, "")
+	}
+
+	// Empty decks only refill with the auto-reshuffle tag.
+	shouldEval(`{ !auto(deck) }`, "ace")
+	_, err = eval(`{ !auto(deck) }`)
+	assert.Error(err)
+	shouldEval(`{ !auto(deck, discard, a) }`, "")
+	shouldEval(`{ !auto(deck) }`, "ace")
+	_, err = eval(`{ !pair(deck, nonesuch) }`)
+	assert.Error(err)
+}
+
+func TestUniqueRoll(t *testing.T) {
+	assert := assert.New(t)
+	c, err := NewCompiler()
+	assert.NoError(err)
+
+	prog, err := c.CompileString(`TablePack: gems
+
+	TableDef: gem
+	"ruby"
+	"opal"
+	"jade"`)
+	assert.NoError(err)
+
+	eval := func(code string) (*program.ExpressionResult, error) {
+		expr, err := c.CompileExpression(code)
+		assert.NoError(err)
+		return prog.Eval(expr)
+	}
+	assertAllGems := func(result string) {
+		assert.Len(strings.Split(result, ", "), 3)
+		for _, gem := range []string{"ruby", "opal", "jade"} {
+			assert.Contains(result, gem)
+		}
+	}
+
+	// Picks are unique within one evaluation and reset for the next one.
+	for i := 0; i < 10; i++ {
+		result, err := eval(`{ !gem(unique, 3) }`)
+		assert.NoError(err)
+		assertAllGems(result.StringVal())
+
+		result, err = eval(`{ repeat(3, !gem(unique), ", ") }`)
+		assert.NoError(err)
+		assertAllGems(result.StringVal())
+	}
+
+	_, err = eval(`{ !gem(unique, 4) }`)
+	assert.Error(err)
+	_, err = eval(`{ concat(!gem(unique, 2), !gem(unique, 2)) }`)
+	assert.Error(err)
+	_, err = eval(`{ !gem(unique, 0) }`)
+	assert.Error(err)
+}
+
+func TestAvoidRepeat(t *testing.T) {
+	assert := assert.New(t)
+	p, err := parser.GetTableParser()
+	assert.NoError(err)
+
+	rand := program.NewTestRandSource()
+	ctx := program.NewRootExecutionContext().
+		SetRandom(rand).
+		SetRecentPicks(program.NewRecentPicks())
+
+	expr := `TableDef: foo
+	~ avoid-repeat: 2
+	{1}
+	{2}
+	w=3: {3}`
+	table := parseTable(expr, p, assert)
+	rand.AddMore(0, 0, 0, 0)
+	testExpect := []string{"1", "2", "3", "1"}
+	for _, expected := range testExpect {
+		result, err := program.EvaluateExpression(table.Roll(), ctx)
+		assert.NoError(err)
+		assert.Equal(expected, result.StringVal())
+	}
+
+	// Recent rows are left out of the weights, 3 and 1 were rolled last.
+	rand.AddMore(0, 2)
+	testExpect = []string{"2", "3"}
+	for _, expected := range testExpect {
+		result, err := program.EvaluateExpression(table.WeightedRoll(), ctx)
+		assert.NoError(err)
+		assert.Equal(expected, result.StringVal())
+	}
+
+	// Every row is recent, so any row can be rolled.
+	expr = `TableDef: bar
+	~ avoid-repeat: 5
+	{1}`
+	table = parseTable(expr, p, assert)
+	rand.AddMore(0, 0)
+	for i := 0; i < 2; i++ {
+		result, err := program.EvaluateExpression(table.Roll(), ctx)
+		assert.NoError(err)
+		assert.Equal("1", result.StringVal())
+	}
+
+	// 0 turns it off.
+	parsed, err := p.Parse(`TableDef: off
+	~ avoid-repeat: "0"
+	{1}`)
+	assert.NoError(err)
+	_, err = compileTable(parsed, defaultNameMap, nil)
+	assert.NoError(err)
+
+	parsed, err = p.Parse(`TableDef: baz
+	~ avoid-repeat: lots
+	{1}`)
+	assert.NoError(err)
+	_, err = compileTable(parsed, defaultNameMap, nil)
+	assert.EqualError(err, "tag 'avoid-repeat' on table 'baz' must be a number of 0 or more, was 'lots'")
+}
+
+func TestDiceRoll(t *testing.T) {
+	assert := assert.New(t)
+	p, err := parser.GetTableParser()
+	assert.NoError(err)
+
+	rand := program.NewTestRandSource()
+	ctx := program.NewRootExecutionContext().SetRandom(rand)
+
+	// Without a dice tag the span of the ranges is rolled.
+	expr := `TableDef: suit
+	3-4: "hearts"
+	5-6: "spades"`
+	table := parseTable(expr, p, assert)
+	low, high, ok := table.DiceBounds()
+	assert.True(ok)
+	assert.Equal(3, low)
+	assert.Equal(6, high)
+	rand.AddMore(5)
+	roll, err := table.DiceRoll()
+	assert.NoError(err)
+	result, err := program.EvaluateExpression(roll, ctx)
+	assert.NoError(err)
+	assert.Equal("spades", result.StringVal())
+	assert.Equal("5: 3-6", ctx.LatestRoll())
+	assert.Empty(table.UncoveredDice())
+
+	// The dice tag is rolled and recorded like any roll.
+	expr = `TableDef: weather
+	~ dice: 2d6
+	2-6: "rain"
+	7: "clouds"
+	8-12: "sun"`
+	table = parseTable(expr, p, assert)
+	low, high, ok = table.DiceBounds()
+	assert.True(ok)
+	assert.Equal(2, low)
+	assert.Equal(12, high)
+	rand.AddMore(3, 4)
+	roll, err = table.DiceRoll()
+	assert.NoError(err)
+	result, err = program.EvaluateExpression(roll, ctx)
+	assert.NoError(err)
+	assert.Equal("clouds", result.StringVal())
+	assert.Contains(ctx.LatestRoll(), "7")
+	assert.Empty(table.UncoveredDice())
+
+	// Uncovered values fail when rolled and are reported.
+	expr = `TableDef: gap
+	~ dice: 2d6h1 
+	1-3: "low"
+	5: "five"`
+	table = parseTable(expr, p, assert)
+	assert.Equal([]int{4, 6}, table.UncoveredDice())
+	rand.AddMore(6, 1)
+	roll, err = table.DiceRoll()
+	assert.NoError(err)
+	_, err = program.EvaluateExpression(roll, ctx)
+	assert.Error(err)
+
+	// Default rows cover everything, dice can be quoted too.
+	expr = `TableDef: gap
+	~ dice: "1d6"
+	1-3: "low"
+	Default: "high"`
+	table = parseTable(expr, p, assert)
+	assert.Empty(table.UncoveredDice())
+
+	// Labels only, no index to roll.
+	expr = `TableDef: names
+	"a"
+	"b"`
+	table = parseTable(expr, p, assert)
+	_, _, ok = table.DiceBounds()
+	assert.False(ok)
+	_, err = table.DiceRoll()
+	assert.Error(err)
+
+	for _, bad := range []string{`"2d6.str"`, `"2d6.+6"`, `"d6"`, `lots`, `"0d6"`} {
+		parsed, err := p.Parse(`TableDef: bad
+		~ dice: ` + bad + `
+		{1}`)
+		assert.NoError(err)
+		_, err = compileTable(parsed, defaultNameMap, nil)
+		assert.Error(err, bad)
+	}
+}
+
+func TestDiceCoverageWarning(t *testing.T) {
+	assert := assert.New(t)
+	c, err := NewCompiler()
+	assert.NoError(err)
+	warnings := make([]string, 0)
+	c.SetWarningHandler(func(msg string) {
+		warnings = append(warnings, msg)
+	})
+
+	prog, err := c.CompileString(`TablePack: weather
+
+	TableDef: weather
+	~ dice: 2d6
+	2-6: "rain"
+	8-11: "sun"`)
+	assert.NoError(err)
+	assert.Len(warnings, 1)
+	assert.Contains(warnings[0], "7, 12")
+
+	expr, err := c.CompileExpression(`{ !weather(dice) }`)
+	assert.NoError(err)
+	for i := 0; i < 10; i++ {
+		// Either a covered row or a missing index error.
+		result, err := prog.Eval(expr)
+		if err == nil {
+			assert.Contains([]string{"rain", "sun"}, result.StringVal())
+		}
+	}
+
+	expr, err = c.CompileExpression(`{ !weather(dice, 2) }`)
+	assert.NoError(err)
+	_, err = prog.Eval(expr)
+	assert.Error(err)
+}
+
+func TestDynamicRows(t *testing.T) {
+	assert := assert.New(t)
+	p, err := parser.GetTableParser()
+	assert.NoError(err)
+
+	rand := program.NewTestRandSource()
+	ctx := program.NewRootExecutionContext().
+		SetRandom(rand)
+	ctx.Set("level", program.NewIntResult(3))
+	ctx.Set("terrain", program.NewStringResult("swamp"))
+
+	expr := `TableDef: foo
+	w={@level}: "a"
+	w=2 when {gt(@level, 5)}: "b"
+	w={if(eq(@terrain, swamp), 10, 1)}: "c"`
+	table := parseTable(expr, p, assert)
+
+	// Weights are 3, excluded and 10.
+	rand.AddMore(2, 3, 12)
+	testExpect := []string{"a", "c", "c"}
+	for _, expected := range testExpect {
+		result, err := program.EvaluateExpression(table.WeightedRoll(), ctx)
+		assert.NoError(err)
+		assert.Equal(expected, result.StringVal())
+	}
+
+	// Unweighted rolls only use the conditions.
+	rand.AddMore(1)
+	result, err := program.EvaluateExpression(table.Roll(), ctx)
+	assert.NoError(err)
+	assert.Equal("c", result.StringVal())
+
+	ctx.Set("level", program.NewIntResult(7))
+	rand.AddMore(8, 1)
+	result, err = program.EvaluateExpression(table.WeightedRoll(), ctx)
+	assert.NoError(err)
+	assert.Equal("b", result.StringVal())
+	result, err = program.EvaluateExpression(table.Roll(), ctx)
+	assert.NoError(err)
+	assert.Equal("b", result.StringVal())
+
+	// Label and index lookups ignore conditions.
+	row, err := table.LabelRoll("nonesuch")
+	assert.Error(err)
+	assert.Nil(row)
+
+	expr = `TableDef: bar
+	when {gt(@level, 10)}: "a"
+	w={sub(@level, 7)}: "b"`
+	table = parseTable(expr, p, assert)
+	_, err = program.EvaluateExpression(table.WeightedRoll(), ctx)
+	assert.Error(err)
+	ctx.Set("level", program.NewIntResult(6))
+	_, err = program.EvaluateExpression(table.WeightedRoll(), ctx)
+	assert.Error(err)
+	ctx.Set("level", program.NewStringResult("high"))
+	_, err = program.EvaluateExpression(table.Roll(), ctx)
+	assert.Error(err)
+
+	// `when` is only a condition before an expression, it's still a label.
+	expr = `TableDef: baz
+	when: "now"
+	when-ready: "soon"`
+	table = parseTable(expr, p, assert)
+	row, err = table.LabelRoll("when")
+	assert.NoError(err)
+	result, err = program.EvaluateExpression(row, ctx)
+	assert.NoError(err)
+	assert.Equal("now", result.StringVal())
+}
+
+func TestInlineTables(t *testing.T) {
+	assert := assert.New(t)
+	p, err := parser.GetTableParser()
+	assert.NoError(err)
+
+	rand := program.NewTestRandSource()
+	ctx := program.NewRootExecutionContext().
+		SetRandom(rand)
+
+	expr := `TableDef: door
+	"a " [ "red" | w=3 "blue" | "green" {1d4?} ] " door"
+	Default: [ "oak" | "pine"
+		| "ash" ] " " ["gate" | "hatch"]`
+	table := parseTable(expr, p, assert)
+	assert.Equal(2, table.RowCount())
+
+	// Weights are 1, 3 and 1.
+	testCases := [][]int{{0}, {1}, {3}, {4, 2}}
+	testExpect := []string{"a red door", "a blue door", "a blue door", "a green2 door"}
+	for i, vals := range testCases {
+		rand.AddMore(vals...)
+		result, err := program.EvaluateExpression(table.Rows()[0].Value(), ctx)
+		assert.NoError(err)
+		assert.Equal(testExpect[i], result.StringVal())
+	}
+	row, err := table.Default()
+	assert.NoError(err)
+	rand.AddMore(2, 1)
+	result, err := program.EvaluateExpression(row, ctx)
+	assert.NoError(err)
+	assert.Equal("ash hatch", result.StringVal())
+
+	// Generator rows still parse.
+	expr = `TableDef: gen
+	["a", "b"]["c"]`
+	table = parseTable(expr, p, assert)
+	assert.Equal(2, table.RowCount())
+
+	exprP, err := parser.GetExpressionParser()
+	assert.NoError(err)
+	rand.AddMore(1)
+	result = shouldParseExprWithContext(`{ concat("x", [ "y" | "z" ]) }`, exprP, ctx, assert)
+	assertString("xz", result, assert)
+	rand.AddMore(1, 1)
+	result = shouldParseExprWithContext(`{ [ "y" | [ "z" | "w" ] ] }`, exprP, ctx, assert)
+	assertString("w", result, assert)
+	_, err = exprP.Parse(`{ [ "y" ] }`)
+	assert.Error(err)
+}
+
+func TestMarkovTable(t *testing.T) {
+	assert := assert.New(t)
+	p, err := parser.GetTableParser()
+	assert.NoError(err)
+
+	rand := program.NewTestRandSource()
+	ctx := program.NewRootExecutionContext().
+		SetRandom(rand)
+
+	// Order 1 model: start -> a|b, a -> b, b -> c|a|end, c -> end
+	expr := `TableDef: names
+	~ markov: 1
+	~ min-length: 3
+	~ new-only: true
+	first: "abc"
+	"b" "ab"`
+	table := parseTable(expr, p, assert)
+	// "ab" is too short, "bab" is a sample, then "babc".
+	rand.AddMore(0, 0, 2, 1, 1, 0, 2, 1, 1, 0, 0, 0)
+	result, err := program.EvaluateExpression(table.Roll(), ctx)
+	assert.NoError(err)
+	assert.Equal("babc", result.StringVal())
+
+	// Rows are still there for other rolls.
+	row, err := table.LabelRoll("first")
+	assert.NoError(err)
+	result, err = program.EvaluateExpression(row, ctx)
+	assert.NoError(err)
+	assert.Equal("abc", result.StringVal())
+
+	expr = `TableDef: names
+	~ markov: 1
+	~ max-length: 2
+	"abc"
+	"bab"`
+	table = parseTable(expr, p, assert)
+	// "bab" is too long.
+	rand.AddMore(1, 1, 0, 0, 0, 2)
+	result, err = program.EvaluateExpression(table.WeightedRoll(), ctx)
+	assert.NoError(err)
+	assert.Equal("ab", result.StringVal())
+
+	// Only samples can be generated.
+	expr = `TableDef: names
+	~ markov: 2
+	~ new-only: true
+	"abc"`
+	table = parseTable(expr, p, assert)
+	for i := 0; i < 400; i++ {
+		rand.AddMore(0)
+	}
+	_, err = program.EvaluateExpression(table.Roll(), ctx)
+	assert.Error(err)
+
+	badTables := []string{
+		`TableDef: foo
+		~ markov: 1
+		"a" {1}`,
+		`TableDef: foo
+		~ markov: x
+		"a"`,
+		`TableDef: foo
+		~ markov: 1
+		~ min-length: 5
+		~ max-length: 2
+		"a"`,
+		`TableDef: foo
+		~ markov: 1
+		~ new-only: maybe
+		"a"`,
+	}
+	for _, expr := range badTables {
+		parsed, err := p.Parse(expr)
+		assert.NoError(err)
+		_, err = compileTable(parsed, defaultNameMap, nil)
+		assert.Error(err)
+	}
+}
+
+func TestCoverage(t *testing.T) {
+	assert := assert.New(t)
+	c, err := NewCompiler()
+	assert.NoError(err)
+	prog, err := c.CompileString(`TablePack: dungeon
+
+	TableDef: monster
+	~ dice: "1d2"
+	1 orc: "an orc"
+	2 rat: "a rat"
+	"a bat"`)
+	assert.NoError(err)
+	eval := func(code string) {
+		expr, err := c.CompileExpression(code)
+		assert.NoError(err)
+		_, err = prog.Eval(expr)
+		assert.NoError(err)
+	}
+
+	// Nothing is counted until coverage is on.
+	eval("{!monster(label, orc)}")
+	assert.Empty(prog.Coverage())
+
+	prog.SetCoverage(true)
+	prog.SetRandom(program.NewTestRandSource(0, 2))
+	session := prog.Copy()
+	session.SetRandom(program.NewTestRandSource(2))
+	eval("{!monster(label, orc)}")
+	eval("{!monster()}")
+	eval("{!monster(weighted)}")
+	_, err = session.Eval(mustExpr(c, "{!monster(dice)}", assert))
+	assert.NoError(err)
+
+	report := prog.Coverage()
+	assert.Len(report, 1)
+	assert.Equal("dungeon", report[0].Pack)
+	assert.Equal("monster", report[0].Table)
+	assert.Equal(3, report[0].Covered())
+	assert.Equal(map[string]int{"label": 1, "roll": 1}, report[0].Rows[0].Hits)
+	assert.Equal(2, report[0].Rows[0].Total)
+	assert.Equal(map[string]int{"dice": 1}, report[0].Rows[1].Hits)
+	assert.Equal("rat", report[0].Rows[1].Label)
+	assert.Equal(map[string]int{"weighted": 1}, report[0].Rows[2].Hits)
+
+	prog.SetCoverage(false)
+	assert.Empty(prog.Coverage())
+}
+
+func mustExpr(c *Compiler, code string, assert *assert.Assertions) program.Evallable {
+	expr, err := c.CompileExpression(code)
+	assert.NoError(err)
+	return expr
+}
@@ -0,0 +1,444 @@
+// Package docgen builds a browsable HTML site documenting a tree of table packs.
+//
+// Each pack gets a page with its doc comment, imports and tables. Tables show
+// their doc comment, tags, rows and example rolls, and link to the tables they
+// call and the tables that call them.
+package docgen
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	compiler "github.com/wingerjc/tableman-golang/pkg/compile"
+	"github.com/wingerjc/tableman-golang/pkg/export"
+	"github.com/wingerjc/tableman-golang/pkg/parser"
+	"github.com/wingerjc/tableman-golang/pkg/program"
+)
+
+const (
+	// DefaultSeed is the default seed for example rolls.
+	DefaultSeed = 1
+	// DefaultExamples is the default number of example rolls for each table.
+	DefaultExamples = 3
+	// PackExt is the extension of pack files found in directories.
+	PackExt = ".tman"
+	// IndexPage is the name of the site index page.
+	IndexPage = "index.html"
+)
+
+// creditTags are tags shown first for each table.
+var creditTags = []string{"source", "license"}
+
+// Options for building a site.
+type Options struct {
+	// Seed is the seed for example rolls, so a site builds the same each time.
+	Seed int64
+	// Examples is the number of example rolls for each table.
+	Examples int
+}
+
+// NewOptions returns the default options.
+func NewOptions() *Options {
+	return &Options{
+		Seed:     DefaultSeed,
+		Examples: DefaultExamples,
+	}
+}
+
+// Site is a built documentation site.
+type Site struct {
+	// Pages are the page contents by file name.
+	Pages map[string][]byte
+}
+
+// Write writes the pages of the site to a directory, creating it if needed.
+func (s *Site) Write(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for name, data := range s.Pages {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), data, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Build documents the packs in the given files and directories, and the packs
+// they import. Directories are searched for `.tman` files.
+func Build(c *compiler.Compiler, paths []string, opts *Options) (*Site, error) {
+	p, err := parser.GetParser()
+	if err != nil {
+		return nil, err
+	}
+	b := &builder{
+		compiler: c,
+		parser:   p,
+		opts:     opts,
+		files:    make(map[string]*packFile),
+		pages:    make(map[string]bool),
+		usedBy:   make(map[string][]*link),
+	}
+	for _, path := range paths {
+		if len(b.root) == 0 {
+			if b.root, err = siteRoot(path); err != nil {
+				return nil, err
+			}
+		}
+		fnames, err := packFiles(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, fname := range fnames {
+			if _, err := b.load(fname); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if len(b.order) == 0 {
+		return nil, fmt.Errorf("no pack files found")
+	}
+	for _, f := range b.order {
+		b.describe(f)
+	}
+	for _, f := range b.order {
+		for _, t := range f.page.Tables {
+			if len(t.Lang) == 0 {
+				t.UsedBy = b.usedBy[tableKey(f.fname, t.Name)]
+			}
+		}
+	}
+	return b.render()
+}
+
+// siteRoot returns the directory pack file names are shown relative to, the
+// first path given or the directory of the first file.
+func siteRoot(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return abs, nil
+	}
+	return filepath.Dir(abs), nil
+}
+
+// packFiles returns the pack file for a path, or the pack files in a directory.
+func packFiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+	result := make([]string, 0)
+	err = filepath.Walk(path, func(fname string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && strings.EqualFold(filepath.Ext(fname), PackExt) {
+			result = append(result, fname)
+		}
+		return nil
+	})
+	sort.Strings(result)
+	return result, err
+}
+
+type builder struct {
+	compiler *compiler.Compiler
+	parser   *parser.TableFileParser
+	opts     *Options
+	root     string
+	files    map[string]*packFile
+	order    []*packFile
+	// pages are the page names used so far.
+	pages map[string]bool
+	// usedBy links tables to the tables that call them.
+	usedBy map[string][]*link
+}
+
+// packFile is a loaded pack file.
+type packFile struct {
+	fname  string
+	parsed *parser.TableFile
+	// aliases maps the package names used in the file to file names.
+	aliases map[string]string
+	page    *packPage
+}
+
+// load parses a pack file and the files it imports.
+func (b *builder) load(fname string) (*packFile, error) {
+	fname, err := filepath.Abs(fname)
+	if err != nil {
+		return nil, err
+	}
+	if f, ok := b.files[fname]; ok {
+		return f, nil
+	}
+	code, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := b.parser.Parse(string(code))
+	if err != nil {
+		return nil, fmt.Errorf("could not parse '%s': %w", fname, err)
+	}
+	name := parsed.Header.Name.FullName()
+	shown, err := filepath.Rel(b.root, fname)
+	if err != nil {
+		shown = fname
+	}
+	f := &packFile{
+		fname:   fname,
+		parsed:  parsed,
+		aliases: map[string]string{name: fname},
+		page: &packPage{
+			Name: name,
+			File: filepath.ToSlash(shown),
+			Page: b.pageName(name),
+			Doc:  paragraphs(parsed.Header.Doc),
+		},
+	}
+	b.files[fname] = f
+	b.order = append(b.order, f)
+	for _, i := range parsed.Header.Imports {
+		imported, err := b.load(importPath(fname, i.File()))
+		if err != nil {
+			return nil, err
+		}
+		alias := imported.parsed.Header.Name.FullName()
+		if i.Alias != nil {
+			alias = i.Alias.FullName()
+		}
+		f.aliases[alias] = imported.fname
+		f.page.Imports = append(f.page.Imports, &link{Text: alias, Href: imported.page.Page})
+		imported.page.ImportedBy = append(imported.page.ImportedBy, &link{Text: name, Href: f.page.Page})
+	}
+	for _, d := range parsed.Header.Data {
+		f.page.Data = append(f.page.Data, d.File())
+	}
+	return f, nil
+}
+
+// importPath finds an imported file the same way the compiler does.
+func importPath(caller string, imported string) string {
+	if filepath.IsAbs(imported) {
+		return imported
+	}
+	return filepath.Join(filepath.Dir(caller), imported)
+}
+
+// pageName returns an unused page name for a pack.
+func (b *builder) pageName(pack string) string {
+	name := pack + ".html"
+	for i := 2; b.pages[name] || name == IndexPage; i++ {
+		name = fmt.Sprintf("%s-%d.html", pack, i)
+	}
+	b.pages[name] = true
+	return name
+}
+
+// describe fills in the tables of a pack page, with rows and examples from the
+// compiled pack.
+func (b *builder) describe(f *packFile) {
+	compiled := make(map[string]*export.Table)
+	var prog *program.Program
+	p, err := b.compiler.CompileFile(f.fname)
+	if err != nil {
+		f.page.Error = err.Error()
+	} else {
+		prog = p
+		for _, t := range export.Describe(prog)[0].Tables {
+			compiled[variantKey(t.Name, t.Tags[program.LangTag])] = t
+		}
+	}
+
+	for _, t := range f.parsed.Tables {
+		if t == nil {
+			continue
+		}
+		tags := make([]*tag, 0, len(t.Header.Tags))
+		lang := ""
+		for _, tg := range t.Header.Tags {
+			key, value := tg.Key.String(), tg.Value.String()
+			tags = append(tags, newTag(key, value))
+			if key == program.LangTag {
+				lang = value
+			}
+		}
+		page := &tablePage{
+			ID:   tableID(t.Header.Name, lang),
+			Name: t.Header.Name,
+			Lang: lang,
+			Doc:  paragraphs(t.Doc),
+			Tags: sortTags(tags),
+		}
+		if t.Header.Extends != nil {
+			page.Extends = b.tableLink(f, t.Header.Extends)
+		}
+		seen := make(map[string]bool)
+		for _, c := range parser.TableCalls(t) {
+			l := b.tableLink(f, &c.Name)
+			if seen[l.Text] {
+				continue
+			}
+			seen[l.Text] = true
+			page.Uses = append(page.Uses, l)
+			if len(l.file) > 0 {
+				key := tableKey(l.file, c.Name.TableName())
+				b.usedBy[key] = appendLink(b.usedBy[key], &link{
+					Text: f.page.Name + "." + t.Header.Name,
+					Href: f.page.Page + "#" + tableID(t.Header.Name, ""),
+				})
+			}
+		}
+		key := variantKey(t.Header.Name, lang)
+		if desc, ok := compiled[key]; ok {
+			page.Rows = desc.Rows
+			delete(compiled, key)
+			b.examples(prog, page)
+		}
+		f.page.Tables = append(f.page.Tables, page)
+	}
+
+	// Tables left over are read from data files.
+	names := make([]string, 0, len(compiled))
+	for k := range compiled {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	for _, k := range names {
+		desc := compiled[k]
+		page := &tablePage{
+			ID:       tableID(desc.Name, ""),
+			Name:     desc.Name,
+			Rows:     desc.Rows,
+			FromData: true,
+		}
+		b.examples(prog, page)
+		f.page.Tables = append(f.page.Tables, page)
+	}
+}
+
+// examples rolls on a table with the seed from the options.
+func (b *builder) examples(prog *program.Program, page *tablePage) {
+	expr, err := b.compiler.CompileExpression(fmt.Sprintf("{!%s()}", page.Name))
+	if err != nil {
+		page.ExampleError = err.Error()
+		return
+	}
+	prog.SetRandom(program.NewSeededRandSource(b.opts.Seed))
+	for i := 0; i < b.opts.Examples; i++ {
+		result, err := prog.EvalLocale(expr, page.Lang)
+		if err != nil {
+			page.ExampleError = err.Error()
+			return
+		}
+		if result.MatchType(program.StringResult) {
+			page.Examples = append(page.Examples, result.StringVal())
+		} else {
+			page.Examples = append(page.Examples, fmt.Sprintf("%d", result.IntVal()))
+		}
+	}
+}
+
+// tableLink links to a table called from a pack. Tables in packs that
+// aren't imported only get text.
+func (b *builder) tableLink(f *packFile, name *parser.ExtendedTableName) *link {
+	fname := f.fname
+	if pkg := name.PackageName(); len(pkg) > 0 {
+		var ok bool
+		if fname, ok = f.aliases[pkg]; !ok {
+			return &link{Text: name.FullName()}
+		}
+	}
+	target := b.files[fname]
+	return &link{
+		Text: name.FullName(),
+		Href: target.page.Page + "#" + tableID(name.TableName(), ""),
+		file: fname,
+	}
+}
+
+func appendLink(links []*link, l *link) []*link {
+	for _, x := range links {
+		if x.Href == l.Href {
+			return links
+		}
+	}
+	return append(links, l)
+}
+
+func tableID(name string, lang string) string {
+	if len(lang) > 0 {
+		return "table-" + name + "-" + strings.ToLower(lang)
+	}
+	return "table-" + name
+}
+
+func tableKey(fname string, name string) string {
+	return fname + "\x00" + name
+}
+
+func variantKey(name string, lang string) string {
+	return name + "\x00" + strings.ToLower(lang)
+}
+
+func newTag(key string, value string) *tag {
+	return &tag{
+		Key:   key,
+		Value: value,
+		URL:   strings.HasPrefix(value, "http://") || strings.HasPrefix(value, "https://"),
+	}
+}
+
+// sortTags puts the credit tags first and keeps the others in order.
+func sortTags(tags []*tag) []*tag {
+	result := make([]*tag, 0, len(tags))
+	for _, key := range creditTags {
+		for _, t := range tags {
+			if t.Key == key {
+				t.Credit = true
+				result = append(result, t)
+			}
+		}
+	}
+	for _, t := range tags {
+		if !t.Credit {
+			result = append(result, t)
+		}
+	}
+	return result
+}
+
+// paragraphs joins doc comment lines into paragraphs split by blank lines.
+func paragraphs(lines []string) []string {
+	result := make([]string, 0)
+	current := make([]string, 0)
+	flush := func() {
+		if len(current) > 0 {
+			result = append(result, strings.Join(current, " "))
+			current = current[:0]
+		}
+	}
+	for _, l := range lines {
+		if len(strings.TrimSpace(l)) == 0 {
+			flush()
+			continue
+		}
+		current = append(current, strings.TrimSpace(l))
+	}
+	flush()
+	return result
+}
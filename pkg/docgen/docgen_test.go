@@ -0,0 +1,100 @@
+package docgen
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	compiler "github.com/wingerjc/tableman-golang/pkg/compile"
+)
+
+func TestBuild(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	assert.NoError(os.Mkdir(filepath.Join(dir, "lib"), 0755))
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "main.tman"), []byte(`# The dungeon pack.
+TablePack: dungeon
+Import: f"./lib/names.tman" As: n
+
+# A room with a monster.
+TableDef: room
+~ author: Mel
+~ source: "https://example.com/rooms"
+~ license: "CC BY 4.0"
+"a room with " {!monster()} " named " {!n.names()}
+
+TableDef: monster
+w=2: "an orc"
+"a rat"
+
+TableDef: monster
+~ lang: de
+"ein Ork"
+`), 0644))
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "lib", "names.tman"), []byte(`TablePack: names
+
+TableDef: names
+"Ada"
+"Bo"
+"Cy"
+
+TableDef: title
+Extends: names
+"Dee"
+`), 0644))
+	assert.NoError(ioutil.WriteFile(filepath.Join(dir, "broken.tman"), []byte(`TablePack: broken
+TableDef: t
+{!other.thing()}
+`), 0644))
+
+	c, err := compiler.NewCompiler()
+	assert.NoError(err)
+	site, err := Build(c, []string{dir}, NewOptions())
+	if !assert.NoError(err) {
+		return
+	}
+	assert.Len(site.Pages, 4)
+	index := string(site.Pages[IndexPage])
+	assert.Contains(index, `<tr><td><a href="dungeon.html">dungeon</a></td><td>3</td><td><a href="names.html">n</a></td><td>The dungeon pack.</td></tr>`)
+
+	page := string(site.Pages["dungeon.html"])
+	assert.Contains(page, `<p><code>main.tman</code></p>`)
+	assert.Contains(page, `<p>Imports: <a href="names.html">n</a></p>`)
+	assert.Contains(page, `<h2 id="table-room">room</h2>
+<p class="credit">source: <a href="https://example.com/rooms">https://example.com/rooms</a></p>
+<p class="credit">license: CC BY 4.0</p>
+<p>A room with a monster.</p>
+<p>Uses: <a href="dungeon.html#table-monster">monster</a>, <a href="names.html#table-names">n.names</a></p>
+<dl>
+<dt>author</dt><dd>Mel</dd>
+</dl>`)
+	assert.Contains(page, `<h2 id="table-monster-de">monster (de)</h2>`)
+	assert.Contains(page, `<li>ein Ork</li>`)
+
+	// The pack calls a table it doesn't import, so it can't be compiled.
+	page = string(site.Pages["broken.html"])
+	assert.Contains(page, `<p>Uses: other.thing</p>`)
+	assert.Contains(page, `Could not compile the pack`)
+
+	page = string(site.Pages["names.html"])
+	assert.Contains(page, `<p>Imported by: <a href="dungeon.html">dungeon</a></p>`)
+	assert.Contains(page, `<p>Used by: <a href="dungeon.html#table-room">dungeon.room</a></p>`)
+	assert.Contains(page, `<p>Extends: <a href="names.html#table-names">names</a></p>`)
+	assert.Contains(page, `<td><code>&#34;Bo&#34;</code></td>`)
+
+	// Examples are the same for the same seed.
+	again, err := Build(c, []string{filepath.Join(dir, "lib", "names.tman")}, NewOptions())
+	assert.NoError(err)
+	examples := func(page []byte) string {
+		return string(page[bytes.Index(page, []byte("<p>Examples:")):])
+	}
+	assert.Equal(examples(site.Pages["names.html"]), examples(again.Pages["names.html"]))
+
+	out := filepath.Join(dir, "site")
+	assert.NoError(site.Write(out))
+	_, err = os.Stat(filepath.Join(out, "names.html"))
+	assert.NoError(err)
+}
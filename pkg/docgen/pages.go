@@ -0,0 +1,173 @@
+package docgen
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"sort"
+
+	"github.com/wingerjc/tableman-golang/pkg/export"
+)
+
+type link struct {
+	Text string
+	Href string
+	// file is the pack file linked to, empty for tables that weren't found.
+	file string
+}
+
+type tag struct {
+	Key    string
+	Value  string
+	URL    bool
+	Credit bool
+}
+
+type packPage struct {
+	Name       string
+	File       string
+	Page       string
+	Doc        []string
+	Imports    []*link
+	ImportedBy []*link
+	Data       []string
+	Tables     []*tablePage
+	// Error is why the pack couldn't be compiled for rows and examples.
+	Error string
+}
+
+type tablePage struct {
+	ID           string
+	Name         string
+	Lang         string
+	Doc          []string
+	Tags         []*tag
+	Extends      *link
+	Uses         []*link
+	UsedBy       []*link
+	Rows         []*export.Row
+	Examples     []string
+	ExampleError string
+	FromData     bool
+}
+
+// Credits returns the credit tags of the table.
+func (t *tablePage) Credits() []*tag {
+	return t.filterTags(true)
+}
+
+// OtherTags returns the tags of the table that aren't credits.
+func (t *tablePage) OtherTags() []*tag {
+	return t.filterTags(false)
+}
+
+func (t *tablePage) filterTags(credit bool) []*tag {
+	result := make([]*tag, 0, len(t.Tags))
+	for _, tg := range t.Tags {
+		if tg.Credit == credit {
+			result = append(result, tg)
+		}
+	}
+	return result
+}
+
+// render renders the index and pack pages.
+func (b *builder) render() (*Site, error) {
+	site := &Site{Pages: make(map[string][]byte)}
+	packs := make([]*packPage, 0, len(b.order))
+	for _, f := range b.order {
+		packs = append(packs, f.page)
+	}
+	sort.SliceStable(packs, func(i, j int) bool {
+		return packs[i].Name < packs[j].Name
+	})
+	var index bytes.Buffer
+	if err := pageTemplate.ExecuteTemplate(&index, "index", packs); err != nil {
+		return nil, err
+	}
+	site.Pages[IndexPage] = index.Bytes()
+	for _, p := range packs {
+		var page bytes.Buffer
+		if err := pageTemplate.ExecuteTemplate(&page, "pack", p); err != nil {
+			return nil, err
+		}
+		site.Pages[p.Page] = page.Bytes()
+	}
+	return site, nil
+}
+
+var pageTemplate = template.Must(template.New("site").Funcs(template.FuncMap{
+	"percent": func(p *float64) string {
+		if p == nil {
+			return "varies"
+		}
+		return fmt.Sprintf("%.1f%%", *p)
+	},
+}).Parse(`
+{{define "head"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.}}</title>
+<style>
+body { font-family: sans-serif; max-width: 60em; margin: 0 auto; padding: 1em; }
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #999; padding: 0.2em 0.5em; text-align: left; vertical-align: top; }
+dt { font-weight: bold; }
+.credit { font-style: italic; }
+.error { color: #a00; }
+</style>
+</head>
+<body>
+{{end}}
+
+{{define "link"}}{{if .Href}}<a href="{{.Href}}">{{.Text}}</a>{{else}}{{.Text}}{{end}}{{end}}
+
+{{define "tag"}}{{if .URL}}<a href="{{.Value}}">{{.Value}}</a>{{else}}{{.Value}}{{end}}{{end}}
+
+{{define "links"}}{{range $i, $l := .}}{{if $i}}, {{end}}{{template "link" $l}}{{end}}{{end}}
+
+{{define "index"}}{{template "head" "Table Packs"}}<h1>Table Packs</h1>
+<table>
+<tr><th>Pack</th><th>Tables</th><th>Imports</th><th>About</th></tr>
+{{range .}}<tr><td><a href="{{.Page}}">{{.Name}}</a></td><td>{{len .Tables}}</td><td>{{template "links" .Imports}}</td><td>{{with .Doc}}{{index . 0}}{{end}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+{{end}}
+
+{{define "pack"}}{{template "head" .Name}}<p><a href="index.html">All packs</a></p>
+<h1>{{.Name}}</h1>
+<p><code>{{.File}}</code></p>
+{{range .Doc}}<p>{{.}}</p>
+{{end}}{{if .Imports}}<p>Imports: {{template "links" .Imports}}</p>
+{{end}}{{if .ImportedBy}}<p>Imported by: {{template "links" .ImportedBy}}</p>
+{{end}}{{if .Data}}<p>Table data: {{range $i, $d := .Data}}{{if $i}}, {{end}}<code>{{$d}}</code>{{end}}</p>
+{{end}}{{if .Error}}<p class="error">Could not compile the pack for rows and examples: {{.Error}}</p>
+{{end}}{{if .Tables}}<ul>
+{{range .Tables}}<li><a href="#{{.ID}}">{{.Name}}{{if .Lang}} ({{.Lang}}){{end}}</a></li>
+{{end}}</ul>
+{{end}}{{range .Tables}}
+<h2 id="{{.ID}}">{{.Name}}{{if .Lang}} ({{.Lang}}){{end}}</h2>
+{{range .Credits}}<p class="credit">{{.Key}}: {{template "tag" .}}</p>
+{{end}}{{range .Doc}}<p>{{.}}</p>
+{{end}}{{if .FromData}}<p>Rows are read from a table data file.</p>
+{{end}}{{if .Extends}}<p>Extends: {{template "link" .Extends}}</p>
+{{end}}{{if .Uses}}<p>Uses: {{template "links" .Uses}}</p>
+{{end}}{{if .UsedBy}}<p>Used by: {{template "links" .UsedBy}}</p>
+{{end}}{{with .OtherTags}}<dl>
+{{range .}}<dt>{{.Key}}</dt><dd>{{template "tag" .}}</dd>
+{{end}}</dl>
+{{end}}{{if .Rows}}<table>
+<tr><th>Label</th><th>Ranges</th><th>Weight</th><th>Chance</th><th>Row</th></tr>
+{{range .Rows}}<tr><td>{{.Label}}{{if .Default}} (default){{end}}</td><td>{{range $i, $r := .Ranges}}{{if $i}}, {{end}}{{$r.String}}{{end}}</td><td>{{if .WeightExpr}}<code>{{.WeightExpr}}</code>{{else}}{{.Weight}}{{end}}</td><td>{{percent .Percent}}</td><td><code>{{.Source}}</code>{{if .When}} when <code>{{.When}}</code>{{end}}</td></tr>
+{{end}}</table>
+{{end}}{{if .Examples}}<p>Examples:</p>
+<ul>
+{{range .Examples}}<li>{{.}}</li>
+{{end}}</ul>
+{{end}}{{if .ExampleError}}<p class="error">No examples: {{.ExampleError}}</p>
+{{end}}{{end}}</body>
+</html>
+{{end}}
+`))
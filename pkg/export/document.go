@@ -0,0 +1,294 @@
+package export
+
+import (
+	"bytes"
+	"fmt"
+	htmltemplate "html/template"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/wingerjc/tableman-golang/pkg/program"
+)
+
+// printedTable is a table laid out for a document.
+type printedTable struct {
+	Name      string
+	Tags      string
+	Die       string
+	HasLabels bool
+	HasNotes  bool
+	Rows      []*printedRow
+}
+
+type printedRow struct {
+	Roll    string
+	Label   string
+	Result  string
+	Code    bool
+	Percent string
+	Notes   string
+}
+
+type printedPack struct {
+	Name   string
+	Tables []*printedTable
+}
+
+// layout lays out the packs for a document. Tables with index ranges print
+// them with the dice from the `dice` tag or the span of the ranges, other
+// tables get ranges for a die the size of the total row weight.
+func layout(packs []*Pack) []*printedPack {
+	result := make([]*printedPack, 0, len(packs))
+	for _, p := range packs {
+		printed := &printedPack{Name: p.Name}
+		for _, t := range p.Tables {
+			printed.Tables = append(printed.Tables, layoutTable(t))
+		}
+		result = append(result, printed)
+	}
+	return result
+}
+
+func layoutTable(t *Table) *printedTable {
+	result := &printedTable{Name: t.Name}
+	tags := make([]string, 0, len(t.Tags))
+	for _, k := range t.tagNames() {
+		tags = append(tags, fmt.Sprintf("%s: %s", k, t.Tags[k]))
+	}
+	result.Tags = strings.Join(tags, ", ")
+
+	ranged := false
+	low, high := 0, 0
+	for _, r := range t.Rows {
+		for _, rng := range r.Ranges {
+			if !ranged || rng.Low < low {
+				low = rng.Low
+			}
+			if !ranged || rng.High > high {
+				high = rng.High
+			}
+			ranged = true
+		}
+	}
+	// Markov tables make up names from the rows instead of rolling them.
+	_, markov := t.Tags[program.MarkovTag]
+	var chances *indexChances
+	if ranged {
+		chances = newIndexChances(t, low, high)
+	}
+	next := 1
+	for _, r := range t.Rows {
+		row := &printedRow{
+			Label:   r.Label,
+			Percent: "varies",
+			Notes:   rowNotes(r),
+		}
+		row.Result, row.Code = rowResult(r.Source)
+		if r.Percent != nil {
+			row.Percent = formatPercent(*r.Percent)
+		}
+		switch {
+		case markov:
+			row.Percent = ""
+		case ranged:
+			ranges := make([]string, 0, len(r.Ranges))
+			for _, rng := range r.Ranges {
+				ranges = append(ranges, rng.String())
+			}
+			row.Roll = strings.Join(ranges, ", ")
+			if chances != nil {
+				row.Percent = formatPercent(chances.percent(r))
+			}
+		case r.Percent != nil:
+			rng := &Range{Low: next, High: next + r.Weight - 1}
+			row.Roll = rng.String()
+			next += r.Weight
+		}
+		if len(row.Roll) == 0 && r.Default {
+			row.Roll = "other"
+		}
+		result.HasLabels = result.HasLabels || len(row.Label) > 0
+		result.HasNotes = result.HasNotes || len(row.Notes) > 0
+		result.Rows = append(result.Rows, row)
+	}
+	switch {
+	case markov:
+		result.Die = program.MarkovTag
+	case !ranged:
+		result.Die = fmt.Sprintf("d%d", next-1)
+	case len(t.Tags[program.DiceTag]) > 0:
+		result.Die = t.Tags[program.DiceTag]
+	case low == 1:
+		result.Die = fmt.Sprintf("d%d", high)
+	default:
+		result.Die = fmt.Sprintf("%d-%d", low, high)
+	}
+	return result
+}
+
+func formatPercent(p float64) string {
+	return fmt.Sprintf("%.1f%%", p)
+}
+
+var simpleDice = regexp.MustCompile(`^(\d+)d(\d+)$`)
+
+// indexChances is the chance of rolling each index of a table with index
+// ranges, from simple `NdS` dice or uniformly over the span of the ranges.
+type indexChances struct {
+	chance  map[int]float64
+	covered float64
+}
+
+// newIndexChances returns the chances of the indexes of a table, nil if the
+// dice are too complicated to work out.
+func newIndexChances(t *Table, low int, high int) *indexChances {
+	result := &indexChances{chance: make(map[int]float64)}
+	dice, ok := t.Tags[program.DiceTag]
+	if !ok {
+		for i := low; i <= high; i++ {
+			result.chance[i] = 1 / float64(high-low+1)
+		}
+	} else {
+		m := simpleDice.FindStringSubmatch(strings.TrimSpace(dice))
+		if m == nil {
+			return nil
+		}
+		count, _ := strconv.Atoi(m[1])
+		sides, _ := strconv.Atoi(m[2])
+		if count < 1 || sides < 1 {
+			return nil
+		}
+		result.chance[0] = 1
+		for d := 0; d < count; d++ {
+			next := make(map[int]float64)
+			for total, p := range result.chance {
+				for side := 1; side <= sides; side++ {
+					next[total+side] += p / float64(sides)
+				}
+			}
+			result.chance = next
+		}
+	}
+	for _, r := range t.Rows {
+		for _, rng := range r.Ranges {
+			for i := rng.Low; i <= rng.High; i++ {
+				result.covered += result.chance[i]
+			}
+		}
+	}
+	return result
+}
+
+// percent returns the chance of a dice roll picking the row, the default row
+// is picked for indexes no row has.
+func (c *indexChances) percent(r *Row) float64 {
+	p := 0.0
+	for _, rng := range r.Ranges {
+		for i := rng.Low; i <= rng.High; i++ {
+			p += c.chance[i]
+		}
+	}
+	if r.Default {
+		p += 1 - c.covered
+	}
+	return 100 * p
+}
+
+// rowResult returns the text to print for a row, rows that are a single
+// string print as text and others as source code.
+func rowResult(source string) (string, bool) {
+	if len(source) >= 2 && strings.HasPrefix(source, `"`) && strings.HasSuffix(source, `"`) &&
+		strings.Count(source, `"`) == 2 {
+		return source[1 : len(source)-1], false
+	}
+	return source, true
+}
+
+func rowNotes(r *Row) string {
+	notes := make([]string, 0)
+	if len(r.WeightExpr) > 0 {
+		notes = append(notes, "w="+r.WeightExpr)
+	}
+	if len(r.When) > 0 {
+		notes = append(notes, "when "+r.When)
+	}
+	if r.Count > 1 {
+		notes = append(notes, fmt.Sprintf("c=%d", r.Count))
+	}
+	for _, t := range r.Tags {
+		notes = append(notes, "~ "+t)
+	}
+	return strings.Join(notes, " ")
+}
+
+// markdownCell escapes text for a Markdown table cell.
+func markdownCell(text string) string {
+	return strings.NewReplacer("|", `\|`, "\r", "", "\n", " ").Replace(text)
+}
+
+// markdownCode formats source code for a Markdown table cell.
+func markdownCode(text string) string {
+	if len(text) == 0 || strings.Contains(text, "`") {
+		return markdownCell(text)
+	}
+	return "`" + markdownCell(text) + "`"
+}
+
+var markdownTemplate = template.Must(template.New("markdown").Funcs(template.FuncMap{
+	"cell": markdownCell,
+	"code": markdownCode,
+}).Parse(`{{range .}}# {{.Name}}
+{{range .Tables}}
+## {{.Name}}
+{{if .Tags}}
+*{{cell .Tags}}*
+{{end}}
+| {{.Die}} |{{if .HasLabels}} Label |{{end}} Result | Chance |{{if .HasNotes}} Notes |{{end}}
+| --- |{{if .HasLabels}} --- |{{end}} --- | --- |{{if .HasNotes}} --- |{{end}}
+{{$t := .}}{{range .Rows}}| {{.Roll}} |{{if $t.HasLabels}} {{cell .Label}} |{{end}} {{if .Code}}{{code .Result}}{{else}}{{cell .Result}}{{end}} | {{.Percent}} |{{if $t.HasNotes}} {{code .Notes}} |{{end}}
+{{end}}{{end}}
+{{end}}`))
+
+// Markdown describes the packs of a program as a Markdown document with a
+// section for each table.
+func Markdown(p *program.Program) ([]byte, error) {
+	var b bytes.Buffer
+	if err := markdownTemplate.Execute(&b, layout(Describe(p))); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSpace(b.Bytes()), nil
+}
+
+var htmlTemplate = htmltemplate.Must(htmltemplate.New("html").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{range $i, $p := .}}{{if $i}}, {{end}}{{$p.Name}}{{end}}</title>
+<style>
+table { border-collapse: collapse; margin-bottom: 1em; }
+th, td { border: 1px solid #999; padding: 0.2em 0.5em; text-align: left; }
+</style>
+</head>
+<body>
+{{range .}}<h1>{{.Name}}</h1>
+{{range .Tables}}<h2>{{.Name}}</h2>
+{{if .Tags}}<p><em>{{.Tags}}</em></p>
+{{end}}<table>
+<tr><th>{{.Die}}</th>{{if .HasLabels}}<th>Label</th>{{end}}<th>Result</th><th>Chance</th>{{if .HasNotes}}<th>Notes</th>{{end}}</tr>
+{{$t := .}}{{range .Rows}}<tr><td>{{.Roll}}</td>{{if $t.HasLabels}}<td>{{.Label}}</td>{{end}}<td>{{if .Code}}<code>{{.Result}}</code>{{else}}{{.Result}}{{end}}</td><td>{{.Percent}}</td>{{if $t.HasNotes}}<td>{{if .Notes}}<code>{{.Notes}}</code>{{end}}</td>{{end}}</tr>
+{{end}}</table>
+{{end}}{{end}}</body>
+</html>
+`))
+
+// HTML describes the packs of a program as an HTML page with a table for
+// each table.
+func HTML(p *program.Program) ([]byte, error) {
+	var b bytes.Buffer
+	if err := htmlTemplate.Execute(&b, layout(Describe(p))); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
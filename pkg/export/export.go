@@ -0,0 +1,122 @@
+// Package export describes the tables of a compiled program for publishing,
+// as JSON for other tools or as Markdown and HTML documents to print.
+package export
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/wingerjc/tableman-golang/pkg/program"
+)
+
+// Pack describes a table pack.
+type Pack struct {
+	Name   string   `json:"name"`
+	Tables []*Table `json:"tables"`
+}
+
+// Table describes a table and its rows.
+type Table struct {
+	Name        string            `json:"name"`
+	Tags        map[string]string `json:"tags,omitempty"`
+	TotalWeight int               `json:"totalWeight"`
+	TotalCount  int               `json:"totalCount"`
+	Rows        []*Row            `json:"rows"`
+}
+
+// Row describes a table row. Percent is the chance of a weighted roll picking
+// the row, it's left out for rows with weight expressions.
+type Row struct {
+	Label      string   `json:"label,omitempty"`
+	Default    bool     `json:"default,omitempty"`
+	Weight     int      `json:"weight"`
+	WeightExpr string   `json:"weightExpr,omitempty"`
+	Count      int      `json:"count"`
+	Ranges     []*Range `json:"ranges,omitempty"`
+	When       string   `json:"when,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+	Source     string   `json:"source"`
+	Percent    *float64 `json:"percent,omitempty"`
+}
+
+// Range is an inclusive index range of a row.
+type Range struct {
+	Low  int `json:"low"`
+	High int `json:"high"`
+}
+
+// String formats the range like it's written in a table.
+func (r *Range) String() string {
+	if r.Low == r.High {
+		return fmt.Sprintf("%d", r.Low)
+	}
+	return fmt.Sprintf("%d-%d", r.Low, r.High)
+}
+
+// Describe returns the packs of a program with the root pack first.
+func Describe(p *program.Program) []*Pack {
+	result := make([]*Pack, 0, p.PackCount())
+	for _, pack := range p.Packs() {
+		desc := &Pack{Name: pack.Name()}
+		for _, t := range pack.Tables() {
+			desc.Tables = append(desc.Tables, describeTable(t))
+		}
+		result = append(result, desc)
+	}
+	return result
+}
+
+func describeTable(t *program.Table) *Table {
+	result := &Table{
+		Name:        t.Name(),
+		Tags:        t.Tags(),
+		TotalWeight: t.TotalWeight(),
+		TotalCount:  t.TotalCount(),
+	}
+	staticWeight := 0
+	for _, r := range t.Rows() {
+		if !r.Dynamic() {
+			staticWeight += r.Weight()
+		}
+	}
+	for _, r := range t.Rows() {
+		row := &Row{
+			Label:   r.Label(),
+			Default: r.Default(),
+			Weight:  r.Weight(),
+			Count:   r.Count(),
+			Tags:    r.Tags(),
+		}
+		for _, rng := range r.Ranges() {
+			row.Ranges = append(row.Ranges, &Range{Low: rng.Low(), High: rng.High()})
+		}
+		if source := r.Source(); source != nil {
+			row.Source = source.Value
+			row.WeightExpr = source.Weight
+			row.When = source.When
+		}
+		if !r.Dynamic() && staticWeight > 0 {
+			percent := math.Round(10000*float64(r.Weight())/float64(staticWeight)) / 100
+			row.Percent = &percent
+		}
+		result.Rows = append(result.Rows, row)
+	}
+	return result
+}
+
+// JSON describes the packs of a program as indented JSON.
+func JSON(p *program.Program) ([]byte, error) {
+	return json.MarshalIndent(Describe(p), "", "  ")
+}
+
+// tagNames returns the tag names of a table in order.
+func (t *Table) tagNames() []string {
+	result := make([]string, 0, len(t.Tags))
+	for k := range t.Tags {
+		result = append(result, k)
+	}
+	sort.Strings(result)
+	return result
+}
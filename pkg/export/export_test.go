@@ -0,0 +1,100 @@
+package export
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	compiler "github.com/wingerjc/tableman-golang/pkg/compile"
+	"github.com/wingerjc/tableman-golang/pkg/program"
+)
+
+const testPack = `TablePack: dungeon
+
+TableDef: monster
+~ dice: "2d4"
+1-2 orc: "an orc"
+3-5 goblins: {@n=1d4?; concat(str(@n), " goblins")} ~ small
+Default: "a | rat"
+
+TableDef: door
+w=3: "a wooden door"
+"a " [ "red" | "blue" ] " door"
+w={1} when {1}: "a secret door"
+`
+
+func compilePack(code string, assert *assert.Assertions) *program.Program {
+	c, err := compiler.NewCompiler()
+	assert.NoError(err)
+	prog, err := c.CompileString(code)
+	assert.NoError(err)
+	return prog
+}
+
+func TestJSON(t *testing.T) {
+	assert := assert.New(t)
+	prog := compilePack(testPack, assert)
+
+	data, err := JSON(prog)
+	assert.NoError(err)
+	packs := make([]*Pack, 0)
+	assert.NoError(json.Unmarshal(data, &packs))
+	assert.Len(packs, 1)
+	assert.Equal("dungeon", packs[0].Name)
+	assert.Len(packs[0].Tables, 2)
+
+	door := packs[0].Tables[0]
+	assert.Equal("door", door.Name)
+	assert.Equal(5, door.TotalWeight)
+	assert.Len(door.Rows, 3)
+	assert.Equal(`"a wooden door"`, door.Rows[0].Source)
+	assert.Equal(75.0, *door.Rows[0].Percent)
+	assert.Equal(`"a " [ "red" | "blue" ] " door"`, door.Rows[1].Source)
+	assert.Equal("{1}", door.Rows[2].WeightExpr)
+	assert.Equal("{1}", door.Rows[2].When)
+	assert.Nil(door.Rows[2].Percent)
+
+	monster := packs[0].Tables[1]
+	assert.Equal(map[string]string{"dice": "2d4"}, monster.Tags)
+	assert.Equal("orc", monster.Rows[0].Label)
+	assert.Equal([]*Range{{Low: 1, High: 2}}, monster.Rows[0].Ranges)
+	assert.Equal(`{@n=1d4?; concat(str(@n), " goblins")}`, monster.Rows[1].Source)
+	assert.Equal([]string{"small"}, monster.Rows[1].Tags)
+	assert.True(monster.Rows[2].Default)
+}
+
+func TestMarkdown(t *testing.T) {
+	assert := assert.New(t)
+	prog := compilePack(testPack, assert)
+
+	md, err := Markdown(prog)
+	assert.NoError(err)
+	assert.Equal("# dungeon\n\n"+
+		"## door\n\n"+
+		"| d4 | Result | Chance | Notes |\n"+
+		"| --- | --- | --- | --- |\n"+
+		"| 1-3 | a wooden door | 75.0% |  |\n"+
+		"| 4 | `\"a \" [ \"red\" \\| \"blue\" ] \" door\"` | 25.0% |  |\n"+
+		"|  | a secret door | varies | `w={1} when {1}` |\n\n"+
+		"## monster\n\n"+
+		"*dice: 2d4*\n\n"+
+		"| 2d4 | Label | Result | Chance | Notes |\n"+
+		"| --- | --- | --- | --- | --- |\n"+
+		"| 1-2 | orc | an orc | 6.2% |  |\n"+
+		"| 3-5 | goblins | `{@n=1d4?; concat(str(@n), \" goblins\")}` | 56.2% | `~ small` |\n"+
+		"| other |  | a \\| rat | 37.5% |  |", string(md))
+
+	// Ranges without dice are rolled evenly over their span.
+	prog = compilePack(`TablePack: p
+TableDef: t
+1: "a"
+2-4: "b"`, assert)
+	md, err = Markdown(prog)
+	assert.NoError(err)
+	assert.Contains(string(md), "| d4 | Result | Chance |\n")
+	assert.Contains(string(md), "| 2-4 | b | 75.0% |")
+
+	page, err := HTML(prog)
+	assert.NoError(err)
+	assert.Contains(string(page), "<tr><td>2-4</td><td>b</td><td>75.0%</td></tr>")
+}
@@ -0,0 +1,180 @@
+// Package packtest runs golden output tests for table packs.
+//
+// Tests are kept in `.tmantest` YAML files next to the packs they test:
+//
+//	pack: dungeon.tman
+//	seed: 7
+//	tests:
+//	  - name: orc room
+//	    expr: "{!room()}"
+//	    expect: "a room with an orc"
+//	  - name: scripted rolls
+//	    expr: "{!monster()}"
+//	    rolls: [1]
+//	    expect: "a rat"
+//	  - name: names
+//	    expr: "{!names()}"
+//	    match: "^[A-Z][a-z]+$"
+//	    assert: ['{not(eq(@result, "Bob"))}']
+//	  - name: hoards
+//	    expr: "{!hoard()}"
+//	    runs: 100
+//	    range: {min: 100}
+//	    never: ['{eq(@result, "nothing")}']
+//	    reachable: [hoard]
+//
+// Each test evaluates its expression on a fresh copy of the compiled pack,
+// with scripted rolls or a seeded random source. Property tests evaluate it
+// for a number of runs with consecutive seeds.
+package packtest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// TestExt is the extension of pack test files.
+	TestExt = ".tmantest"
+	// DefaultSeed is the seed for tests without rolls or a seed.
+	DefaultSeed = 1
+	// ResultVar is the variable assertions read the result from, `@result`.
+	ResultVar = "result"
+)
+
+// File is a pack test file.
+type File struct {
+	// Pack is the pack file to test, relative to the test file.
+	Pack string `yaml:"pack"`
+	// Seed is the default seed for the tests in the file.
+	Seed  *int64  `yaml:"seed"`
+	Tests []*Case `yaml:"tests"`
+
+	fname string
+}
+
+// Case is a single test of an expression.
+type Case struct {
+	Name string `yaml:"name"`
+	Expr string `yaml:"expr"`
+	// Seed for the random source, unless rolls are given.
+	Seed *int64 `yaml:"seed"`
+	// Rolls are scripted values for every random pick, in order. Dice take
+	// their face value and a pick between n rows a value from 0 to n-1.
+	Rolls  []int  `yaml:"rolls"`
+	Locale string `yaml:"locale"`
+
+	// Expect is the exact expected output.
+	Expect *string `yaml:"expect"`
+	// Match is a regular expression the output must match.
+	Match string `yaml:"match"`
+	// Assert are expressions that must give a non-zero integer with the output
+	// in `@result`.
+	Assert []string `yaml:"assert"`
+
+	// Runs is how many times to evaluate the expression, each run with the
+	// next seed. Every run must pass the checks.
+	Runs int `yaml:"runs"`
+	// Always are assertions that must be true for every run.
+	Always []string `yaml:"always"`
+	// Never are assertions that must be false for every run.
+	Never []string `yaml:"never"`
+	// Range is the range the output must be a number in.
+	Range *Range `yaml:"range"`
+	// Reachable are tables that must have every row picked in some run.
+	Reachable []string `yaml:"reachable"`
+	// Error is text the evaluation error must contain, the test fails if there
+	// is no error.
+	Error string `yaml:"error"`
+}
+
+// Range is an inclusive range of numbers, either end can be left open.
+type Range struct {
+	Min *int `yaml:"min"`
+	Max *int `yaml:"max"`
+}
+
+// runs returns how many times the test is run.
+func (c *Case) runs() int {
+	if c.Runs < 1 {
+		return 1
+	}
+	return c.Runs
+}
+
+// Load reads a pack test file.
+func Load(fname string) (*File, error) {
+	data, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return nil, err
+	}
+	result := &File{}
+	if err := yaml.Unmarshal(data, result); err != nil {
+		return nil, fmt.Errorf("could not read test file '%s': %w", fname, err)
+	}
+	if len(result.Pack) == 0 {
+		return nil, fmt.Errorf("test file '%s' has no pack", fname)
+	}
+	for i, c := range result.Tests {
+		if len(c.Name) == 0 {
+			c.Name = fmt.Sprintf("test %d", i+1)
+		}
+		if len(strings.TrimSpace(c.Expr)) == 0 {
+			return nil, fmt.Errorf("test file '%s': '%s' has no expr", fname, c.Name)
+		}
+		if c.Runs < 0 {
+			return nil, fmt.Errorf("test file '%s': '%s' has negative runs", fname, c.Name)
+		}
+		if c.Runs > 1 && len(c.Rolls) > 0 {
+			return nil, fmt.Errorf("test file '%s': '%s' can't use rolls with more than one run", fname, c.Name)
+		}
+	}
+	result.fname = fname
+	return result, nil
+}
+
+// PackFile returns the path of the tested pack.
+func (f *File) PackFile() string {
+	if filepath.IsAbs(f.Pack) {
+		return f.Pack
+	}
+	return filepath.Join(filepath.Dir(f.fname), f.Pack)
+}
+
+// Discover returns the test files for the given paths, directories are
+// searched for `.tmantest` files.
+func Discover(paths []string) ([]string, error) {
+	result := make([]string, 0)
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			result = append(result, path)
+			continue
+		}
+		found := make([]string, 0)
+		err = filepath.Walk(path, func(fname string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && strings.EqualFold(filepath.Ext(fname), TestExt) {
+				found = append(found, fname)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(found)
+		result = append(result, found...)
+	}
+	return result, nil
+}
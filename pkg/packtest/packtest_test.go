@@ -0,0 +1,184 @@
+package packtest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	compiler "github.com/wingerjc/tableman-golang/pkg/compile"
+)
+
+const testPack = `TablePack: dungeon
+
+TableDef: monster
+w=2: "an orc"
+"a rat"
+
+TableDef: names
+"Ada"
+"Bo"
+"Cy"
+
+TableDef: greeting
+"hello"
+
+TableDef: greeting
+~ lang: de
+"hallo"
+
+TableDef: hoard
+{@g=1d4?; mult(@g, 100)}
+
+TableDef: lookup
+1-3: "low"
+4-6: "high"
+Default: "none"
+`
+
+func writeFile(dir string, name string, content string, assert *assert.Assertions) string {
+	fname := filepath.Join(dir, name)
+	assert.NoError(os.MkdirAll(filepath.Dir(fname), 0755))
+	assert.NoError(ioutil.WriteFile(fname, []byte(content), 0644))
+	return fname
+}
+
+func failures(results []*Result) map[string][]string {
+	result := make(map[string][]string)
+	for _, r := range results {
+		result[r.Name] = r.Failures
+	}
+	return result
+}
+
+func TestRun(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	writeFile(dir, "dungeon.tman", testPack, assert)
+	fname := writeFile(dir, "tests/dungeon.tmantest", `pack: ../dungeon.tman
+seed: 3
+tests:
+  - name: scripted
+    expr: "{!monster()}"
+    rolls: [1]
+    expect: "a rat"
+  - name: scripted out
+    expr: "{concat(!monster(), !monster())}"
+    rolls: [1]
+  - name: scripted range
+    expr: "{!monster()}"
+    rolls: [2]
+  - name: golden
+    expr: "{!names()}"
+    expect: "Bob"
+  - name: match
+    expr: "{!names()}"
+    match: "^[A-Z][a-z]+$"
+    assert: ['{not(eq(@result, "Bob"))}', '{eq(@result, "Bob")}', '{@result}']
+  - name: locale
+    expr: "{!greeting()}"
+    locale: de
+    expect: "hallo"
+  - name: error
+    expr: "{!missing()}"
+    error: "missing"
+  - expr: "{!greeting()}"
+    error: "missing"
+`, assert)
+
+	f, err := Load(fname)
+	assert.NoError(err)
+	c, err := compiler.NewCompiler()
+	assert.NoError(err)
+	results := Run(c, f)
+	assert.Len(results, 8)
+	assert.True(results[0].Passed(), results[0].Failures)
+
+	got := failures(results)
+	assert.Equal([]string{"evaluation failed: ran out of scripted rolls"}, got["scripted out"])
+	assert.Equal([]string{"evaluation failed: scripted roll 2 is outside 0-1"}, got["scripted range"])
+	assert.Len(got["golden"], 1)
+	assert.Contains(got["golden"][0], "output differs:\n  expected: \"Bob\"")
+	assert.Len(got["match"], 2)
+	assert.Contains(got["match"][0], `assert {eq(@result, "Bob")} is false for output`)
+	assert.Contains(got["match"][1], `assert {@result} failed: must give an integer`)
+	assert.Empty(got["locale"])
+	assert.Empty(got["error"])
+	assert.Equal([]string{`expected an error containing "missing", got output "hello"`}, got["test 8"])
+
+	// The same seed gives the same output.
+	first := results[3].Failures[0]
+	again := Run(c, f)
+	assert.Equal(first, again[3].Failures[0])
+}
+
+func TestProperties(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	writeFile(dir, "dungeon.tman", testPack, assert)
+	fname := writeFile(dir, "dungeon.tmantest", `pack: dungeon.tman
+tests:
+  - name: hoard
+    expr: "{!hoard()}"
+    runs: 50
+    range: {min: 100, max: 400}
+    always: ['{gte(int(@result), 100)}']
+    reachable: [hoard]
+  - name: small hoard
+    expr: "{!hoard()}"
+    runs: 50
+    range: {max: 300}
+  - name: lookup
+    expr: "{!lookup(index, 1d6?)}"
+    runs: 30
+    never: ['{eq(@result, "none")}']
+    reachable: [lookup, missing]
+  - name: monster
+    expr: "{!monster()}"
+    runs: 20
+    reachable: [monster]
+    never: ['{eq(@result, "a rat")}']
+`, assert)
+
+	f, err := Load(fname)
+	assert.NoError(err)
+	c, err := compiler.NewCompiler()
+	assert.NoError(err)
+	got := failures(Run(c, f))
+	assert.Empty(got["hoard"])
+	assert.Len(got["small hoard"], 1)
+	assert.Regexp(`^run \d+ \(seed \d+\): output 400 is above the range maximum 300$`, got["small hoard"][0])
+	assert.Equal([]string{
+		"table 'lookup' never picked row 3 in 30 runs",
+		"no table 'missing' to check rows are reachable",
+	}, got["lookup"])
+	assert.Len(got["monster"], 1)
+	assert.Regexp(`^run \d+ \(seed \d+\): never {eq\(@result, "a rat"\)} is true for output "a rat"$`, got["monster"][0])
+
+	_, err = Load(writeFile(dir, "bad.tmantest", "pack: a.tman\ntests:\n  - expr: x\n    runs: 2\n    rolls: [1]", assert))
+	assert.Error(err)
+}
+
+func TestDiscover(t *testing.T) {
+	assert := assert.New(t)
+	dir := t.TempDir()
+	writeFile(dir, "b.tmantest", "pack: a.tman", assert)
+	writeFile(dir, "sub/a.tmantest", "pack: a.tman", assert)
+	writeFile(dir, "a.tman", testPack, assert)
+
+	files, err := Discover([]string{dir})
+	assert.NoError(err)
+	assert.Equal([]string{filepath.Join(dir, "b.tmantest"), filepath.Join(dir, "sub/a.tmantest")}, files)
+
+	_, err = Load(writeFile(dir, "bad.tmantest", "tests: []", assert))
+	assert.Error(err)
+	_, err = Load(writeFile(dir, "bad.tmantest", "pack: a.tman\ntests:\n  - name: x", assert))
+	assert.Error(err)
+}
+
+func TestDiff(t *testing.T) {
+	assert := assert.New(t)
+	assert.Equal("  expected: \"an orc\"\n       got: \"an ogre\"\n                 ^", Diff("an orc", "an ogre"))
+	assert.Equal("  a\n- b\n+ c\n+ d", Diff("a\nb", "a\nc\nd"))
+}
@@ -0,0 +1,278 @@
+package packtest
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	compiler "github.com/wingerjc/tableman-golang/pkg/compile"
+	"github.com/wingerjc/tableman-golang/pkg/program"
+)
+
+// Result is the result of a single test.
+type Result struct {
+	File     string
+	Name     string
+	Failures []string
+}
+
+// Passed returns whether the test had no failures.
+func (r *Result) Passed() bool {
+	return len(r.Failures) == 0
+}
+
+func (r *Result) fail(format string, vals ...interface{}) {
+	r.Failures = append(r.Failures, fmt.Sprintf(format, vals...))
+}
+
+// Run runs the tests of a file against its compiled pack. A pack that doesn't
+// compile fails every test in the file.
+func Run(c *compiler.Compiler, f *File) []*Result {
+	results := make([]*Result, 0, len(f.Tests))
+	prog, err := c.CompileFile(f.PackFile())
+	for _, tc := range f.Tests {
+		result := &Result{File: f.fname, Name: tc.Name}
+		if err != nil {
+			result.fail("could not compile pack '%s': %s", f.PackFile(), err.Error())
+		} else {
+			runCase(c, prog, f, tc, result)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// runCase runs a test on fresh copies of the program, each run uses the next
+// seed. The failures of the first failing run are reported.
+func runCase(c *compiler.Compiler, compiled *program.Program, f *File, tc *Case, result *Result) {
+	expr, err := c.CompileExpression(tc.Expr)
+	if err != nil {
+		result.fail("could not compile expr: %s", err.Error())
+		return
+	}
+	// Copies of the base share its row counts for the reachable check.
+	base := compiled.Copy()
+	if len(tc.Reachable) > 0 {
+		base.SetCoverage(true)
+	}
+	seed := caseSeed(f, tc)
+	for i := 0; i < tc.runs(); i++ {
+		prog := base.Copy()
+		if len(tc.Rolls) > 0 {
+			prog.SetRandom(&scriptedRolls{program.NewTestRandSource(tc.Rolls...)})
+		} else {
+			prog.SetRandom(program.NewSeededRandSource(seed + int64(i)))
+		}
+		if len(tc.Locale) > 0 {
+			prog.SetLocale(tc.Locale)
+		}
+		run := &Result{}
+		runOnce(c, prog, expr, tc, run)
+		if !run.Passed() {
+			for _, msg := range run.Failures {
+				if tc.runs() > 1 {
+					msg = fmt.Sprintf("run %d (seed %d): %s", i+1, seed+int64(i), msg)
+				}
+				result.fail("%s", msg)
+			}
+			return
+		}
+	}
+	checkReachable(base, tc, result)
+}
+
+func runOnce(c *compiler.Compiler, prog *program.Program, expr program.Evallable, tc *Case, result *Result) {
+	out, err := eval(prog, expr)
+	if len(tc.Error) > 0 {
+		if err == nil {
+			result.fail("expected an error containing %q, got output %q", tc.Error, out.StringVal())
+		} else if !strings.Contains(err.Error(), tc.Error) {
+			result.fail("expected an error containing %q, got %q", tc.Error, err.Error())
+		}
+		return
+	}
+	if err != nil {
+		result.fail("evaluation failed: %s", err.Error())
+		return
+	}
+	checkOutput(c, prog, tc, out, result)
+}
+
+// checkOutput checks the output of a test against its expected output, regex,
+// range and assertions.
+func checkOutput(c *compiler.Compiler, prog *program.Program, tc *Case, out *program.ExpressionResult, result *Result) {
+	text := resultString(out)
+	if tc.Expect != nil && text != *tc.Expect {
+		result.fail("output differs:\n%s", Diff(*tc.Expect, text))
+	}
+	if len(tc.Match) > 0 {
+		re, err := regexp.Compile(tc.Match)
+		if err != nil {
+			result.fail("bad match pattern %q: %s", tc.Match, err.Error())
+		} else if !re.MatchString(text) {
+			result.fail("output %q doesn't match %q", text, tc.Match)
+		}
+	}
+	if tc.Range != nil {
+		checkRange(tc.Range, text, result)
+	}
+	for _, a := range tc.Assert {
+		checkAssertion(c, prog, "assert", a, true, out, result)
+	}
+	for _, a := range tc.Always {
+		checkAssertion(c, prog, "always", a, true, out, result)
+	}
+	for _, a := range tc.Never {
+		checkAssertion(c, prog, "never", a, false, out, result)
+	}
+}
+
+// checkAssertion checks an assertion gives want for the output.
+func checkAssertion(c *compiler.Compiler, prog *program.Program, kind string, assertion string, want bool,
+	out *program.ExpressionResult, result *Result) {
+	ok, err := check(c, prog, assertion, out)
+	if err != nil {
+		result.fail("%s %s failed: %s", kind, assertion, err.Error())
+	} else if ok != want {
+		result.fail("%s %s is %t for output %q", kind, assertion, ok, resultString(out))
+	}
+}
+
+func checkRange(r *Range, text string, result *Result) {
+	n, err := strconv.Atoi(strings.TrimSpace(text))
+	if err != nil {
+		result.fail("output %q is not a number", text)
+		return
+	}
+	if r.Min != nil && n < *r.Min {
+		result.fail("output %d is below the range minimum %d", n, *r.Min)
+	}
+	if r.Max != nil && n > *r.Max {
+		result.fail("output %d is above the range maximum %d", n, *r.Max)
+	}
+}
+
+// checkReachable checks every row of the reachable tables was picked in a run.
+func checkReachable(prog *program.Program, tc *Case, result *Result) {
+	for _, name := range tc.Reachable {
+		table, ok := prog.Packs()[0].Table(name, tc.Locale)
+		if !ok {
+			result.fail("no table '%s' to check rows are reachable", name)
+			continue
+		}
+		missed := make([]string, 0)
+		for i, r := range table.Rows() {
+			if r.Hits() > 0 {
+				continue
+			}
+			if len(r.Label()) > 0 {
+				missed = append(missed, r.Label())
+			} else {
+				missed = append(missed, fmt.Sprintf("row %d", i+1))
+			}
+		}
+		if len(missed) > 0 {
+			result.fail("table '%s' never picked %s in %d runs", name, strings.Join(missed, ", "), tc.runs())
+		}
+	}
+}
+
+// check evaluates an assertion with `@result` set to the output.
+func check(c *compiler.Compiler, prog *program.Program, assertion string, out *program.ExpressionResult) (bool, error) {
+	expr, err := c.CompileExpression(assertion)
+	if err != nil {
+		return false, err
+	}
+	res, err := prog.EvalWith(expr, map[string]*program.ExpressionResult{ResultVar: out})
+	if err != nil {
+		return false, err
+	}
+	if !res.MatchType(program.IntResult) {
+		return false, fmt.Errorf("must give an integer, gave %q", res.StringVal())
+	}
+	return res.BoolVal(), nil
+}
+
+// rollError stops an evaluation when the scripted rolls don't fit.
+type rollError struct {
+	err error
+}
+
+// scriptedRolls is a TestingRandSource that stops the evaluation when it runs
+// out of rolls or a roll is out of range.
+type scriptedRolls struct {
+	*program.TestingRandSource
+}
+
+// Get implementation for RandomSource.
+func (r *scriptedRolls) Get(low int, high int) int {
+	if r.Remaining() == 0 {
+		panic(rollError{fmt.Errorf("ran out of scripted rolls")})
+	}
+	result := r.TestingRandSource.Get(low, high)
+	if result < low || result >= high {
+		panic(rollError{fmt.Errorf("scripted roll %d is outside %d-%d", result, low, high-1)})
+	}
+	return result
+}
+
+// eval evaluates an expression, scripted rolls that don't fit are an error.
+func eval(prog *program.Program, expr program.Evallable) (out *program.ExpressionResult, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			re, ok := r.(rollError)
+			if !ok {
+				panic(r)
+			}
+			out, err = nil, re.err
+		}
+	}()
+	return prog.Eval(expr)
+}
+
+func caseSeed(f *File, tc *Case) int64 {
+	if tc.Seed != nil {
+		return *tc.Seed
+	}
+	if f.Seed != nil {
+		return *f.Seed
+	}
+	return DefaultSeed
+}
+
+func resultString(r *program.ExpressionResult) string {
+	if r.MatchType(program.StringResult) {
+		return r.StringVal()
+	}
+	return strconv.Itoa(r.IntVal())
+}
+
+// Diff shows where the output differs from the expected output, with a caret
+// under the first different character for single lines and the lines that
+// differ otherwise.
+func Diff(expected string, actual string) string {
+	if !strings.Contains(expected, "\n") && !strings.Contains(actual, "\n") {
+		exp, act := strconv.Quote(expected), strconv.Quote(actual)
+		i := 0
+		for i < len(exp) && i < len(act) && exp[i] == act[i] {
+			i++
+		}
+		return fmt.Sprintf("  expected: %s\n       got: %s\n            %s^", exp, act, strings.Repeat(" ", i))
+	}
+	expLines, actLines := strings.Split(expected, "\n"), strings.Split(actual, "\n")
+	var b strings.Builder
+	for i := 0; i < len(expLines) || i < len(actLines); i++ {
+		switch {
+		case i >= len(actLines):
+			fmt.Fprintf(&b, "- %s\n", expLines[i])
+		case i >= len(expLines):
+			fmt.Fprintf(&b, "+ %s\n", actLines[i])
+		case expLines[i] == actLines[i]:
+			fmt.Fprintf(&b, "  %s\n", expLines[i])
+		default:
+			fmt.Fprintf(&b, "- %s\n+ %s\n", expLines[i], actLines[i])
+		}
+	}
+	return strings.TrimRight(b.String(), "\n")
+}
@@ -0,0 +1,58 @@
+package parser
+
+import (
+	"strings"
+	"unicode"
+)
+
+// addDocs sets the doc comments of the pack and its tables from the comment
+// lines right before them.
+func addDocs(code string, file *TableFile) {
+	lines := strings.Split(strings.ReplaceAll(code, "\r\n", "\n"), "\n")
+	if file.Header != nil {
+		file.Header.Doc = docComment(lines, file.Header.Pos.Line)
+	}
+	for _, t := range file.Tables {
+		if t != nil {
+			t.Doc = docComment(lines, t.Pos.Line)
+		}
+	}
+}
+
+// docComment returns the comment lines right above the given line number,
+// without the `#` and the space after it.
+func docComment(lines []string, line int) []string {
+	end := line - 1
+	if end > len(lines) {
+		return nil
+	}
+	start := end
+	for start > 0 && strings.HasPrefix(strings.TrimSpace(lines[start-1]), "#") {
+		start--
+	}
+	if start == end {
+		return nil
+	}
+	result := make([]string, 0, end-start)
+	for _, l := range lines[start:end] {
+		l = strings.TrimPrefix(strings.TrimSpace(l), "#")
+		result = append(result, strings.TrimRight(strings.TrimPrefix(l, " "), " \t"))
+	}
+	// Drop separator lines like `#-----` around the comment.
+	for len(result) > 0 && !hasWord(result[0]) {
+		result = result[1:]
+	}
+	for len(result) > 0 && !hasWord(result[len(result)-1]) {
+		result = result[:len(result)-1]
+	}
+	if len(result) == 0 {
+		return nil
+	}
+	return result
+}
+
+func hasWord(line string) bool {
+	return strings.IndexFunc(line, func(r rune) bool {
+		return unicode.IsLetter(r) || unicode.IsDigit(r)
+	}) >= 0
+}
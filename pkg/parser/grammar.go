@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"path/filepath"
 	"strconv"
 	"strings"
 
@@ -29,22 +30,26 @@ const (
 
 	// VarExprT the type value for a variable value expression.
 	VarExprT ValueExprType = 6
+
+	// InlineExprT the type value for an inline table value expression.
+	InlineExprT ValueExprType = 7
 )
 
 var (
 	// DefaultLexer is a default lexer for the tableman language.
 	DefaultLexer = participle.Lexer(fileLexer)
 	// DefaultElide the default list of tokens to elide from AST parsing.
-	DefaultElide = participle.Elide("Comment", "Whitespace", "CommentLine")
+	DefaultElide = participle.Elide("Comment", "Whitespace", "CommentLine", "RollComment")
 	// ExprTypeStr a human readable map of expression value types for debugging.
 	ExprTypeStr = map[ValueExprType]string{
-		NoneExprT:  "None",
-		RollExprT:  "Roll",
-		LabelExprT: "Label",
-		NumExprT:   "Number",
-		TableExprT: "Table",
-		FuncExprT:  "Function",
-		VarExprT:   "Variable",
+		NoneExprT:   "None",
+		RollExprT:   "Roll",
+		LabelExprT:  "Label",
+		NumExprT:    "Number",
+		TableExprT:  "Table",
+		FuncExprT:   "Function",
+		VarExprT:    "Variable",
+		InlineExprT: "Inline",
 	}
 )
 
@@ -55,6 +60,9 @@ var (
 //    <FileHeader>
 //    (<EOL>+ <Table> <TableBarrier>?)*
 // `TableBarrier` is at least 3 dashes on its own line.
+//
+// Comment lines right before `TablePack:` or `TableDef:` are kept as the doc
+// comment of the pack or table.
 type TableFile struct {
 	Pos    lexer.Position
 	Header *FileHeader `parser:"EOL* @@"`
@@ -66,14 +74,18 @@ type TableFile struct {
 //  Pattern:
 //    TablePack: <ExtendedTableName>
 //    (<EOL> <ImportStatement>)*
+//    (<EOL> <TableData>)*
 //
 //  Example:
 //    TablePack: foo.bar.baz
 //    Import f"~/quz/util.tman" As: q.util
+//    TableData: f"./monsters.csv" As: monsters
 type FileHeader struct {
 	Pos     lexer.Position
+	Doc     []string
 	Name    *ExtendedTableName `parser:"PkgStart @@"`
-	Imports []*ImportStatement `parser:"(EOL @@)*"`
+	Imports []*ImportStatement `parser:"((?= EOL Import) EOL @@)*"`
+	Data    []*TableData       `parser:"((?= EOL DataStart) EOL @@)*"`
 }
 
 // ImportStatement is an AST node that denotes an imported file.
@@ -95,41 +107,102 @@ func (i *ImportStatement) File() string {
 	return i.FileName[2 : len(i.FileName)-1]
 }
 
+// TableData is an AST node for a table with rows read from a csv, tsv, json or
+// yaml file. The table is named after the file unless it has an alias.
+//
+//  Pattern:
+//    TableData: <FilePath> (As: <TableName>)?
+//
+//  Example:
+//    TableData: f"./monsters.csv" As: monsters
+type TableData struct {
+	Pos      lexer.Position
+	FileName string  `parser:"DataStart @FilePath"`
+	Alias    *string `parser:"(PackAlias @TableName)?"`
+}
+
+// File returns the actual file name and not the parsed token.
+func (d *TableData) File() string {
+	return d.FileName[2 : len(d.FileName)-1]
+}
+
+// TableName returns the alias, or the file name without directories and extension.
+func (d *TableData) TableName() string {
+	if d.Alias != nil {
+		return *d.Alias
+	}
+	name := filepath.Base(d.File())
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
 // Table is an AST node that denotes a single table.
 //
 // It can be provided either a list of table rows or a single generator row to
-// programatically create rows from.
+// programatically create rows from, but not both. Only tables that extend
+// another table can have no rows.
 //
 //  Pattern:
 //    <TableHeader>
-//    (
-//        (<EOL> <TableRow>)+
-//      | (<EOL> <GeneratorTableRow>)
-//    )
+//    (<EOL> <GeneratorTableRow>)?
+//    (<EOL> <TableRow>)*
 type Table struct {
 	Pos       lexer.Position
+	Doc       []string
 	Header    *TableHeader       `parser:"@@"`
-	Rows      []*TableRow        `parser:"((EOL @@)+"`
-	Generator *GeneratorTableRow `parser:"| (EOL@@))"`
+	Generator *GeneratorTableRow `parser:"((?= EOL GenStart (ExprStart (~ExprEnd)* ExprEnd | ~(ChoiceDelimiter | GenEnd))* GenEnd) EOL @@)?"`
+	Rows      []*TableRow        `parser:"(EOL @@)*"`
 }
 
 // TableHeader is an AST node that denotes meta information about a table.
 //
-// Tags are currently transferred in compilation, but not otherwise accessible in the
-// execution engine. This may change in the future.
+// Tags can be read at runtime with the `tag` function.
+//
+// A table can extend another table, starting with its rows and tags. Rows of
+// the parent can be removed or reweighted by label, rows of the extending
+// table replace parent rows with the same label and the rest are added.
+//
+// Generator tables can exclude generated rows by label.
 //
 //  Pattern:
 //    TableDef: <TableName>
+//    (<EOL>+ Extends: <ExtendedTableName>)?
+//    (<EOL>+ Remove: <Label> (, <Label>)*)*
+//    (<EOL>+ Reweight: <Reweight> (, <Reweight>)*)*
+//    (<EOL>+ Exclude: <Label> (, <Label>)*)*
 //    (<EOL>+ <Tag>)*
 //
 //  Example:
 //    TableDef: GreekNames
 //    ~ something: something-else
 //    ~ "With spaces": "needs quotes"
+//
+//    TableDef: swamp-encounters
+//    Extends: base.encounters
+//    Remove: wolf, bear
+//    Reweight: zombie w=5
+//
+//    TableDef: cards
+//    Exclude: "Joker of Clubs"
 type TableHeader struct {
-	Pos  lexer.Position
-	Name string `parser:"TableStart @TableName"`
-	Tags []*Tag `parser:"(EOL+ @@)*"`
+	Pos      lexer.Position
+	Name     string             `parser:"TableStart @TableName"`
+	Extends  *ExtendedTableName `parser:"((?= EOL+ Extends) EOL+ Extends @@)?"`
+	Remove   []*LabelString     `parser:"((?= EOL+ Remove) EOL+ Remove @@ (ListDelimiter @@)*)*"`
+	Reweight []*Reweight        `parser:"((?= EOL+ Reweight) EOL+ Reweight @@ (ListDelimiter @@)*)*"`
+	Exclude  []*LabelString     `parser:"((?= EOL+ Exclude) EOL+ Exclude @@ (ListDelimiter @@)*)*"`
+	Tags     []*Tag             `parser:"((?= EOL+ TagStart) EOL+ @@)*"`
+}
+
+// Reweight is an AST node that sets the weight of an extended table row.
+//
+//  Pattern:
+//    <Label> w=<Number>
+//
+//  Example:
+//    zombie w=5
+type Reweight struct {
+	Label  LabelString `parser:"@@ WeightMarker"`
+	Weight int         `parser:"@Number"`
 }
 
 // Tag is an AST node that denotes a meta tag.
@@ -137,14 +210,39 @@ type TableHeader struct {
 // Useful for tagging author, source, copyright/license, or other information.
 //
 //  Pattern:
-//    ~ <Label>: <Label>
+//    ~ <Label>: <TagValue>
 //
 //  Example:
 //    ~ foo: bar
+//    ~ avoid-repeat: 5
 type Tag struct {
 	Pos   lexer.Position
 	Key   LabelString `parser:"TagStart @@ TableDelimiter"`
-	Value LabelString `parser:"@@"`
+	Value TagValue    `parser:"@@"`
+}
+
+// TagValue is an AST node for the value of a tag, a label, dice or a number.
+//
+//  Pattern:
+//    <Label> | <Number>d<Number> ( (l | h) <Number>)? (. <RollFuncAggr>)? | <Number>
+//
+//  Example:
+//    2d6
+type TagValue struct {
+	Label  *LabelString `parser:"@@"`
+	Dice   *string      `parser:"| @(Roll (RollSubset Number)? RollFuncAggr?)"`
+	Number *string      `parser:"| @Number"`
+}
+
+// String returns the tag value as a string.
+func (v *TagValue) String() string {
+	if v.Dice != nil {
+		return *v.Dice
+	}
+	if v.Number != nil {
+		return *v.Number
+	}
+	return v.Label.String()
 }
 
 // GeneratorTableRow is an AST node that denotes an ordered list of row generation steps.
@@ -161,18 +259,49 @@ type GeneratorTableRow struct {
 // GeneratorStep is an AST node that denotes a list of generation targets.
 //
 //  Pattern:
-//    [ <Label> (, <EOL>? <Label>)* ]
+//    [ <GeneratorValue> (, <EOL>? <GeneratorValue>)* ]
 //
 //  Example:
 //    ["x", "y", "z"]
+//    [w=2 A: "Ace", K: "King", {!suit()}]
 type GeneratorStep struct {
-	Values []string `parser:"GenStart @String (ListDelimiter EOL? @String)* GenEnd"`
+	Values []*GeneratorValue `parser:"GenStart EOL? @@ (ListDelimiter EOL? @@)* EOL? GenEnd"`
+}
+
+// GeneratorValue is an AST node for one value of a generation step.
+//
+// Weights and counts multiply across the steps of a generated row. If any value
+// has a label the labels are joined, a string value is its own label unless it
+// has one.
+//
+//  Pattern:
+//    (w=<Number>)? (c=<Number>)? (<Label> :)? (<String> | <Expression>)
+//
+//  Example:
+//    w=3 c=2 K: "King"
+type GeneratorValue struct {
+	Pos        lexer.Position
+	Weight     int          `parser:"(WeightMarker @Number)?"`
+	Count      int          `parser:"(CountMarker @Number)?"`
+	Label      *LabelString `parser:"((?= (TableName | String) TableDelimiter) @@ TableDelimiter)?"`
+	StringVal  *string      `parser:"(@String"`
+	Expression *Expression  `parser:"| @@)"`
 }
 
-// StrVal returns the actual string to be generated at the given index. Convenience method.
-func (s *GeneratorStep) StrVal(index int) string {
-	v := s.Values[index]
-	return v[1 : len(v)-1]
+// String returns the unquoted string value, or empty for expressions. Convenience method.
+func (v *GeneratorValue) String() string {
+	if v.StringVal == nil {
+		return ""
+	}
+	return (*v.StringVal)[1 : len(*v.StringVal)-1]
+}
+
+// LabelVal returns the label the value adds to a generated row label. Convenience method.
+func (v *GeneratorValue) LabelVal() string {
+	if v.Label != nil {
+		return v.Label.String()
+	}
+	return v.String()
 }
 
 // TableRow is an AST node that denotes a single table row.
@@ -183,21 +312,33 @@ func (s *GeneratorStep) StrVal(index int) string {
 //
 // A row needs at least one value, but all values will be concatenated as strings.
 //
+// Weights can be an expression evaluated at roll time, and rows with a `when`
+// condition are only rolled if the condition evaluates to a non-zero integer.
+//
+// Tags after the values mark the row so rolls can be filtered to tagged rows.
+//
 //  Pattern:
-//    Default? (w=<Number>)? (c=<number>)? <RangeList>? <Label>? :?
+//    Default? (w=(<Number> | <Expression>))? (c=<number>)? (when <Expression>)?
+//    <RangeList>? <Label>? :?
 //    (<RowItem> (-> <EOL>)? )+
+//    (~ <Label>)*
 //
 //  Example:
 //    w=5 Hard-TH: "th" ->
 //        "'"
+//    w={@level} when {gt(@level, 5)}: "a dragon"
+//    "zombie" ~ undead ~ slow
 type TableRow struct {
-	Pos     lexer.Position
-	Default bool         `parser:"(@Default?"`
-	Weight  int          `parser:"(WeightMarker @Number)?"`
-	Count   int          `parser:"(CountMarker @Number)?"`
-	Numbers *RangeList   `parser:"@@?"`
-	Label   *LabelString `parser:"@@? ':')?"`
-	Values  []*RowItem   `parser:"(@@ (ExtendLine EOL)? )+"`
+	Pos        lexer.Position
+	Default    bool           `parser:"(@Default?"`
+	Weight     int            `parser:"(WeightMarker (@Number"`
+	WeightExpr *Expression    `parser:"| @@))?"`
+	Count      int            `parser:"(CountMarker @Number)?"`
+	When       *Expression    `parser:"((?= 'when' ExprStart) 'when' @@)?"`
+	Numbers    *RangeList     `parser:"@@?"`
+	Label      *LabelString   `parser:"@@? ':')?"`
+	Values     []*RowItem     `parser:"(@@ (ExtendLine EOL)? )+"`
+	Tags       []*LabelString `parser:"(TagStart @@)*"`
 }
 
 // RowItem is an AST node that denotes a single value to be concatenated in a row.
@@ -205,11 +346,35 @@ type TableRow struct {
 // The line extension `->` can be used to shorten longer lines for readability.
 //
 //  Pattern:
-//    (<Label> | <Expression>)
+//    (<Label> | <Expression> | <InlineTable>)
 type RowItem struct {
 	Pos        lexer.Position
-	StringVal  *string     `parser:"(@String"`
-	Expression *Expression `parser:"| @@)"`
+	Tokens     []lexer.Token
+	StringVal  *string      `parser:"(@String"`
+	Expression *Expression  `parser:"| @@"`
+	Inline     *InlineTable `parser:"| @@)"`
+}
+
+// InlineTable is an AST node for an anonymous table in a row or expression,
+// one option is picked by weight each time it's evaluated.
+//
+//  Pattern:
+//    [ <InlineOption> (| <InlineOption>)+ ]
+//
+//  Example:
+//    [ "red" | w=3 "blue" | "green" {1d4?} ]
+type InlineTable struct {
+	Pos     lexer.Position
+	Options []*InlineOption `parser:"GenStart EOL? @@ (EOL? ChoiceDelimiter EOL? @@)+ EOL? GenEnd"`
+}
+
+// InlineOption is an AST node for one option of an inline table.
+//
+//  Pattern:
+//    (w=<Number>)? <RowItem>+
+type InlineOption struct {
+	Weight int        `parser:"(WeightMarker @Number)?"`
+	Values []*RowItem `parser:"@@+"`
 }
 
 // String returns the wrapped passed string. Convenience method.
@@ -339,13 +504,14 @@ type RollCountAggr struct {
 
 // FinalMult returns the final signed multiplier for the node
 func (r *RollCountAggr) FinalMult() int {
-	if r.Multiplier == 0 {
-		r.Multiplier = 1
+	mult := r.Multiplier
+	if mult == 0 {
+		mult = 1
 	}
 	if r.Sign[1:] == "-" {
-		return -1 * r.Multiplier
+		return -1 * mult
 	}
-	return r.Multiplier
+	return mult
 }
 
 // Expression is an AST node for an expression that can have variables defined within it.
@@ -360,9 +526,10 @@ func (r *RollCountAggr) FinalMult() int {
 //  Example:
 //    { @foo=8, @bar=1d8?; add(@foo, @bar) }
 type Expression struct {
-	Pos   lexer.Position
-	Vars  []*VariableDef `parser:"ExprStart EOL? (@@ (ListDelimiter EOL? @@)* EndVarList EOL?)?"`
-	Value *ValueExpr     `parser:"@@ EOL? ExprEnd"`
+	Pos    lexer.Position
+	Tokens []lexer.Token
+	Vars   []*VariableDef `parser:"ExprStart EOL? ((?= VarPrefix TableName VarAssign) @@ (ListDelimiter EOL? @@)* EndVarList EOL?)?"`
+	Value  *ValueExpr     `parser:"@@ EOL? ExprEnd"`
 }
 
 // VariableDef is an AST node for defining a variable.
@@ -382,14 +549,27 @@ type VariableDef struct {
 // Table calls are delineated by starting with an exclamation point.
 //
 //  Pattern:
-//    !? <ExtendedTableName> <(> (<ValueExpr> (, <EOL>? <ValueExpr>)* )? <)>
+//    !? <ExtendedTableName> <(> (<CallParam> (, <EOL>? <CallParam>)* )? <)>
 //
 //  Example:
 //    !CardDeck(deck, shuffle)
+//    !monsters(roll, tag="undead")
 type Call struct {
 	IsTable bool              `parser:"@TableCallSignal?"`
 	Name    ExtendedTableName `parser:"@@ CallStart EOL?"`
-	Params  []*ValueExpr      `parser:"(@@ (ListDelimiter EOL? @@)* )? EOL? CallEnd"`
+	Params  []*CallParam      `parser:"(@@ (ListDelimiter EOL? @@)* )? EOL? CallEnd"`
+}
+
+// CallParam is an AST node for a call parameter, optionally named.
+//
+//  Pattern:
+//    (<TableName> =)? <ValueExpr>
+//
+//  Example:
+//    tag="undead"
+type CallParam struct {
+	Name  *string    `parser:"((?= TableName ParamAssign) @TableName ParamAssign)?"`
+	Value *ValueExpr `parser:"@@"`
 }
 
 // ValueExpr is an AST node for expressions that can return values.
@@ -401,38 +581,39 @@ type Call struct {
 //  | <Call>
 //  | <LabelString>
 //  | <VarName>
+//  | <InlineTable>
 type ValueExpr struct {
 	Roll     *Roll        `parser:"@@"`
 	Num      *int         `parser:"| (@Number | @Integer)"`
 	Call     *Call        `parser:"| @@"`
 	Label    *LabelString `parser:"| @@"`
 	Variable *VarName     `parser:"| @@"`
-	exprType ValueExprType
+	Inline   *InlineTable `parser:"| @@"`
 }
 
 // ValueExprType is an enum type for denoting a ValueExpr stored type.
 type ValueExprType int
 
-// GetType resolves and caches the type of this ValueExpr.
+// GetType resolves the type of this ValueExpr. Parse trees are shared between
+// compilations, so it doesn't change the node.
 func (v *ValueExpr) GetType() ValueExprType {
-	if v.exprType != NoneExprT {
-		return v.exprType
-	} else if v.Roll != nil {
-		v.exprType = RollExprT
+	if v.Roll != nil {
+		return RollExprT
 	} else if v.Num != nil {
-		v.exprType = NumExprT
+		return NumExprT
 	} else if v.Label != nil {
-		v.exprType = LabelExprT
+		return LabelExprT
 	} else if v.Call != nil {
 		if v.Call.IsTable {
-			v.exprType = TableExprT
-		} else {
-			v.exprType = FuncExprT
+			return TableExprT
 		}
+		return FuncExprT
 	} else if v.Variable != nil {
-		v.exprType = VarExprT
+		return VarExprT
+	} else if v.Inline != nil {
+		return InlineExprT
 	}
-	return v.exprType
+	return NoneExprT
 }
 
 // GetStringType returns the string version of the type value for debugging.
@@ -491,7 +672,12 @@ var (
 			{Name: "PkgStart", Pattern: `TablePack:`},
 			{Name: "TableStart", Pattern: `TableDef:`},
 			{Name: "Import", Pattern: `Import:`},
+			{Name: "DataStart", Pattern: `TableData:`},
 			{Name: "PackAlias", Pattern: `As:`},
+			{Name: "Extends", Pattern: `Extends:`},
+			{Name: "Remove", Pattern: `Remove:`},
+			{Name: "Reweight", Pattern: `Reweight:`},
+			{Name: "Exclude", Pattern: `Exclude:`},
 			{Name: "WeightMarker", Pattern: `w=`},
 			{Name: "CountMarker", Pattern: `c=`},
 			{Name: "ExtendLine", Pattern: `->`},
@@ -501,14 +687,13 @@ var (
 			{Name: "TableDelimiter", Pattern: `:`},
 			{Name: "RangeDash", Pattern: `-`},
 			{Name: "TagStart", Pattern: `~`},
-			{Name: "GenStart", Pattern: `\[`},
-			{Name: "GenEnd", Pattern: `]`},
 		},
 		"Atomic": []lexer.Rule{
 			{Name: "TableName", Pattern: identifierPat},
 			{Name: "Roll", Pattern: naturalNumberPat + `d` + naturalNumberPat, Action: lexer.Push("Roll")},
 			{Name: "CallStart", Pattern: `\(`, Action: lexer.Push("Call")},
 			{Name: "ExprStart", Pattern: `{`, Action: lexer.Push("Expr")},
+			{Name: "GenStart", Pattern: `\[`, Action: lexer.Push("Choice")},
 			{Name: "String", Pattern: `"(\\"|[^"])*"`},
 			lexer.Include("NumberRule"),
 			{Name: "TableCallSignal", Pattern: `\!`},
@@ -525,6 +710,11 @@ var (
 			{Name: "RollCast", Pattern: `\.str`},
 			{Name: "RollEnd", Pattern: `\?`, Action: lexer.Pop()},
 			lexer.Include("NumberRule"),
+			// Dice in tag values end with the line instead of a `?`. Comments
+			// stop before the line end so it still ends the roll.
+			{Name: "Whitespace", Pattern: `[ \t]+`},
+			{Name: "RollComment", Pattern: `#[^\r\n]*`},
+			{Name: "EOL", Pattern: `\r?\n`, Action: lexer.Pop()},
 		},
 		"Expr": []lexer.Rule{
 			lexer.Include("Whitespace"),
@@ -538,8 +728,18 @@ var (
 			lexer.Include("Whitespace"),
 			lexer.Include("Atomic"),
 			lexer.Include("ExprValues"),
+			{Name: "ParamAssign", Pattern: `=`},
 			{Name: "CallEnd", Pattern: `\)`, Action: lexer.Pop()},
 		},
+		"Choice": []lexer.Rule{
+			lexer.Include("Whitespace"),
+			{Name: "WeightMarker", Pattern: `w=`},
+			{Name: "CountMarker", Pattern: `c=`},
+			{Name: "TableDelimiter", Pattern: `:`},
+			{Name: "ChoiceDelimiter", Pattern: `\|`},
+			{Name: "GenEnd", Pattern: `]`, Action: lexer.Pop()},
+			lexer.Include("Atomic"),
+		},
 		"Whitespace": []lexer.Rule{
 			{Name: "Comment", Pattern: `#.*$`},
 			{Name: "CommentLine", Pattern: `^[ \t]*#.*\r?\n`},
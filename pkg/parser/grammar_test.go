@@ -9,7 +9,7 @@ import (
 )
 
 func parserTypeWithDefaultOptions(t interface{}) (*participle.Parser, error) {
-	return participle.Build(t, participle.Lexer(fileLexer), participle.Elide("Comment", "Whitespace", "CommentLine"))
+	return participle.Build(t, participle.Lexer(fileLexer), DefaultElide)
 }
 
 func TestNumberRanges(t *testing.T) {
@@ -186,6 +186,39 @@ func TestTableRow(t *testing.T) {
 	if print {
 		pp.Println(val)
 	}
+
+	// Row tags.
+	val = &TableRow{}
+	err = parser.ParseString("", `zombie: "zombie" {1} ~ undead ~ "slow walker"`, val)
+	assert.NoError(err)
+	assert.Len(val.Values, 2)
+	assert.Len(val.Tags, 2)
+	assert.Equal("undead", val.Tags[0].String())
+	assert.Equal("slow walker", val.Tags[1].String())
+	if print {
+		pp.Println(val)
+	}
+
+	// Conditions, `when` only starts one before an expression.
+	val = &TableRow{}
+	err = parser.ParseString("", `w={@level} when {gt(@level, 5)}: "a dragon"`, val)
+	assert.NoError(err)
+	assert.NotNil(val.WeightExpr)
+	assert.NotNil(val.When)
+	if print {
+		pp.Println(val)
+	}
+
+	for _, row := range []string{`when: "now"`, `when-ready: "soon"`, `whenever: "later"`} {
+		val = &TableRow{}
+		err = parser.ParseString("", row, val)
+		assert.NoError(err, row)
+		assert.Nil(val.When, row)
+	}
+	val = &TableRow{}
+	err = parser.ParseString("", `when: "now"`, val)
+	assert.NoError(err)
+	assert.Equal("when", val.Label.String())
 }
 
 func TestTableHeader(t *testing.T) {
@@ -221,6 +254,57 @@ func TestTableHeader(t *testing.T) {
 	if print {
 		pp.Println(val)
 	}
+
+	val = &TableHeader{}
+	err = parser.ParseString("", "TableDef: swamp\nExtends: base.encounters\nRemove: wolf, \"brown bear\"\nRemove: elk\nReweight: zombie w=5, ghoul w=2\n~ region: swamp", val)
+	assert.NoError(err)
+	assert.Equal("base.encounters", val.Extends.FullName())
+	assert.Len(val.Remove, 3)
+	assert.Equal("brown bear", val.Remove[1].String())
+	assert.Len(val.Reweight, 2)
+	assert.Equal("ghoul", val.Reweight[1].Label.String())
+	assert.Equal(2, val.Reweight[1].Weight)
+	assert.Len(val.Tags, 1)
+	if print {
+		pp.Println(val)
+	}
+
+	// Dice tag values end with the line.
+	val = &TableHeader{}
+	err = parser.ParseString("", "TableDef: weather\n~ dice: 2d6 \n~ best: 4d6h3.sum\n~ avoid-repeat: 3", val)
+	assert.NoError(err)
+	assert.Len(val.Tags, 3)
+	assert.Equal("2d6", val.Tags[0].Value.String())
+	assert.Equal("4d6h3.sum", val.Tags[1].Value.String())
+	assert.Equal("3", val.Tags[2].Value.String())
+
+	// Comments after dice end at the line too.
+	val = &TableHeader{}
+	err = parser.ParseString("", "TableDef: weather\n~ dice: 2d6  # note\n~ best: 4d6h3.sum# best three\n~ avoid-repeat: 3", val)
+	assert.NoError(err)
+	assert.Len(val.Tags, 3)
+	assert.Equal("2d6", val.Tags[0].Value.String())
+	assert.Equal("4d6h3.sum", val.Tags[1].Value.String())
+	assert.Equal("3", val.Tags[2].Value.String())
+}
+
+func TestTagValue(t *testing.T) {
+	assert := assert.New(t)
+	parser, err := parserTypeWithDefaultOptions(&Tag{})
+	assert.NoError(err)
+
+	val := &Tag{}
+	err = parser.ParseString("", "~ author: franz", val)
+	assert.NoError(err)
+	assert.Equal("franz", val.Value.String())
+	assert.Nil(val.Value.Number)
+
+	val = &Tag{}
+	err = parser.ParseString("", "~ avoid-repeat: 5", val)
+	assert.NoError(err)
+	assert.Equal("avoid-repeat", val.Key.String())
+	assert.Equal("5", val.Value.String())
+	assert.NotNil(val.Value.Number)
 }
 
 func TestTable(t *testing.T) {
@@ -247,6 +331,13 @@ func TestTable(t *testing.T) {
 	if print {
 		pp.Println(val)
 	}
+
+	// Extending tables don't need rows.
+	val = &Table{}
+	err = parser.ParseString("", "TableDef: copy\nExtends: footable", val)
+	assert.NoError(err)
+	assert.Len(val.Rows, 0)
+	assert.Nil(val.Generator)
 }
 
 func TestFileHeader(t *testing.T) {
@@ -301,6 +392,22 @@ func TestFileHeader(t *testing.T) {
 	if print {
 		pp.Println(val)
 	}
+
+	val = &FileHeader{}
+	header = `TablePack: data
+	Import: f"./util.tman"
+	TableData: f"./sheets/monsters.csv"
+	TableData: f"./loot.yaml" As: treasure`
+	err = parser.ParseString("", header, val)
+	assert.NoError(err)
+	assert.Len(val.Imports, 1)
+	assert.Len(val.Data, 2)
+	assert.Equal("./sheets/monsters.csv", val.Data[0].File())
+	assert.Equal("monsters", val.Data[0].TableName())
+	assert.Equal("treasure", val.Data[1].TableName())
+	if print {
+		pp.Println(val)
+	}
 }
 
 func TestTableFile(t *testing.T) {
@@ -371,6 +478,38 @@ func TestTableFile(t *testing.T) {
 	}
 }
 
+func TestDocComments(t *testing.T) {
+	assert := assert.New(t)
+	p, err := GetParser()
+	assert.NoError(err)
+
+	file, err := p.Parse(`# Dungeon tables.
+TablePack: dungeon
+
+#----------
+# Monsters by depth.
+#   Roll on deeper levels.
+#----------
+TableDef: monsters
+"orc" {!treasure(deck)} {!common.names()}
+when {!common.check()}: [ "rat" | {upper(!loot())} ]
+
+# Not a doc comment.
+
+TableDef: treasure
+"gold"`)
+	assert.NoError(err)
+	assert.Equal([]string{"Dungeon tables."}, file.Header.Doc)
+	assert.Equal([]string{"Monsters by depth.", "  Roll on deeper levels."}, file.Tables[0].Doc)
+	assert.Nil(file.Tables[1].Doc)
+
+	names := make([]string, 0)
+	for _, c := range TableCalls(file.Tables[0]) {
+		names = append(names, c.Name.FullName())
+	}
+	assert.Equal([]string{"treasure", "common.names", "common.check", "loot"}, names)
+}
+
 func TestRoll(t *testing.T) {
 	print := false
 	// t.Parallel()
@@ -494,6 +633,30 @@ func TestExpr(t *testing.T) {
 	if print {
 		pp.Println(val)
 	}
+
+	val = &Expression{}
+	err = parser.ParseString("", `{ !monsters(roll, tag="undead") }`, val)
+	assert.NoError(err)
+	assert.Len(val.Value.Call.Params, 2)
+	assert.Nil(val.Value.Call.Params[0].Name)
+	assert.Equal("tag", *val.Value.Call.Params[1].Name)
+	assert.Equal(`"undead"`, *val.Value.Call.Params[1].Value.Label.Escaped)
+	if print {
+		pp.Println(val)
+	}
+
+	val = &Expression{}
+	err = parser.ParseString("", `{ [ "a" | w=3 "b" {1d4?} |
+		[ "c" | "d" ] ] }`, val)
+	assert.NoError(err)
+	assert.Equal(InlineExprT, val.Value.GetType())
+	assert.Len(val.Value.Inline.Options, 3)
+	assert.Equal(3, val.Value.Inline.Options[1].Weight)
+	assert.Len(val.Value.Inline.Options[1].Values, 2)
+	assert.NotNil(val.Value.Inline.Options[2].Values[0].Inline)
+	if print {
+		pp.Println(val)
+	}
 }
 
 func TestExprVars(t *testing.T) {
@@ -536,6 +699,7 @@ func TestExprVars(t *testing.T) {
 	if print {
 		pp.Println(val)
 	}
+	assert.Equal(strVal, Source(val.Tokens))
 }
 
 func TestGeneratorRows(t *testing.T) {
@@ -568,7 +732,30 @@ func TestGeneratorRows(t *testing.T) {
 	assert.Len(val.Generator.Steps[0].Values, 13)
 	assert.Len(val.Generator.Steps[1].Values, 1)
 	assert.Len(val.Generator.Steps[2].Values, 4)
-	assert.Equal(`"Diamonds"`, val.Generator.Steps[2].Values[2])
+	assert.Equal(`"Diamonds"`, *val.Generator.Steps[2].Values[2].StringVal)
+	if print {
+		pp.Println(val)
+	}
+
+	val = &Table{}
+	strVal = `TableDef: foo
+	Exclude: AH, "K S"
+	[w=2 c=3 A: "Ace", K: "King", {!bar()}]["": " of "][
+		H: "Hearts", "S"]`
+	err = parser.ParseString("", strVal, val)
+	assert.NoError(err)
+	assert.Nil(val.Rows)
+	assert.Len(val.Header.Exclude, 2)
+	assert.Len(val.Generator.Steps, 3)
+	first := val.Generator.Steps[0].Values
+	assert.Equal(2, first[0].Weight)
+	assert.Equal(3, first[0].Count)
+	assert.Equal("A", first[0].LabelVal())
+	assert.Equal("Ace", first[0].String())
+	assert.NotNil(first[2].Expression)
+	assert.Equal("", first[2].LabelVal())
+	assert.Equal("", val.Generator.Steps[1].Values[0].LabelVal())
+	assert.Equal("S", val.Generator.Steps[2].Values[1].LabelVal())
 	if print {
 		pp.Println(val)
 	}
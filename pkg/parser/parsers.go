@@ -13,6 +13,9 @@ type TableFileParser struct {
 func (t *TableFileParser) Parse(code string) (*TableFile, error) {
 	res := &TableFile{}
 	err := t.p.ParseString("", code, res)
+	if err == nil {
+		addDocs(code, res)
+	}
 	return res, err
 }
 
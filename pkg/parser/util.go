@@ -1,19 +1,25 @@
 package parser
 
 import (
+	"strings"
+	"sync"
+
 	"github.com/alecthomas/participle/v2/lexer"
 	"github.com/k0kubun/pp"
 )
 
-var typeString map[lexer.TokenType]string
+var (
+	typeString     map[lexer.TokenType]string
+	typeStringOnce sync.Once
+)
 
 func setupTypeTable() {
-	if len(typeString) == 0 {
+	typeStringOnce.Do(func() {
 		typeString = make(map[lexer.TokenType]string)
 		for k, v := range fileLexer.Symbols() {
 			typeString[v] = k
 		}
-	}
+	})
 }
 
 // PrintTokens can be used for debug to print out up to
@@ -28,3 +34,17 @@ func PrintTokens(in string, count int) {
 		t, _ = l.Next()
 	}
 }
+
+// Source returns the source text of the tokens of a node, without comments.
+func Source(tokens []lexer.Token) string {
+	setupTypeTable()
+	var b strings.Builder
+	for _, t := range tokens {
+		switch typeString[t.Type] {
+		case "Comment", "CommentLine", "RollComment":
+			continue
+		}
+		b.WriteString(t.Value)
+	}
+	return strings.TrimSpace(b.String())
+}
@@ -0,0 +1,66 @@
+package parser
+
+// TableCalls returns the table calls in a table, from its rows, generator and
+// row weights and conditions, in order.
+func TableCalls(t *Table) []*Call {
+	w := &callWalker{}
+	if t.Generator != nil {
+		for _, step := range t.Generator.Steps {
+			for _, v := range step.Values {
+				w.expression(v.Expression)
+			}
+		}
+	}
+	for _, r := range t.Rows {
+		w.expression(r.WeightExpr)
+		w.expression(r.When)
+		w.rowItems(r.Values)
+	}
+	return w.calls
+}
+
+type callWalker struct {
+	calls []*Call
+}
+
+func (w *callWalker) rowItems(items []*RowItem) {
+	for _, i := range items {
+		w.expression(i.Expression)
+		w.inline(i.Inline)
+	}
+}
+
+func (w *callWalker) inline(t *InlineTable) {
+	if t == nil {
+		return
+	}
+	for _, o := range t.Options {
+		w.rowItems(o.Values)
+	}
+}
+
+func (w *callWalker) expression(e *Expression) {
+	if e == nil {
+		return
+	}
+	for _, v := range e.Vars {
+		w.value(v.AssignedValue)
+	}
+	w.value(e.Value)
+}
+
+func (w *callWalker) value(v *ValueExpr) {
+	if v == nil {
+		return
+	}
+	w.inline(v.Inline)
+	if v.Call == nil {
+		return
+	}
+	if v.Call.IsTable {
+		w.calls = append(w.calls, v.Call)
+	}
+	for _, p := range v.Call.Params {
+		w.value(p.Value)
+	}
+}
@@ -74,22 +74,81 @@ func lteResolve(results []*ExpressionResult) (*ExpressionResult, error) {
 	return NewIntResult(val), nil
 }
 
-func orResolve(results []*ExpressionResult) (*ExpressionResult, error) {
-	for _, v := range results {
-		if v.IntVal() != 0 {
-			return NewIntResult(1), nil
-		}
+// logicFunction is a short-circuiting `and`/`or`. Parameters are evaluated
+// left to right and evaluation stops as soon as the result is known, so any
+// remaining parameters are never evaluated (no rolls, no deck draws).
+type logicFunction struct {
+	name   string
+	params []Evallable
+	// stopOn is the truthiness that decides the result early,
+	// false for `and` and true for `or`.
+	stopOn bool
+}
+
+func newAndFunction(name string, vals []Evallable) (Evallable, error) {
+	return newLogicFunction(name, vals, false)
+}
+
+func newOrFunction(name string, vals []Evallable) (Evallable, error) {
+	return newLogicFunction(name, vals, true)
+}
+
+func newLogicFunction(name string, vals []Evallable, stopOn bool) (Evallable, error) {
+	if len(vals) < 2 {
+		return nil, fmt.Errorf("too few params (%d) for function '%s', expected at least 2", len(vals), name)
+	}
+	return &logicFunction{
+		name:   name,
+		params: vals,
+		stopOn: stopOn,
+	}, nil
+}
+
+func (l *logicFunction) Eval() ExpressionEval {
+	return &logicFunctionEval{
+		config: l,
 	}
-	return NewIntResult(0), nil
 }
 
-func andResolve(results []*ExpressionResult) (*ExpressionResult, error) {
-	for _, v := range results {
-		if v.IntVal() == 0 {
-			return NewIntResult(0), nil
-		}
+type logicFunctionEval struct {
+	ctx    *ExecutionContext
+	config *logicFunction
+	index  int
+	done   bool
+}
+
+func (l *logicFunctionEval) SetContext(ctx *ExecutionContext) ExpressionEval {
+	l.ctx = ctx
+	return l
+}
+
+func (l *logicFunctionEval) HasNext() bool {
+	return !l.done && l.index < len(l.config.params)
+}
+
+func (l *logicFunctionEval) Next() (ExpressionEval, error) {
+	return l.config.params[l.index].Eval().SetContext(l.ctx.Child()), nil
+}
+
+func (l *logicFunctionEval) Provide(res *ExpressionResult) error {
+	if !res.MatchType(IntResult) {
+		return fmt.Errorf("could not execute %s, wrong type for parameter %d in function '%s'",
+			l.config.name,
+			l.index+1,
+			l.config.name,
+		)
+	}
+	l.index++
+	l.done = res.BoolVal() == l.config.stopOn
+	return nil
+}
+
+func (l *logicFunctionEval) Resolve() (*ExpressionResult, error) {
+	// Stopping early gives the stop value, otherwise every value was the opposite.
+	if l.done == l.config.stopOn {
+		return NewIntResult(1), nil
 	}
-	return NewIntResult(1), nil
+	return NewIntResult(0), nil
 }
 
 func notResolve(results []*ExpressionResult) (*ExpressionResult, error) {
@@ -0,0 +1,199 @@
+package program
+
+import (
+	"fmt"
+)
+
+// errorHandler is implemented by ExpressionEvals that can recover from runtime
+// errors raised while evaluating their sub-expressions.
+type errorHandler interface {
+	// HandleError is passed an error from a sub-expression and returns whether
+	// it was handled. Evaluation continues with the handler if it returns true.
+	HandleError(err error) bool
+}
+
+type caseBranch struct {
+	key   Evallable
+	value Evallable
+}
+
+// caseFunction is a lazily evaluated multi-branch function. Keys are evaluated
+// in order until one matches, then only that branch's value is evaluated.
+//
+//  switch(<value>, <key>, <result>, ..., <default>?)
+//  cond(<condition>, <result>, ..., <else>?)
+type caseFunction struct {
+	name     string
+	subject  Evallable
+	branches []*caseBranch
+	fallback Evallable
+}
+
+func newSwitchFunction(name string, vals []Evallable) (Evallable, error) {
+	if len(vals) < 3 {
+		return nil, fmt.Errorf("need at least 3 parameters for '%s', was passed %d", name, len(vals))
+	}
+	result := newCaseFunction(name, vals[1:])
+	result.subject = vals[0]
+	return result, nil
+}
+
+func newCondFunction(name string, vals []Evallable) (Evallable, error) {
+	if len(vals) < 2 {
+		return nil, fmt.Errorf("need at least 2 parameters for '%s', was passed %d", name, len(vals))
+	}
+	return newCaseFunction(name, vals), nil
+}
+
+func newCaseFunction(name string, vals []Evallable) *caseFunction {
+	result := &caseFunction{
+		name:     name,
+		branches: make([]*caseBranch, 0, len(vals)/2),
+	}
+	for i := 0; i+1 < len(vals); i += 2 {
+		result.branches = append(result.branches, &caseBranch{key: vals[i], value: vals[i+1]})
+	}
+	if len(vals)%2 == 1 {
+		result.fallback = vals[len(vals)-1]
+	}
+	return result
+}
+
+func (c *caseFunction) Eval() ExpressionEval {
+	return &caseFunctionEval{
+		config: c,
+	}
+}
+
+type caseFunctionEval struct {
+	ctx     *ExecutionContext
+	config  *caseFunction
+	subject *ExpressionResult
+	index   int
+	chosen  Evallable
+	result  *ExpressionResult
+}
+
+func (c *caseFunctionEval) SetContext(ctx *ExecutionContext) ExpressionEval {
+	c.ctx = ctx
+	return c
+}
+
+func (c *caseFunctionEval) HasNext() bool {
+	return c.result == nil
+}
+
+func (c *caseFunctionEval) Next() (ExpressionEval, error) {
+	switch {
+	case c.config.subject != nil && c.subject == nil:
+		return c.config.subject.Eval().SetContext(c.ctx.Child()), nil
+	case c.chosen == nil:
+		return c.config.branches[c.index].key.Eval().SetContext(c.ctx.Child()), nil
+	}
+	return c.chosen.Eval().SetContext(c.ctx.Child()), nil
+}
+
+func (c *caseFunctionEval) Provide(res *ExpressionResult) error {
+	switch {
+	case c.config.subject != nil && c.subject == nil:
+		c.subject = res
+		return nil
+	case c.chosen != nil:
+		c.result = res
+		return nil
+	}
+	if c.matches(res) {
+		c.chosen = c.config.branches[c.index].value
+		return nil
+	}
+	if c.config.subject == nil && !res.MatchType(IntResult) {
+		return fmt.Errorf("'%s' condition %d must be an integer expression", c.config.name, c.index+1)
+	}
+	c.index++
+	if c.index < len(c.config.branches) {
+		return nil
+	}
+	if c.config.fallback == nil {
+		return fmt.Errorf("no branch matched in '%s' and no default was given", c.config.name)
+	}
+	c.chosen = c.config.fallback
+	return nil
+}
+
+func (c *caseFunctionEval) matches(key *ExpressionResult) bool {
+	if c.config.subject == nil {
+		return key.BoolVal()
+	}
+	return c.subject.Equal(key)
+}
+
+func (c *caseFunctionEval) Resolve() (*ExpressionResult, error) {
+	return c.result, nil
+}
+
+// tryFunction evaluates an expression and, if it fails with a runtime error,
+// evaluates the fallback instead.
+//
+//  try(<expression>, <fallback>)
+type tryFunction struct {
+	expr     Evallable
+	fallback Evallable
+}
+
+func newTryFunction(name string, vals []Evallable) (Evallable, error) {
+	if len(vals) != 2 {
+		return nil, fmt.Errorf("need 2 parameters for '%s', was passed %d", name, len(vals))
+	}
+	return &tryFunction{
+		expr:     vals[0],
+		fallback: vals[1],
+	}, nil
+}
+
+func (t *tryFunction) Eval() ExpressionEval {
+	return &tryFunctionEval{
+		config: t,
+	}
+}
+
+type tryFunctionEval struct {
+	ctx    *ExecutionContext
+	config *tryFunction
+	failed bool
+	result *ExpressionResult
+}
+
+func (t *tryFunctionEval) SetContext(ctx *ExecutionContext) ExpressionEval {
+	t.ctx = ctx
+	return t
+}
+
+func (t *tryFunctionEval) HasNext() bool {
+	return t.result == nil
+}
+
+func (t *tryFunctionEval) Next() (ExpressionEval, error) {
+	if t.failed {
+		return t.config.fallback.Eval().SetContext(t.ctx.Child()), nil
+	}
+	return t.config.expr.Eval().SetContext(t.ctx.Child()), nil
+}
+
+func (t *tryFunctionEval) Provide(res *ExpressionResult) error {
+	t.result = res
+	return nil
+}
+
+func (t *tryFunctionEval) Resolve() (*ExpressionResult, error) {
+	return t.result, nil
+}
+
+// HandleError implementation for errorHandler, only errors from the first
+// expression are handled so errors in the fallback are still reported.
+func (t *tryFunctionEval) HandleError(err error) bool {
+	if t.failed || t.result != nil {
+		return false
+	}
+	t.failed = true
+	return true
+}
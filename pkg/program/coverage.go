@@ -0,0 +1,164 @@
+package program
+
+import "sync/atomic"
+
+// CallMode is the way a table call picked a row, coverage counts picks by mode.
+type CallMode int
+
+// Table call modes.
+const (
+	RollMode CallMode = iota
+	WeightedMode
+	IndexMode
+	LabelMode
+	DiceMode
+	UniqueMode
+	DeckMode
+	callModeCount
+)
+
+var callModeNames = [callModeCount]string{"roll", "weighted", "index", "label", "dice", "unique", "deck"}
+
+// String returns the name of the mode as used in table calls.
+func (m CallMode) String() string {
+	return callModeNames[m]
+}
+
+// rowHits counts the picks of a row by call mode.
+type rowHits [callModeCount]uint64
+
+// TableCoverage is how often evaluation picked each row of a table.
+type TableCoverage struct {
+	Pack  string         `json:"pack"`
+	Table string         `json:"table"`
+	Lang  string         `json:"lang,omitempty"`
+	Rows  []*RowCoverage `json:"rows"`
+}
+
+// Covered returns the number of rows picked at least once.
+func (t *TableCoverage) Covered() int {
+	result := 0
+	for _, r := range t.Rows {
+		if r.Total > 0 {
+			result++
+		}
+	}
+	return result
+}
+
+// RowCoverage is how often evaluation picked a row.
+type RowCoverage struct {
+	// Row is the row number, starting from 1.
+	Row   int    `json:"row"`
+	Label string `json:"label,omitempty"`
+	// Hits are the picks by call mode, modes without picks are left out.
+	Hits  map[string]int `json:"hits"`
+	Total int            `json:"total"`
+}
+
+// SetCoverage starts counting the rows evaluation picks from zero, or stops
+// counting. Copies of the program made after this share the counts, so
+// programs copied for separate sessions add up to one report. Not safe to call
+// during evaluation.
+func (p *Program) SetCoverage(enabled bool) {
+	for _, pack := range p.Packs() {
+		for _, t := range pack.Tables() {
+			t.SetCoverage(enabled)
+		}
+	}
+}
+
+// Coverage returns the row picks counted for every table with coverage on.
+func (p *Program) Coverage() []*TableCoverage {
+	result := make([]*TableCoverage, 0)
+	for _, pack := range p.Packs() {
+		for _, t := range pack.Tables() {
+			if c := t.Coverage(); c != nil {
+				c.Pack = pack.Name()
+				result = append(result, c)
+			}
+		}
+	}
+	return result
+}
+
+// SetCoverage starts counting the rows evaluation picks from zero, or stops
+// counting. Copies of the table share the counts.
+func (t *Table) SetCoverage(enabled bool) {
+	for _, r := range t.rows {
+		if enabled {
+			r.hits = &rowHits{}
+		} else {
+			r.hits = nil
+		}
+	}
+}
+
+// Coverage returns the row picks counted for the table, nil if coverage is off.
+// The pack name is left empty.
+func (t *Table) Coverage() *TableCoverage {
+	if len(t.rows) == 0 || t.rows[0].hits == nil {
+		return nil
+	}
+	result := &TableCoverage{
+		Table: t.name,
+		Lang:  t.tags[LangTag],
+		Rows:  make([]*RowCoverage, 0, len(t.rows)),
+	}
+	for i, r := range t.rows {
+		row := &RowCoverage{
+			Row:   i + 1,
+			Label: r.label,
+			Hits:  make(map[string]int),
+		}
+		for mode := CallMode(0); mode < callModeCount; mode++ {
+			if n := r.HitsBy(mode); n > 0 {
+				row.Hits[mode.String()] = n
+				row.Total += n
+			}
+		}
+		result.Rows = append(result.Rows, row)
+	}
+	return result
+}
+
+// Hits returns how many times evaluation picked each row of the table in any
+// mode, in row order. All zero if coverage is off.
+func (t *Table) Hits() []int {
+	result := make([]int, 0, len(t.rows))
+	for _, r := range t.rows {
+		result = append(result, r.Hits())
+	}
+	return result
+}
+
+// shareHits makes the row count picks with the counters of another row.
+func (r *TableRow) shareHits(other *TableRow) *TableRow {
+	r.hits = other.hits
+	return r
+}
+
+// pick counts a pick of the row if coverage is on and returns its value.
+func (r *TableRow) pick(mode CallMode) Evallable {
+	if r.hits != nil {
+		atomic.AddUint64(&r.hits[mode], 1)
+	}
+	return r.value
+}
+
+// Hits returns how many times evaluation picked the row in any mode.
+func (r *TableRow) Hits() int {
+	result := 0
+	for mode := CallMode(0); mode < callModeCount; mode++ {
+		result += r.HitsBy(mode)
+	}
+	return result
+}
+
+// HitsBy returns how many times evaluation picked the row with the given mode.
+func (r *TableRow) HitsBy(mode CallMode) int {
+	if r.hits == nil {
+		return 0
+	}
+	return int(atomic.LoadUint64(&r.hits[mode]))
+}
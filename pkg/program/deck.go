@@ -0,0 +1,254 @@
+package program
+
+import (
+	"fmt"
+)
+
+const (
+	// DefaultDeck is the name of the deck used by `!t(deck)` calls.
+	DefaultDeck = ""
+
+	// AutoReshuffleTag is the table tag that reshuffles a deck when a draw finds
+	// it empty. The value is `discard` to shuffle the discard pile back in or
+	// `all` to shuffle every card back in.
+	AutoReshuffleTag = "auto-reshuffle"
+)
+
+// deck is the draw state of one named deck over a table's rows.
+//
+// Every copy of a row card is in the deck, in the discard pile or drawn.
+// Drawn cards stay out of the deck until discarded, returned or shuffled back.
+type deck struct {
+	counts    []int
+	discards  []int
+	remaining int
+	discarded int
+	// top is the row index picked by a peek, the next draw takes it.
+	top int
+}
+
+func newDeck(t *Table) *deck {
+	d := &deck{
+		counts:   make([]int, len(t.rows)),
+		discards: make([]int, len(t.rows)),
+	}
+	d.shuffle(t)
+	return d
+}
+
+// shuffle puts every card back into the deck.
+func (d *deck) shuffle(t *Table) {
+	for i, r := range t.rows {
+		d.counts[i] = r.count
+		d.discards[i] = 0
+	}
+	d.remaining = t.totalCount
+	d.discarded = 0
+	d.top = -1
+}
+
+// copy returns a copy of the deck state.
+func (d *deck) copy() *deck {
+	result := *d
+	result.counts = append([]int(nil), d.counts...)
+	result.discards = append([]int(nil), d.discards...)
+	return &result
+}
+
+// reshuffle puts the discard pile back into the deck.
+func (d *deck) reshuffle() {
+	for i, c := range d.discards {
+		d.counts[i] += c
+		d.discards[i] = 0
+	}
+	d.remaining += d.discarded
+	d.discarded = 0
+	d.top = -1
+}
+
+// deck returns the named deck, creating it if needed. deckMu must be held.
+func (t *Table) deck(name string) *deck {
+	if t.decks == nil {
+		t.decks = make(map[string]*deck)
+	}
+	d, ok := t.decks[name]
+	if !ok {
+		d = newDeck(t)
+		t.decks[name] = d
+	}
+	return d
+}
+
+// deckName formats a deck name for error messages.
+func (t *Table) deckName(name string) string {
+	if name == DefaultDeck {
+		return fmt.Sprintf("table '%s'", t.name)
+	}
+	return fmt.Sprintf("deck '%s' of table '%s'", name, t.name)
+}
+
+// fill refills an empty deck if the table has the auto-reshuffle tag.
+// deckMu must be held.
+func (t *Table) fill(d *deck, name string) error {
+	if d.remaining > 0 {
+		return nil
+	}
+	switch t.tags[AutoReshuffleTag] {
+	case "discard":
+		d.reshuffle()
+	case "all":
+		d.shuffle(t)
+	}
+	if d.remaining == 0 {
+		return fmt.Errorf("deck draw called too many times without shuffle on %s", t.deckName(name))
+	}
+	return nil
+}
+
+// pickTop chooses the top card of the deck if a peek hasn't already.
+// deckMu must be held.
+func (t *Table) pickTop(ctx *ExecutionContext, d *deck, name string) (int, error) {
+	if err := t.fill(d, name); err != nil {
+		return -1, err
+	}
+	if d.top >= 0 {
+		return d.top, nil
+	}
+	roll := ctx.Rand(0, d.remaining)
+	for i, c := range d.counts {
+		if roll < c {
+			d.top = i
+			return i, nil
+		}
+		roll -= c
+	}
+	return -1, fmt.Errorf("deck count out of sync on %s", t.deckName(name))
+}
+
+// drawCard takes the top card out of the deck and returns its row index.
+// deckMu must be held.
+func (t *Table) drawCard(ctx *ExecutionContext, d *deck, name string) (int, error) {
+	i, err := t.pickTop(ctx, d, name)
+	if err != nil {
+		return -1, err
+	}
+	d.counts[i]--
+	d.remaining--
+	d.top = -1
+	return i, nil
+}
+
+// DeckDrawN draws count cards from the named deck. Drawn cards stay out of the
+// deck until they are discarded, returned or the deck is shuffled. If the deck
+// runs out before count cards are drawn it is left as it was.
+func (t *Table) DeckDrawN(ctx *ExecutionContext, name string, count int) ([]Evallable, error) {
+	t.deckMu.Lock()
+	defer t.deckMu.Unlock()
+	if count < 1 {
+		return nil, fmt.Errorf("must draw at least 1 card from %s, asked for %d", t.deckName(name), count)
+	}
+	d := t.deck(name)
+	saved := d.copy()
+	drawn := make([]int, 0, count)
+	for len(drawn) < count {
+		i, err := t.drawCard(ctx, d, name)
+		if err != nil {
+			t.decks[name] = saved
+			return nil, err
+		}
+		drawn = append(drawn, i)
+	}
+	result := make([]Evallable, 0, count)
+	for _, i := range drawn {
+		result = append(result, t.rows[i].pick(DeckMode))
+	}
+	return result, nil
+}
+
+// DeckPeek returns the top card of the named deck without drawing it, the next
+// draw from the deck will return the same card.
+func (t *Table) DeckPeek(ctx *ExecutionContext, name string) (Evallable, error) {
+	t.deckMu.Lock()
+	defer t.deckMu.Unlock()
+	i, err := t.pickTop(ctx, t.deck(name), name)
+	if err != nil {
+		return nil, err
+	}
+	return t.rows[i].Value(), nil
+}
+
+// DeckRemaining returns the number of cards left in the named deck.
+func (t *Table) DeckRemaining(name string) int {
+	t.deckMu.Lock()
+	defer t.deckMu.Unlock()
+	return t.deck(name).remaining
+}
+
+// DeckDiscards returns the number of cards in the named deck's discard pile.
+func (t *Table) DeckDiscards(name string) int {
+	t.deckMu.Lock()
+	defer t.deckMu.Unlock()
+	return t.deck(name).discarded
+}
+
+// DeckShuffle puts every card of the named deck back into it.
+func (t *Table) DeckShuffle(name string) {
+	t.deckMu.Lock()
+	defer t.deckMu.Unlock()
+	t.deck(name).shuffle(t)
+}
+
+// DeckReshuffle puts the discard pile of the named deck back into it.
+func (t *Table) DeckReshuffle(name string) {
+	t.deckMu.Lock()
+	defer t.deckMu.Unlock()
+	t.deck(name).reshuffle()
+}
+
+// DeckDiscard moves a drawn card with the given label to the discard pile.
+func (t *Table) DeckDiscard(name string, label string) error {
+	t.deckMu.Lock()
+	defer t.deckMu.Unlock()
+	i, err := t.labelIndex(label)
+	if err != nil {
+		return err
+	}
+	d := t.deck(name)
+	if t.rows[i].count-d.counts[i]-d.discards[i] == 0 {
+		return fmt.Errorf("no drawn '%s' card to discard from %s", label, t.deckName(name))
+	}
+	d.discards[i]++
+	d.discarded++
+	return nil
+}
+
+// DeckReturn puts a card with the given label back into the named deck, taking
+// it from the discard pile first and otherwise from the drawn cards.
+func (t *Table) DeckReturn(name string, label string) error {
+	t.deckMu.Lock()
+	defer t.deckMu.Unlock()
+	i, err := t.labelIndex(label)
+	if err != nil {
+		return err
+	}
+	d := t.deck(name)
+	switch {
+	case d.discards[i] > 0:
+		d.discards[i]--
+		d.discarded--
+	case d.counts[i] == t.rows[i].count:
+		return fmt.Errorf("every '%s' card is already in %s", label, t.deckName(name))
+	}
+	d.counts[i]++
+	d.remaining++
+	return nil
+}
+
+func (t *Table) labelIndex(label string) (int, error) {
+	for i, r := range t.rows {
+		if r.label == label {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("in table '%s' no row labelled '%s'", t.name, label)
+}
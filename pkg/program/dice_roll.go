@@ -0,0 +1,182 @@
+package program
+
+import (
+	"fmt"
+)
+
+const (
+	// DiceTag is the table tag with the roll used by `!t(dice)`, e.g. `~ dice: 2d6`.
+	DiceTag = "dice"
+)
+
+// SetDice sets the roll used to pick an index for dice rolls on the table.
+func (t *Table) SetDice(r *Roll) *Table {
+	t.dice = r
+	return t
+}
+
+// DiceBounds returns the lowest and highest index a dice roll on the table can
+// give, from the dice set with SetDice or the span of the row ranges otherwise.
+// ok is false if the table has no ranges or the dice bounds aren't known.
+func (t *Table) DiceBounds() (low int, high int, ok bool) {
+	if t.dice != nil {
+		return t.dice.Bounds()
+	}
+	if len(t.rowsByRange) == 0 {
+		return 0, 0, false
+	}
+	low, high = t.rowsByRange[0].low, t.rowsByRange[0].high
+	for _, rng := range t.rowsByRange[1:] {
+		if rng.low < low {
+			low = rng.low
+		}
+		if rng.high > high {
+			high = rng.high
+		}
+	}
+	return low, high, true
+}
+
+// DiceRoll rolls an index and returns the row for it like IndexRoll. The index
+// is rolled with the table dice if set, otherwise uniformly over the span of the
+// row ranges. The roll is recorded in the roll history.
+func (t *Table) DiceRoll() (Evallable, error) {
+	if t.dice != nil {
+		return &diceRoll{table: t, roll: t.dice}, nil
+	}
+	low, high, ok := t.DiceBounds()
+	if !ok {
+		return nil, fmt.Errorf("table '%s' has no index ranges to roll dice for", t.name)
+	}
+	return &diceRoll{table: t, roll: &spanRoll{low: low, high: high}}, nil
+}
+
+// Bounds returns the lowest and highest value the roll can give. ok is false
+// for rolls with count aggregations.
+func (r *Roll) Bounds() (low int, high int, ok bool) {
+	if len(r.countAggrs) > 0 {
+		return 0, 0, false
+	}
+	count := r.diceCount
+	if r.selector != nil && r.selector.count < count {
+		count = r.selector.count
+	}
+	switch r.aggrFn {
+	case "min", "max", "mode", "median":
+		return 1, r.diceSides, true
+	case "avg":
+		// avg always divides by the total dice count, even with a subset.
+		return count / r.diceCount, count * r.diceSides / r.diceCount, true
+	}
+	return count, count * r.diceSides, true
+}
+
+// spanRoll rolls uniformly between low and high inclusive.
+type spanRoll struct {
+	low  int
+	high int
+}
+
+func (s *spanRoll) Eval() ExpressionEval {
+	return &spanRollEval{config: s}
+}
+
+type spanRollEval struct {
+	ctx    *ExecutionContext
+	config *spanRoll
+}
+
+func (s *spanRollEval) SetContext(ctx *ExecutionContext) ExpressionEval {
+	s.ctx = ctx
+	return s
+}
+
+func (s *spanRollEval) HasNext() bool {
+	return false
+}
+
+func (s *spanRollEval) Next() (ExpressionEval, error) {
+	return nil, fmt.Errorf("roll has no sub-expressions")
+}
+
+func (s *spanRollEval) Provide(res *ExpressionResult) error {
+	return fmt.Errorf("no values should be provided to roll expressions")
+}
+
+func (s *spanRollEval) Resolve() (*ExpressionResult, error) {
+	v := s.ctx.Rand(s.config.low, s.config.high+1)
+	s.ctx.AddRollToHistory(fmt.Sprintf("%d: %d-%d", v, s.config.low, s.config.high))
+	return NewIntResult(v), nil
+}
+
+// diceRoll evaluates a roll and then the table row for the rolled index.
+type diceRoll struct {
+	table *Table
+	roll  Evallable
+}
+
+func (d *diceRoll) Eval() ExpressionEval {
+	return &diceRollEval{config: d}
+}
+
+type diceRollEval struct {
+	ctx    *ExecutionContext
+	config *diceRoll
+	row    Evallable
+	result *ExpressionResult
+}
+
+func (d *diceRollEval) SetContext(ctx *ExecutionContext) ExpressionEval {
+	d.ctx = ctx
+	return d
+}
+
+func (d *diceRollEval) HasNext() bool {
+	return d.result == nil
+}
+
+func (d *diceRollEval) Next() (ExpressionEval, error) {
+	if d.row == nil {
+		return d.config.roll.Eval().SetContext(d.ctx.Child()), nil
+	}
+	return d.row.Eval().SetContext(d.ctx.Child()), nil
+}
+
+func (d *diceRollEval) Provide(res *ExpressionResult) error {
+	if d.row != nil {
+		d.result = res
+		return nil
+	}
+	if !res.MatchType(IntResult) {
+		return fmt.Errorf("dice for table '%s' must roll a number", d.config.table.name)
+	}
+	row, err := d.config.table.indexPick(res.IntVal(), DiceMode)
+	if err != nil {
+		return err
+	}
+	d.row = row
+	return nil
+}
+
+func (d *diceRollEval) Resolve() (*ExpressionResult, error) {
+	return d.result, nil
+}
+
+// UncoveredDice returns the values the table dice can roll that have no row
+// and would fail without a default row. Empty if the table has no dice tag.
+func (t *Table) UncoveredDice() []int {
+	result := make([]int, 0)
+	if t.dice == nil || t.defaultRow >= 0 {
+		return result
+	}
+	low, high, ok := t.dice.Bounds()
+	if !ok {
+		return result
+	}
+	for v := low; v <= high; v++ {
+		if _, err := t.indexRow(v); err != nil {
+			result = append(result, v)
+		}
+	}
+	return result
+}
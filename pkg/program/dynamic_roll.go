@@ -0,0 +1,148 @@
+package program
+
+import (
+	"fmt"
+)
+
+// dynamicRoll is a Roll or WeightedRoll on a table with row conditions or
+// weight expressions. Conditions and weights are evaluated in row order before
+// the row is chosen, weights are only evaluated for rows that pass their condition.
+type dynamicRoll struct {
+	table    *Table
+	weighted bool
+}
+
+// mode returns the call mode the roll counts picks under.
+func (d *dynamicRoll) mode() CallMode {
+	if d.weighted {
+		return WeightedMode
+	}
+	return RollMode
+}
+
+func (d *dynamicRoll) Eval() ExpressionEval {
+	return &dynamicRollEval{
+		config:  d,
+		weights: make([]int, 0, len(d.table.rows)),
+	}
+}
+
+type dynamicRollEval struct {
+	ctx    *ExecutionContext
+	config *dynamicRoll
+	// weights has the weight of each row checked so far, 0 for excluded rows.
+	weights []int
+	// checked is whether the condition of the current row passed.
+	checked bool
+	chosen  Evallable
+	result  *ExpressionResult
+}
+
+func (d *dynamicRollEval) SetContext(ctx *ExecutionContext) ExpressionEval {
+	d.ctx = ctx
+	return d
+}
+
+func (d *dynamicRollEval) HasNext() bool {
+	return d.result == nil
+}
+
+// pending returns the next condition or weight to evaluate, or nil once every
+// row has a weight.
+func (d *dynamicRollEval) pending() Evallable {
+	rows := d.config.table.rows
+	for len(d.weights) < len(rows) {
+		r := rows[len(d.weights)]
+		if r.when != nil && !d.checked {
+			return r.when
+		}
+		if d.config.weighted && r.weightExpr != nil {
+			return r.weightExpr
+		}
+		d.addWeight(r.weight)
+	}
+	return nil
+}
+
+func (d *dynamicRollEval) addWeight(w int) {
+	if !d.config.weighted && w > 0 {
+		w = 1
+	}
+	d.weights = append(d.weights, w)
+	d.checked = false
+}
+
+func (d *dynamicRollEval) Next() (ExpressionEval, error) {
+	if e := d.pending(); e != nil {
+		return e.Eval().SetContext(d.ctx.Child()), nil
+	}
+	if d.chosen == nil {
+		chosen, err := d.choose()
+		if err != nil {
+			return nil, err
+		}
+		d.chosen = chosen
+	}
+	return d.chosen.Eval().SetContext(d.ctx.Child()), nil
+}
+
+func (d *dynamicRollEval) choose() (Evallable, error) {
+	t := d.config.table
+	rows := make([]int, 0, len(t.rows))
+	total := 0
+	for _, i := range t.candidates(d.ctx) {
+		if d.weights[i] > 0 {
+			rows = append(rows, i)
+			total += d.weights[i]
+		}
+	}
+	// Recent rows are only avoided if there's another row to pick.
+	if total == 0 {
+		for _, i := range t.tagged(d.ctx) {
+			if w := d.weights[i]; w > 0 {
+				rows = append(rows, i)
+				total += w
+			}
+		}
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("no rows of table '%s' can be rolled, all conditions failed or weights are 0", t.name)
+	}
+	roll := d.ctx.Rand(0, total)
+	for _, i := range rows {
+		if d.weights[i] > roll {
+			return t.rolled(d.ctx, i, d.config.mode()), nil
+		}
+		roll -= d.weights[i]
+	}
+	return t.rolled(d.ctx, rows[len(rows)-1], d.config.mode()), nil
+}
+
+func (d *dynamicRollEval) Provide(res *ExpressionResult) error {
+	if d.chosen != nil {
+		d.result = res
+		return nil
+	}
+	t := d.config.table
+	r := t.rows[len(d.weights)]
+	if !res.MatchType(IntResult) {
+		return fmt.Errorf("row %d of table '%s' needs an integer for its condition and weight", len(d.weights)+1, t.name)
+	}
+	if r.when != nil && !d.checked {
+		if !res.BoolVal() {
+			d.addWeight(0)
+			return nil
+		}
+		d.checked = true
+		return nil
+	}
+	if res.IntVal() < 0 {
+		return fmt.Errorf("row %d of table '%s' has a negative weight %d", len(d.weights)+1, t.name, res.IntVal())
+	}
+	d.addWeight(res.IntVal())
+	return nil
+}
+
+func (d *dynamicRollEval) Resolve() (*ExpressionResult, error) {
+	return d.result, nil
+}
@@ -0,0 +1,518 @@
+package program
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+const (
+	// EncodingVersion is the version of the compiled program format written by
+	// Encode. It changes whenever the encoded tables, rows or nodes do.
+	EncodingVersion = 1
+)
+
+// Kinds of encoded expression nodes.
+const (
+	numberNode   = "number"
+	stringNode   = "string"
+	variableNode = "variable"
+	exprNode     = "expression"
+	listNode     = "list"
+	functionNode = "function"
+	callNode     = "call"
+	rollNode     = "roll"
+	inlineNode   = "inline"
+)
+
+// encodedProgram is a compiled Program, packs are in the order of Packs with
+// the root pack first.
+type encodedProgram struct {
+	Version int            `json:"version"`
+	Packs   []*encodedPack `json:"packs"`
+}
+
+// encodedPack is a TablePack. A table can be both the default table for a name
+// and a language variant, so tables are listed once and named by index.
+type encodedPack struct {
+	Key      string                    `json:"key"`
+	Name     string                    `json:"name"`
+	Aliases  map[string]string         `json:"aliases,omitempty"`
+	Tables   []*encodedTable           `json:"tables"`
+	Names    map[string]int            `json:"names"`
+	Variants map[string]map[string]int `json:"variants,omitempty"`
+}
+
+type encodedTable struct {
+	Name   string            `json:"name"`
+	Tags   map[string]string `json:"tags,omitempty"`
+	Rows   []*encodedRow     `json:"rows"`
+	Dice   *encodedRoll      `json:"dice,omitempty"`
+	Markov *MarkovOptions    `json:"markov,omitempty"`
+}
+
+type encodedRow struct {
+	Label      string       `json:"label,omitempty"`
+	Ranges     [][2]int     `json:"ranges,omitempty"`
+	Weight     int          `json:"weight"`
+	Count      int          `json:"count"`
+	Default    bool         `json:"default,omitempty"`
+	Value      *encodedNode `json:"value"`
+	WeightExpr *encodedNode `json:"weightExpr,omitempty"`
+	When       *encodedNode `json:"when,omitempty"`
+	Tags       []string     `json:"tags,omitempty"`
+	Source     *RowSource   `json:"source,omitempty"`
+}
+
+// encodedNode is an Evallable, the fields used depend on the kind.
+type encodedNode struct {
+	Kind     string         `json:"kind"`
+	Int      int            `json:"int,omitempty"`
+	Text     string         `json:"text,omitempty"`
+	Label    bool           `json:"label,omitempty"`
+	Names    []string       `json:"names,omitempty"`
+	Nodes    []*encodedNode `json:"nodes,omitempty"`
+	Pack     string         `json:"pack,omitempty"`
+	PackName string         `json:"packName,omitempty"`
+	Tag      *encodedNode   `json:"tag,omitempty"`
+	Roll     *encodedRoll   `json:"roll,omitempty"`
+	Rows     []*encodedRow  `json:"rows,omitempty"`
+}
+
+type encodedRoll struct {
+	Count      int      `json:"count"`
+	Sides      int      `json:"sides"`
+	Print      bool     `json:"print,omitempty"`
+	Aggr       string   `json:"aggr,omitempty"`
+	High       *bool    `json:"high,omitempty"`
+	Keep       int      `json:"keep,omitempty"`
+	CountAggrs [][2]int `json:"countAggrs,omitempty"`
+}
+
+// Encode serializes the compiled tables, rows and expressions of the program
+// to JSON, DecodeProgram reads them back without compiling. State like decks,
+// history and coverage isn't kept, see Snapshot for that.
+func (p *Program) Encode() ([]byte, error) {
+	if _, ok := p.packs[RootPack]; !ok {
+		return nil, fmt.Errorf("program has no root pack")
+	}
+	result := &encodedProgram{Version: EncodingVersion}
+	for _, pack := range p.Packs() {
+		encoded, err := pack.encode()
+		if err != nil {
+			return nil, err
+		}
+		result.Packs = append(result.Packs, encoded)
+	}
+	return json.Marshal(result)
+}
+
+// DecodeProgram reads a program written by Encode.
+func DecodeProgram(data []byte) (*Program, error) {
+	encoded := &encodedProgram{}
+	if err := json.Unmarshal(data, encoded); err != nil {
+		return nil, fmt.Errorf("could not read program: %w", err)
+	}
+	if encoded.Version != EncodingVersion {
+		return nil, fmt.Errorf("unsupported program version %d, expected %d", encoded.Version, EncodingVersion)
+	}
+	if len(encoded.Packs) == 0 {
+		return nil, fmt.Errorf("program has no packs")
+	}
+	packs := make(TableMap)
+	for i, e := range encoded.Packs {
+		pack, err := decodePack(e)
+		if err != nil {
+			return nil, err
+		}
+		packs[pack.key] = pack
+		if i == 0 {
+			packs[RootPack] = pack
+		}
+	}
+	return NewProgram(packs), nil
+}
+
+func (t *TablePack) encode() (*encodedPack, error) {
+	result := &encodedPack{
+		Key:     t.key,
+		Name:    t.name,
+		Aliases: t.aliases,
+		Names:   make(map[string]int),
+	}
+	ids := make(map[*Table]int)
+	add := func(table *Table) (int, error) {
+		if id, ok := ids[table]; ok {
+			return id, nil
+		}
+		encoded, err := table.encode()
+		if err != nil {
+			return 0, fmt.Errorf("could not encode table '%s' in pack '%s': %w", table.name, t.name, err)
+		}
+		ids[table] = len(result.Tables)
+		result.Tables = append(result.Tables, encoded)
+		return ids[table], nil
+	}
+	for _, table := range t.Tables() {
+		if _, err := add(table); err != nil {
+			return nil, err
+		}
+	}
+	for name, table := range t.tables {
+		result.Names[name] = ids[table]
+	}
+	if len(t.variants) > 0 {
+		result.Variants = make(map[string]map[string]int)
+	}
+	for name, langs := range t.variants {
+		result.Variants[name] = make(map[string]int)
+		for lang, table := range langs {
+			id, err := add(table)
+			if err != nil {
+				return nil, err
+			}
+			result.Variants[name][lang] = id
+		}
+	}
+	return result, nil
+}
+
+func decodePack(e *encodedPack) (*TablePack, error) {
+	decoded := make([]*Table, 0, len(e.Tables))
+	for _, t := range e.Tables {
+		table, err := decodeTable(t)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode table '%s' in pack '%s': %w", t.Name, e.Name, err)
+		}
+		decoded = append(decoded, table)
+	}
+	lookup := func(id int) (*Table, error) {
+		if id < 0 || id >= len(decoded) {
+			return nil, fmt.Errorf("pack '%s' has no table %d", e.Name, id)
+		}
+		return decoded[id], nil
+	}
+	tables := make(map[string]*Table)
+	for name, id := range e.Names {
+		table, err := lookup(id)
+		if err != nil {
+			return nil, err
+		}
+		tables[name] = table
+	}
+	result := NewTablePack(e.Key, e.Name, tables)
+	result.SetAliases(e.Aliases)
+	names := make([]string, 0, len(e.Variants))
+	for name := range e.Variants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for lang, id := range e.Variants[name] {
+			table, err := lookup(id)
+			if err != nil {
+				return nil, err
+			}
+			result.AddVariant(name, lang, table)
+		}
+	}
+	return result, nil
+}
+
+func (t *Table) encode() (*encodedTable, error) {
+	rows, err := encodeRows(t.rows)
+	if err != nil {
+		return nil, err
+	}
+	result := &encodedTable{
+		Name: t.name,
+		Tags: t.tags,
+		Rows: rows,
+	}
+	if t.dice != nil {
+		result.Dice = t.dice.encode()
+	}
+	if t.markov != nil {
+		opts := t.markov.opts
+		result.Markov = &opts
+	}
+	return result, nil
+}
+
+func decodeTable(e *encodedTable) (*Table, error) {
+	rows, err := decodeRows(e.Rows)
+	if err != nil {
+		return nil, err
+	}
+	tags := e.Tags
+	if tags == nil {
+		tags = make(map[string]string)
+	}
+	result := NewTable(e.Name, tags, rows)
+	if e.Dice != nil {
+		result.SetDice(e.Dice.decode())
+	}
+	if e.Markov != nil {
+		if err := result.SetMarkov(*e.Markov); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func encodeRows(rows []*TableRow) ([]*encodedRow, error) {
+	result := make([]*encodedRow, 0, len(rows))
+	for _, r := range rows {
+		value, err := encodeNode(r.value)
+		if err != nil {
+			return nil, err
+		}
+		weight, err := encodeNode(r.weightExpr)
+		if err != nil {
+			return nil, err
+		}
+		when, err := encodeNode(r.when)
+		if err != nil {
+			return nil, err
+		}
+		encoded := &encodedRow{
+			Label:      r.label,
+			Weight:     r.weight,
+			Count:      r.count,
+			Default:    r.isDefault,
+			Value:      value,
+			WeightExpr: weight,
+			When:       when,
+			Tags:       r.tags,
+			Source:     r.source,
+		}
+		for _, rng := range r.rangeVal {
+			encoded.Ranges = append(encoded.Ranges, [2]int{rng.low, rng.high})
+		}
+		result = append(result, encoded)
+	}
+	return result, nil
+}
+
+func decodeRows(rows []*encodedRow) ([]*TableRow, error) {
+	result := make([]*TableRow, 0, len(rows))
+	for _, r := range rows {
+		value, err := decodeNode(r.Value)
+		if err != nil {
+			return nil, err
+		}
+		if value == nil {
+			return nil, fmt.Errorf("row has no value")
+		}
+		weight, err := decodeNode(r.WeightExpr)
+		if err != nil {
+			return nil, err
+		}
+		when, err := decodeNode(r.When)
+		if err != nil {
+			return nil, err
+		}
+		ranges := make([]*Range, 0, len(r.Ranges))
+		for _, rng := range r.Ranges {
+			ranges = append(ranges, NewRange(rng[0], rng[1]))
+		}
+		row := NewTableRow(r.Label, ranges, r.Weight, r.Count, r.Default, value).
+			SetWeightExpr(weight).
+			SetCondition(when).
+			SetTags(r.Tags).
+			SetSource(r.Source)
+		result = append(result, row)
+	}
+	return result, nil
+}
+
+// encodeNode encodes a compiled expression, nil for a nil Evallable.
+func encodeNode(e Evallable) (*encodedNode, error) {
+	if e == nil {
+		return nil, nil
+	}
+	switch v := e.(type) {
+	case *Number:
+		return &encodedNode{Kind: numberNode, Int: v.value}, nil
+	case *String:
+		return &encodedNode{Kind: stringNode, Text: v.value, Label: v.isLabel}, nil
+	case *Variable:
+		return &encodedNode{Kind: variableNode, Text: v.name}, nil
+	case *Expression:
+		nodes, err := encodeNodes(v.varExprs())
+		if err != nil {
+			return nil, err
+		}
+		return &encodedNode{Kind: exprNode, Names: v.varOrder, Nodes: nodes}, nil
+	case *ListExpression:
+		nodes, err := encodeNodes(v.items)
+		if err != nil {
+			return nil, err
+		}
+		return &encodedNode{Kind: listNode, Nodes: nodes}, nil
+	case *functionCall:
+		nodes, err := encodeNodes(v.params)
+		if err != nil {
+			return nil, err
+		}
+		return &encodedNode{Kind: functionNode, Text: v.name, Nodes: nodes}, nil
+	case *TableCall:
+		nodes, err := encodeNodes(v.params)
+		if err != nil {
+			return nil, err
+		}
+		tag, err := encodeNode(v.tag)
+		if err != nil {
+			return nil, err
+		}
+		return &encodedNode{
+			Kind:     callNode,
+			Text:     v.tableName,
+			Pack:     v.packageKey,
+			PackName: v.packageName,
+			Nodes:    nodes,
+			Tag:      tag,
+		}, nil
+	case *Roll:
+		return &encodedNode{Kind: rollNode, Roll: v.encode()}, nil
+	case *inlineTable:
+		rows, err := encodeRows(v.table.rows)
+		if err != nil {
+			return nil, err
+		}
+		return &encodedNode{Kind: inlineNode, Rows: rows}, nil
+	}
+	return nil, fmt.Errorf("can't encode %T", e)
+}
+
+func encodeNodes(values []Evallable) ([]*encodedNode, error) {
+	result := make([]*encodedNode, 0, len(values))
+	for _, v := range values {
+		node, err := encodeNode(v)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, node)
+	}
+	return result, nil
+}
+
+// decodeNode decodes an expression, nil for a nil node.
+func decodeNode(n *encodedNode) (Evallable, error) {
+	if n == nil {
+		return nil, nil
+	}
+	switch n.Kind {
+	case numberNode:
+		return NewNumber(n.Int), nil
+	case stringNode:
+		return NewString(n.Text, n.Label), nil
+	case variableNode:
+		return NewVariable(n.Text), nil
+	case exprNode:
+		values, err := decodeNodes(n.Nodes)
+		if err != nil {
+			return nil, err
+		}
+		if len(values) != len(n.Names)+1 {
+			return nil, fmt.Errorf("expression has %d values for %d variables", len(values), len(n.Names))
+		}
+		vars := make(map[string]Evallable)
+		for i, name := range n.Names {
+			vars[name] = values[i]
+		}
+		return NewExpression(n.Names, vars, values[len(n.Names)]), nil
+	case listNode:
+		values, err := decodeNodes(n.Nodes)
+		if err != nil {
+			return nil, err
+		}
+		return NewListExpression(values), nil
+	case functionNode:
+		values, err := decodeNodes(n.Nodes)
+		if err != nil {
+			return nil, err
+		}
+		return NewFunction(n.Text, values)
+	case callNode:
+		values, err := decodeNodes(n.Nodes)
+		if err != nil {
+			return nil, err
+		}
+		tag, err := decodeNode(n.Tag)
+		if err != nil {
+			return nil, err
+		}
+		return NewFilteredTableCall(n.Pack, n.PackName, n.Text, values, tag)
+	case rollNode:
+		if n.Roll == nil {
+			return nil, fmt.Errorf("roll node has no roll")
+		}
+		return n.Roll.decode(), nil
+	case inlineNode:
+		rows, err := decodeRows(n.Rows)
+		if err != nil {
+			return nil, err
+		}
+		return NewInlineTable(rows), nil
+	}
+	return nil, fmt.Errorf("unknown node kind '%s'", n.Kind)
+}
+
+func decodeNodes(nodes []*encodedNode) ([]Evallable, error) {
+	result := make([]Evallable, 0, len(nodes))
+	for _, n := range nodes {
+		value, err := decodeNode(n)
+		if err != nil {
+			return nil, err
+		}
+		if value == nil {
+			return nil, fmt.Errorf("missing value in node list")
+		}
+		result = append(result, value)
+	}
+	return result, nil
+}
+
+// varExprs returns the variable values of the expression in order followed
+// by its value.
+func (e *Expression) varExprs() []Evallable {
+	result := make([]Evallable, 0, len(e.varOrder)+1)
+	for _, name := range e.varOrder {
+		result = append(result, e.vars[name])
+	}
+	return append(result, e.expr)
+}
+
+func (r *Roll) encode() *encodedRoll {
+	result := &encodedRoll{
+		Count: r.diceCount,
+		Sides: r.diceSides,
+		Print: r.print,
+		Aggr:  r.aggrFn,
+	}
+	if r.selector != nil {
+		high := r.selector.high
+		result.High = &high
+		result.Keep = r.selector.count
+	}
+	for _, a := range r.countAggrs {
+		result.CountAggrs = append(result.CountAggrs, [2]int{a.number, a.multiplier})
+	}
+	return result
+}
+
+func (e *encodedRoll) decode() *Roll {
+	result := NewRoll(e.Count, e.Sides).WithPrint(e.Print).WithAggr(e.Aggr)
+	if e.High != nil {
+		result.WithSelector(NewRollSelect(*e.High, e.Keep))
+	}
+	if len(e.CountAggrs) > 0 {
+		aggrs := make([]*RollCountAggr, 0, len(e.CountAggrs))
+		for _, a := range e.CountAggrs {
+			aggrs = append(aggrs, NewRollCountAggr(a[0], a[1]))
+		}
+		result.WithCountAggr(aggrs)
+	}
+	return result
+}
@@ -58,6 +58,20 @@ var (
 			resolve:     lowerResolve,
 			verifyParam: onlyStringVerify,
 		},
+		"capitalize": {
+			funcName:    "capitalize",
+			minParams:   1,
+			maxParams:   1,
+			resolve:     capitalizeResolve,
+			verifyParam: onlyStringVerify,
+		},
+		"capitalize_all": {
+			funcName:    "capitalize_all",
+			minParams:   1,
+			maxParams:   1,
+			resolve:     capitalizeAllResolve,
+			verifyParam: onlyStringVerify,
+		},
 		"str": {
 			funcName:    "str",
 			minParams:   1,
@@ -107,29 +121,108 @@ var (
 			resolve:     lteResolve,
 			verifyParam: anyVerify,
 		},
-		"and": {
-			funcName:    "and",
-			minParams:   2,
-			maxParams:   -1,
-			resolve:     andResolve,
+		"not": {
+			funcName:    "not",
+			minParams:   1,
+			maxParams:   1,
+			resolve:     notResolve,
 			verifyParam: onlyIntVerify,
 		},
-		"or": {
-			funcName:    "or",
-			minParams:   2,
-			maxParams:   -1,
-			resolve:     orResolve,
+		"a": {
+			funcName:    "a",
+			minParams:   1,
+			maxParams:   1,
+			ctxResolve:  articleResolve,
+			verifyParam: onlyStringVerify,
+		},
+		"an": {
+			funcName:    "an",
+			minParams:   1,
+			maxParams:   1,
+			ctxResolve:  articleResolve,
+			verifyParam: onlyStringVerify,
+		},
+		"plural": {
+			funcName:    "plural",
+			minParams:   1,
+			maxParams:   2,
+			ctxResolve:  pluralResolve,
+			verifyParam: pluralVerify,
+		},
+		"ordinal": {
+			funcName:    "ordinal",
+			minParams:   1,
+			maxParams:   1,
+			ctxResolve:  ordinalResolve,
 			verifyParam: onlyIntVerify,
 		},
-		"not": {
-			funcName:    "not",
+		"words": {
+			funcName:    "words",
 			minParams:   1,
 			maxParams:   1,
-			resolve:     notResolve,
+			ctxResolve:  wordsResolve,
 			verifyParam: onlyIntVerify,
 		},
+		"list_en": {
+			funcName:    "list_en",
+			minParams:   1,
+			maxParams:   -1,
+			resolve:     listEnResolve,
+			verifyParam: anyVerify,
+		},
+		"list": {
+			funcName:    "list",
+			minParams:   1,
+			maxParams:   -1,
+			ctxResolve:  listResolve,
+			verifyParam: anyVerify,
+		},
+		"tag": {
+			funcName:    "tag",
+			minParams:   2,
+			maxParams:   2,
+			ctxResolve:  tagResolve,
+			verifyParam: onlyStringVerify,
+		},
+		"rows": {
+			funcName:    "rows",
+			minParams:   1,
+			maxParams:   1,
+			ctxResolve:  rowsResolve,
+			verifyParam: onlyStringVerify,
+		},
+		"labels": {
+			funcName:    "labels",
+			minParams:   1,
+			maxParams:   1,
+			ctxResolve:  labelsResolve,
+			verifyParam: onlyStringVerify,
+		},
+		"weight": {
+			funcName:    "weight",
+			minParams:   1,
+			maxParams:   1,
+			ctxResolve:  weightResolve,
+			verifyParam: onlyStringVerify,
+		},
+		"exists": {
+			funcName:    "exists",
+			minParams:   1,
+			maxParams:   1,
+			ctxResolve:  existsResolve,
+			verifyParam: onlyStringVerify,
+		},
 	}
 	specializedFunctionList = map[string]func(string, []Evallable) (Evallable, error){
-		"if": newIfFunction,
+		"if":          newIfFunction,
+		"repeat":      newRepeatFunction,
+		"for":         newForFunction,
+		"repeat_list": newRepeatListFunction,
+		"for_list":    newForListFunction,
+		"switch":      newSwitchFunction,
+		"cond":        newCondFunction,
+		"try":         newTryFunction,
+		"and":         newAndFunction,
+		"or":          newOrFunction,
 	}
 )
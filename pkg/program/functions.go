@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 // FunctionDef is a way to define a function so it can be used
@@ -14,6 +16,10 @@ type FunctionDef struct {
 	maxParams   int
 	resolve     func([]*ExpressionResult) (*ExpressionResult, error)
 	verifyParam func(ResultType, int) bool
+
+	// ctxResolve is used instead of resolve for functions that need access
+	// to the execution context (e.g. to read override tables from a pack).
+	ctxResolve func(*ExecutionContext, []*ExpressionResult) (*ExpressionResult, error)
 }
 
 // GenericFunction allows a simple FunctionDef to be wrapped for simpler definitions.
@@ -26,6 +32,27 @@ type GenericFunction struct {
 // NewFunction creates a function Evallable, erroring if it can on the
 // format of the parameters.
 func NewFunction(name string, params []Evallable) (Evallable, error) {
+	fn, err := newFunction(name, params)
+	if err != nil {
+		return nil, err
+	}
+	return &functionCall{name: name, params: params, fn: fn}, nil
+}
+
+// functionCall is a function with the name and parameters it was made from,
+// kept so compiled programs can be encoded.
+type functionCall struct {
+	name   string
+	params []Evallable
+	fn     Evallable
+}
+
+// Eval implementation for Evallable interface.
+func (f *functionCall) Eval() ExpressionEval {
+	return f.fn.Eval()
+}
+
+func newFunction(name string, params []Evallable) (Evallable, error) {
 	fn, ok := specializedFunctionList[name]
 	if ok {
 		return fn(name, params)
@@ -99,6 +126,9 @@ func (g *evalGenericFunc) Provide(res *ExpressionResult) error {
 }
 
 func (g *evalGenericFunc) Resolve() (*ExpressionResult, error) {
+	if g.funcDef.config.ctxResolve != nil {
+		return g.funcDef.config.ctxResolve(g.ctx, g.vals)
+	}
 	return g.funcDef.config.resolve(g.vals)
 }
 
@@ -142,6 +172,27 @@ func lowerResolve(results []*ExpressionResult) (*ExpressionResult, error) {
 	return NewStringResult(strings.ToLower(results[0].StringVal())), nil
 }
 
+func capitalizeResolve(results []*ExpressionResult) (*ExpressionResult, error) {
+	return NewStringResult(capitalize(results[0].StringVal())), nil
+}
+
+func capitalizeAllResolve(results []*ExpressionResult) (*ExpressionResult, error) {
+	words := strings.Split(results[0].StringVal(), " ")
+	for i, w := range words {
+		words[i] = capitalize(w)
+	}
+	return NewStringResult(strings.Join(words, " ")), nil
+}
+
+// capitalize upper cases the first letter of s.
+func capitalize(s string) string {
+	if len(s) == 0 {
+		return s
+	}
+	r, size := utf8.DecodeRuneInString(s)
+	return string(unicode.ToUpper(r)) + s[size:]
+}
+
 func toStrResolve(results []*ExpressionResult) (*ExpressionResult, error) {
 	if results[0].MatchType(StringResult) {
 		return results[0], nil
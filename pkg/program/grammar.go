@@ -0,0 +1,369 @@
+package program
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// PluralTable is the name of an optional root pack table whose labelled rows
+	// override plural forms, e.g. `ox: "oxen"`.
+	PluralTable = "grammar-plural"
+
+	// ArticleTable is the name of an optional root pack table whose labelled rows
+	// override the indefinite article for a word, e.g. `hour: "an"`.
+	ArticleTable = "grammar-article"
+)
+
+// languageRules is the set of grammar rules used by the grammar functions
+// for a single language.
+type languageRules struct {
+	article     func(word string) string
+	plural      func(word string) string
+	ordinal     func(n int) string
+	words       func(n int) string
+	conjunction string
+	// phraseSplit marks where the head of a phrase ends for pluralization,
+	// e.g. " of " in "potion of healing".
+	phraseSplit string
+}
+
+var (
+	englishRules = &languageRules{
+		article: englishArticle,
+		plural:  englishPlural,
+		ordinal: func(n int) string {
+			return strconv.Itoa(n) + englishOrdinalSuffix(n)
+		},
+		words:       englishWords,
+		conjunction: "and",
+		phraseSplit: " of ",
+	}
+
+	// grammarRules maps base languages to rules, English is the default.
+	grammarRules = map[string]*languageRules{
+		"en": englishRules,
+		"de": germanRules,
+	}
+
+	englishIrregularPlurals = map[string]string{
+		"aircraft": "aircraft",
+		"cactus":   "cacti",
+		"child":    "children",
+		"deer":     "deer",
+		"die":      "dice",
+		"dwarf":    "dwarves",
+		"echo":     "echoes",
+		"elf":      "elves",
+		"fish":     "fish",
+		"foot":     "feet",
+		"fungus":   "fungi",
+		"goose":    "geese",
+		"half":     "halves",
+		"hero":     "heroes",
+		"knife":    "knives",
+		"leaf":     "leaves",
+		"life":     "lives",
+		"louse":    "lice",
+		"man":      "men",
+		"moose":    "moose",
+		"mouse":    "mice",
+		"ox":       "oxen",
+		"person":   "people",
+		"potato":   "potatoes",
+		"series":   "series",
+		"sheep":    "sheep",
+		"species":  "species",
+		"staff":    "staves",
+		"thief":    "thieves",
+		"tomato":   "tomatoes",
+		"tooth":    "teeth",
+		"wife":     "wives",
+		"wolf":     "wolves",
+		"woman":    "women",
+	}
+
+	// Word starts that begin with a vowel letter but a consonant sound and vice
+	// versa. They're long enough not to catch words like "uninformed" or
+	// "onerous", whole word exceptions are in englishArticleWords.
+	englishArticleExceptions = map[string]string{
+		"eu":     "a",
+		"one-":   "a",
+		"once":   "a",
+		"unic":   "a",
+		"unif":   "a",
+		"union":  "a",
+		"uniq":   "a",
+		"unis":   "a",
+		"unit":   "a",
+		"univ":   "a",
+		"use":    "a",
+		"usu":    "a",
+		"uti":    "a",
+		"heir":   "an",
+		"honest": "an",
+		"honor":  "an",
+		"honour": "an",
+		"hour":   "an",
+	}
+
+	englishArticleWords = map[string]string{
+		"one":  "a",
+		"ones": "a",
+	}
+
+	englishSmallNumbers = []string{
+		"zero", "one", "two", "three", "four", "five", "six", "seven", "eight", "nine",
+		"ten", "eleven", "twelve", "thirteen", "fourteen", "fifteen", "sixteen",
+		"seventeen", "eighteen", "nineteen",
+	}
+
+	englishTens = []string{
+		"", "", "twenty", "thirty", "forty", "fifty", "sixty", "seventy", "eighty", "ninety",
+	}
+
+	englishScales = []struct {
+		value int
+		name  string
+	}{
+		{1000000000, "billion"},
+		{1000000, "million"},
+		{1000, "thousand"},
+		{100, "hundred"},
+	}
+)
+
+// lookupOverride checks the root pack for a table with the given name and
+// returns the evaluated row labelled with the given word, if there is one.
+func lookupOverride(ctx *ExecutionContext, tableName string, word string) (string, bool, error) {
+	if ctx == nil || ctx.packs == nil {
+		return "", false, nil
+	}
+	pack, ok := ctx.packs[RootPack]
+	if !ok {
+		return "", false, nil
+	}
+	table, ok := pack.table(tableName, ctx.Locale())
+	if !ok {
+		return "", false, nil
+	}
+	row, ok := table.rowsByLabel[word]
+	if !ok {
+		row, ok = table.rowsByLabel[strings.ToLower(word)]
+	}
+	if !ok {
+		return "", false, nil
+	}
+	res, err := EvaluateExpression(row.Value(), ctx)
+	if err != nil {
+		return "", false, err
+	}
+	return resultString(res), true, nil
+}
+
+// rulesFor returns the grammar rules for a locale, defaulting to English.
+func rulesFor(locale string) *languageRules {
+	if r, ok := grammarRules[baseLanguage(strings.ToLower(locale))]; ok {
+		return r
+	}
+	return englishRules
+}
+
+func resultString(res *ExpressionResult) string {
+	if res.MatchType(StringResult) {
+		return res.StringVal()
+	}
+	return strconv.Itoa(res.IntVal())
+}
+
+// matchCase capitalizes the first letter of word if model starts with a capital.
+func matchCase(model string, word string) string {
+	first, _ := utf8.DecodeRuneInString(model)
+	if !unicode.IsUpper(first) || len(word) == 0 {
+		return word
+	}
+	r, size := utf8.DecodeRuneInString(word)
+	return string(unicode.ToUpper(r)) + word[size:]
+}
+
+func articleResolve(ctx *ExecutionContext, results []*ExpressionResult) (*ExpressionResult, error) {
+	word := strings.TrimSpace(results[0].StringVal())
+	if len(word) == 0 {
+		return NewStringResult(""), nil
+	}
+	first := strings.Fields(word)[0]
+	article, ok, err := lookupOverride(ctx, ArticleTable, first)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		article = rulesFor(ctx.Locale()).article(first)
+	}
+	return NewStringResult(article + " " + word), nil
+}
+
+func englishArticle(word string) string {
+	lower := strings.ToLower(word)
+	if article, ok := englishArticleWords[lower]; ok {
+		return article
+	}
+	for prefix, article := range englishArticleExceptions {
+		if strings.HasPrefix(lower, prefix) {
+			return article
+		}
+	}
+	if lower == "11" || lower == "18" || strings.HasPrefix(lower, "8") {
+		return "an"
+	}
+	if strings.ContainsRune("aeiou", rune(lower[0])) {
+		return "an"
+	}
+	return "a"
+}
+
+func pluralResolve(ctx *ExecutionContext, results []*ExpressionResult) (*ExpressionResult, error) {
+	word := results[0].StringVal()
+	if len(results) > 1 && (results[1].IntVal() == 1 || results[1].IntVal() == -1) {
+		return NewStringResult(word), nil
+	}
+	rules := rulesFor(ctx.Locale())
+	// Pluralize the head of "x of y" phrases and the last word otherwise.
+	head, tail := word, ""
+	if i := strings.Index(word, rules.phraseSplit); len(rules.phraseSplit) > 0 && i >= 0 {
+		head, tail = word[:i], word[i:]
+	}
+	prefix := ""
+	if i := strings.LastIndex(head, " "); i >= 0 {
+		prefix, head = head[:i+1], head[i+1:]
+	}
+	plural, ok, err := lookupOverride(ctx, PluralTable, head)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		plural = rules.plural(head)
+	}
+	return NewStringResult(prefix + plural + tail), nil
+}
+
+func englishPlural(word string) string {
+	if len(word) == 0 {
+		return word
+	}
+	lower := strings.ToLower(word)
+	if p, ok := englishIrregularPlurals[lower]; ok {
+		return matchCase(word, p)
+	}
+	for _, suffix := range []string{"s", "x", "z", "ch", "sh"} {
+		if strings.HasSuffix(lower, suffix) {
+			return word + "es"
+		}
+	}
+	if len(lower) > 1 && strings.HasSuffix(lower, "y") && !strings.ContainsRune("aeiou", rune(lower[len(lower)-2])) {
+		return word[:len(word)-1] + "ies"
+	}
+	return word + "s"
+}
+
+func ordinalResolve(ctx *ExecutionContext, results []*ExpressionResult) (*ExpressionResult, error) {
+	return NewStringResult(rulesFor(ctx.Locale()).ordinal(results[0].IntVal())), nil
+}
+
+func englishOrdinalSuffix(n int) string {
+	if n < 0 {
+		n = -n
+	}
+	if n%100 >= 11 && n%100 <= 13 {
+		return "th"
+	}
+	switch n % 10 {
+	case 1:
+		return "st"
+	case 2:
+		return "nd"
+	case 3:
+		return "rd"
+	}
+	return "th"
+}
+
+func wordsResolve(ctx *ExecutionContext, results []*ExpressionResult) (*ExpressionResult, error) {
+	return NewStringResult(rulesFor(ctx.Locale()).words(results[0].IntVal())), nil
+}
+
+func englishWords(n int) string {
+	if n < 0 {
+		return "minus " + englishMagnitude(negativeMagnitude(n))
+	}
+	return englishMagnitude(uint(n))
+}
+
+func englishMagnitude(n uint) string {
+	if n < 20 {
+		return englishSmallNumbers[n]
+	}
+	if n < 100 {
+		if n%10 == 0 {
+			return englishTens[n/10]
+		}
+		return englishTens[n/10] + "-" + englishSmallNumbers[n%10]
+	}
+	for _, scale := range englishScales {
+		value := uint(scale.value)
+		if n >= value {
+			result := englishMagnitude(n/value) + " " + scale.name
+			if n%value != 0 {
+				result += " " + englishMagnitude(n%value)
+			}
+			return result
+		}
+	}
+	return strconv.FormatUint(uint64(n), 10)
+}
+
+// negativeMagnitude returns -n for a negative n, without overflowing for the
+// smallest int.
+func negativeMagnitude(n int) uint {
+	return uint(-(n + 1)) + 1
+}
+
+func listEnResolve(results []*ExpressionResult) (*ExpressionResult, error) {
+	return NewStringResult(joinList(resultStrings(results), englishRules.conjunction)), nil
+}
+
+func listResolve(ctx *ExecutionContext, results []*ExpressionResult) (*ExpressionResult, error) {
+	return NewStringResult(joinList(resultStrings(results), rulesFor(ctx.Locale()).conjunction)), nil
+}
+
+func resultStrings(results []*ExpressionResult) []string {
+	items := make([]string, 0, len(results))
+	for _, r := range results {
+		items = append(items, resultString(r))
+	}
+	return items
+}
+
+// joinList joins items as "a, b <conjunction> c".
+func joinList(items []string, conjunction string) string {
+	switch len(items) {
+	case 0:
+		return ""
+	case 1:
+		return items[0]
+	}
+	return fmt.Sprintf("%s %s %s",
+		strings.Join(items[:len(items)-1], ", "),
+		conjunction,
+		items[len(items)-1],
+	)
+}
+
+func pluralVerify(t ResultType, index int) bool {
+	if index == 0 {
+		return t == StringResult
+	}
+	return t == IntResult
+}
@@ -0,0 +1,112 @@
+package program
+
+import (
+	"strconv"
+	"strings"
+)
+
+var (
+	// German grammar needs the gender of a noun for articles and most plurals are
+	// irregular, so the defaults here are best effort. Packs should list their
+	// nouns in the `grammar-article` and `grammar-plural` tables with `~ lang: de`.
+	germanRules = &languageRules{
+		article: func(word string) string {
+			return "ein"
+		},
+		plural: germanPlural,
+		ordinal: func(n int) string {
+			return strconv.Itoa(n) + "."
+		},
+		words:       germanWords,
+		conjunction: "und",
+	}
+
+	germanIrregularPlurals = map[string]string{
+		"buch":    "Bücher",
+		"haus":    "Häuser",
+		"kind":    "Kinder",
+		"mann":    "Männer",
+		"schwert": "Schwerter",
+		"stadt":   "Städte",
+		"wolf":    "Wölfe",
+		"zwerg":   "Zwerge",
+	}
+
+	germanSmallNumbers = []string{
+		"null", "eins", "zwei", "drei", "vier", "fünf", "sechs", "sieben", "acht", "neun",
+		"zehn", "elf", "zwölf", "dreizehn", "vierzehn", "fünfzehn", "sechzehn",
+		"siebzehn", "achtzehn", "neunzehn",
+	}
+
+	germanTens = []string{
+		"", "", "zwanzig", "dreißig", "vierzig", "fünfzig", "sechzig", "siebzig", "achtzig", "neunzig",
+	}
+)
+
+func germanPlural(word string) string {
+	lower := strings.ToLower(word)
+	if p, ok := germanIrregularPlurals[lower]; ok {
+		return matchCase(word, p)
+	}
+	switch {
+	case strings.HasSuffix(lower, "in"):
+		return word + "nen"
+	case strings.HasSuffix(lower, "e"):
+		return word + "n"
+	case strings.HasSuffix(lower, "el"),
+		strings.HasSuffix(lower, "er"),
+		strings.HasSuffix(lower, "en"):
+		return word
+	case strings.HasSuffix(lower, "ung"),
+		strings.HasSuffix(lower, "heit"),
+		strings.HasSuffix(lower, "keit"),
+		strings.HasSuffix(lower, "schaft"):
+		return word + "en"
+	}
+	return word + "e"
+}
+
+func germanWords(n int) string {
+	if n < 0 {
+		return "minus " + germanMagnitude(negativeMagnitude(n))
+	}
+	return germanMagnitude(uint(n))
+}
+
+func germanMagnitude(n uint) string {
+	if n < 20 {
+		return germanSmallNumbers[n]
+	}
+	return germanCompound(n, true)
+}
+
+// germanCompound writes n as a single compound word, `final` is whether a
+// trailing 1 should be written "eins" rather than "ein".
+func germanCompound(n uint, final bool) string {
+	switch {
+	case n == 0:
+		return ""
+	case n == 1 && !final:
+		return "ein"
+	case n < 20:
+		return germanSmallNumbers[n]
+	case n < 100:
+		if n%10 == 0 {
+			return germanTens[n/10]
+		}
+		return germanCompound(n%10, false) + "und" + germanTens[n/10]
+	case n < 1000:
+		return germanCompound(n/100, false) + "hundert" + germanCompound(n%100, final)
+	case n < 1000000:
+		return germanCompound(n/1000, false) + "tausend" + germanCompound(n%1000, final)
+	}
+	millions := n / 1000000
+	result := "eine Million"
+	if millions > 1 {
+		result = germanMagnitude(millions) + " Millionen"
+	}
+	if n%1000000 != 0 {
+		result += " " + germanCompound(n%1000000, true)
+	}
+	return result
+}
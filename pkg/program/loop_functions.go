@@ -0,0 +1,195 @@
+package program
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// maxLoopIterations limits loop functions so a bad count can't hang evaluation.
+	maxLoopIterations = 10000
+)
+
+// loopFunction is a specialized function that evaluates its body a number of times,
+// each time in a fresh child context, and joins the results as a string. The
+// list forms collect the results into a list for the locale, like list().
+//
+//  repeat(<count>, <body>, <separator>?)
+//  for(@<var>, <from>, <to>, <body>, <separator>?)
+//  repeat_list(<count>, <body>)
+//  for_list(@<var>, <from>, <to>, <body>)
+type loopFunction struct {
+	name    string
+	varName string
+	setup   []Evallable
+	body    Evallable
+	list    bool
+}
+
+func newRepeatFunction(name string, vals []Evallable) (Evallable, error) {
+	if len(vals) < 2 || len(vals) > 3 {
+		return nil, fmt.Errorf("need 2 or 3 parameters for '%s', was passed %d", name, len(vals))
+	}
+	setup := []Evallable{vals[0]}
+	if len(vals) == 3 {
+		setup = append(setup, vals[2])
+	}
+	return &loopFunction{
+		name:  name,
+		setup: setup,
+		body:  vals[1],
+	}, nil
+}
+
+func newRepeatListFunction(name string, vals []Evallable) (Evallable, error) {
+	if len(vals) != 2 {
+		return nil, fmt.Errorf("need 2 parameters for '%s', was passed %d", name, len(vals))
+	}
+	return &loopFunction{
+		name:  name,
+		setup: []Evallable{vals[0]},
+		body:  vals[1],
+		list:  true,
+	}, nil
+}
+
+func newForFunction(name string, vals []Evallable) (Evallable, error) {
+	if len(vals) < 4 || len(vals) > 5 {
+		return nil, fmt.Errorf("need 4 or 5 parameters for '%s', was passed %d", name, len(vals))
+	}
+	return forFunction(name, vals)
+}
+
+func newForListFunction(name string, vals []Evallable) (Evallable, error) {
+	if len(vals) != 4 {
+		return nil, fmt.Errorf("need 4 parameters for '%s', was passed %d", name, len(vals))
+	}
+	result, err := forFunction(name, vals)
+	if err != nil {
+		return nil, err
+	}
+	result.list = true
+	return result, nil
+}
+
+func forFunction(name string, vals []Evallable) (*loopFunction, error) {
+	v, ok := vals[0].(*Variable)
+	if !ok {
+		return nil, fmt.Errorf("first parameter for '%s' must be a variable like @i", name)
+	}
+	setup := []Evallable{vals[1], vals[2]}
+	if len(vals) == 5 {
+		setup = append(setup, vals[4])
+	}
+	return &loopFunction{
+		name:    name,
+		varName: v.name,
+		setup:   setup,
+		body:    vals[3],
+	}, nil
+}
+
+func (l *loopFunction) Eval() ExpressionEval {
+	return &loopFunctionEval{
+		config:  l,
+		setup:   make([]*ExpressionResult, 0, len(l.setup)),
+		results: make([]string, 0),
+	}
+}
+
+type loopFunctionEval struct {
+	ctx     *ExecutionContext
+	config  *loopFunction
+	setup   []*ExpressionResult
+	results []string
+	// Loop variable and iterations left, set once all setup values are evaluated.
+	current   int
+	remaining int
+	sep       string
+}
+
+func (l *loopFunctionEval) SetContext(ctx *ExecutionContext) ExpressionEval {
+	l.ctx = ctx
+	return l
+}
+
+func (l *loopFunctionEval) settingUp() bool {
+	return len(l.setup) < len(l.config.setup)
+}
+
+func (l *loopFunctionEval) HasNext() bool {
+	return l.settingUp() || l.remaining > 0
+}
+
+func (l *loopFunctionEval) Next() (ExpressionEval, error) {
+	if l.settingUp() {
+		return l.config.setup[len(l.setup)].Eval().SetContext(l.ctx.Child()), nil
+	}
+	iterCtx := l.ctx.Child()
+	if len(l.config.varName) > 0 {
+		iterCtx.Set(l.config.varName, NewIntResult(l.current))
+	}
+	return l.config.body.Eval().SetContext(iterCtx), nil
+}
+
+func (l *loopFunctionEval) Provide(res *ExpressionResult) error {
+	if !l.settingUp() {
+		l.results = append(l.results, resultString(res))
+		l.current++
+		l.remaining--
+		return nil
+	}
+	l.setup = append(l.setup, res)
+	if l.settingUp() {
+		return nil
+	}
+	return l.start()
+}
+
+// start sets the loop bounds from the evaluated setup values.
+func (l *loopFunctionEval) start() error {
+	bounds := 1
+	if len(l.config.varName) > 0 {
+		bounds = 2
+	}
+	for i, r := range l.setup[:bounds] {
+		if !r.MatchType(IntResult) {
+			return fmt.Errorf("parameter %d for '%s' must be an integer expression", i+1, l.config.name)
+		}
+	}
+	if len(l.setup) > bounds {
+		l.sep = resultString(l.setup[bounds])
+	}
+	if bounds == 1 {
+		if l.setup[0].IntVal() < 0 {
+			return fmt.Errorf("'%s' count can't be negative, was %d", l.config.name, l.setup[0].IntVal())
+		}
+		l.current, l.remaining = 1, l.setup[0].IntVal()
+		if l.remaining > maxLoopIterations {
+			return l.tooLong()
+		}
+		return nil
+	}
+	from, to := l.setup[0].IntVal(), l.setup[1].IntVal()
+	l.current = from
+	if to < from {
+		return nil
+	}
+	// Compared as unsigned so bounds far apart can't overflow past the limit.
+	if uint64(to)-uint64(from) >= maxLoopIterations {
+		return l.tooLong()
+	}
+	l.remaining = to - from + 1
+	return nil
+}
+
+func (l *loopFunctionEval) tooLong() error {
+	return fmt.Errorf("'%s' can't loop more than %d times", l.config.name, maxLoopIterations)
+}
+
+func (l *loopFunctionEval) Resolve() (*ExpressionResult, error) {
+	if l.config.list {
+		return NewStringResult(joinList(l.results, rulesFor(l.ctx.Locale()).conjunction)), nil
+	}
+	return NewStringResult(strings.Join(l.results, l.sep)), nil
+}
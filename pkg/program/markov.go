@@ -0,0 +1,168 @@
+package program
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	// MarkovTag makes a table generate names from an order-N character Markov
+	// model trained on its rows, e.g. `~ markov: 2`.
+	MarkovTag = "markov"
+	// MinLengthTag is the shortest name a markov table generates.
+	MinLengthTag = "min-length"
+	// MaxLengthTag is the longest name a markov table generates.
+	MaxLengthTag = "max-length"
+	// NewOnlyTag stops a markov table from generating its own rows, `~ new-only: true`.
+	NewOnlyTag = "new-only"
+
+	// markovTries is how many names are generated looking for one that fits.
+	markovTries = 100
+	markovStart = '\x02'
+	markovEnd   = '\x03'
+)
+
+// MarkovOptions configures the names generated by a markov table.
+// A MaxLength of 0 is unlimited.
+type MarkovOptions struct {
+	Order     int
+	MinLength int
+	MaxLength int
+	NewOnly   bool
+}
+
+// markovNext is a possible next character after a state and how often the
+// training samples have it.
+type markovNext struct {
+	char  rune
+	count int
+}
+
+// markov is a character Markov model, states are the last `order` characters.
+// Next characters are kept in the order they were first seen so rolls are
+// repeatable with the same random source.
+type markov struct {
+	opts    MarkovOptions
+	next    map[string][]*markovNext
+	samples map[string]bool
+}
+
+// SetMarkov trains a Markov model from the table rows, which must be plain
+// strings. Roll and WeightedRoll on the table then generate names from it.
+func (t *Table) SetMarkov(opts MarkovOptions) error {
+	if opts.Order < 1 {
+		return fmt.Errorf("table '%s' markov order must be at least 1", t.name)
+	}
+	if opts.MaxLength > 0 && opts.MaxLength < opts.MinLength {
+		return fmt.Errorf("table '%s' max length %d is less than min length %d",
+			t.name, opts.MaxLength, opts.MinLength)
+	}
+	m := &markov{
+		opts:    opts,
+		next:    make(map[string][]*markovNext),
+		samples: make(map[string]bool),
+	}
+	for i, r := range t.rows {
+		sample, ok := literalString(r.value)
+		if !ok {
+			return fmt.Errorf("table '%s' row %d must be a plain string to train a markov table", t.name, i+1)
+		}
+		m.train(sample)
+	}
+	t.markov = m
+	return nil
+}
+
+// literalString returns the value of a string or list of strings.
+func literalString(e Evallable) (string, bool) {
+	switch v := e.(type) {
+	case *String:
+		return v.value, true
+	case *ListExpression:
+		result := ""
+		for _, i := range v.items {
+			s, ok := literalString(i)
+			if !ok {
+				return "", false
+			}
+			result += s
+		}
+		return result, true
+	}
+	return "", false
+}
+
+func (m *markov) train(sample string) {
+	m.samples[sample] = true
+	state := []rune(strings.Repeat(string(markovStart), m.opts.Order))
+	for _, c := range append([]rune(sample), markovEnd) {
+		m.add(string(state), c)
+		state = append(state[1:], c)
+	}
+}
+
+func (m *markov) add(state string, c rune) {
+	for _, n := range m.next[state] {
+		if n.char == c {
+			n.count++
+			return
+		}
+	}
+	m.next[state] = append(m.next[state], &markovNext{char: c, count: 1})
+}
+
+// generate makes names until one fits the length and new-only options.
+func (m *markov) generate(ctx *ExecutionContext) (string, error) {
+	for i := 0; i < markovTries; i++ {
+		name, ok := m.walk(ctx)
+		length := len([]rune(name))
+		if !ok || length < m.opts.MinLength || (m.opts.NewOnly && m.samples[name]) {
+			continue
+		}
+		return name, nil
+	}
+	return "", fmt.Errorf("couldn't generate a name that fits in %d tries", markovTries)
+}
+
+// walk generates one name, ok is false if it grew longer than the max length.
+func (m *markov) walk(ctx *ExecutionContext) (string, bool) {
+	state := []rune(strings.Repeat(string(markovStart), m.opts.Order))
+	name := make([]rune, 0)
+	for {
+		options := m.next[string(state)]
+		total := 0
+		for _, n := range options {
+			total += n.count
+		}
+		roll := ctx.Rand(0, total)
+		c := options[len(options)-1].char
+		for _, n := range options {
+			if n.count > roll {
+				c = n.char
+				break
+			}
+			roll -= n.count
+		}
+		if c == markovEnd {
+			return string(name), true
+		}
+		name = append(name, c)
+		if m.opts.MaxLength > 0 && len(name) > m.opts.MaxLength {
+			return "", false
+		}
+		state = append(state[1:], c)
+	}
+}
+
+// markovRoll generates a name from the table's Markov model.
+func (t *Table) markovRoll() Evallable {
+	return &rowFuture{
+		fn: func(ctx *ExecutionContext) Evallable {
+			name, err := t.markov.generate(ctx)
+			if err != nil {
+				return &failedValue{err: fmt.Errorf("table '%s': %w", t.name, err)}
+			}
+			return NewString(name, false)
+		},
+	}
+}
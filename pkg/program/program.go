@@ -2,12 +2,21 @@ package program
 
 import (
 	"fmt"
+	"sort"
+	"strings"
 	"sync"
 )
 
 const (
 	// RootPack is the default key for table calls.
 	RootPack = "_ROOT"
+
+	// LangTag is the table tag that marks a table as a language variant
+	// of another table with the same name, e.g. `~ lang: de`.
+	LangTag = "lang"
+
+	// InlineTableName is the name of anonymous inline tables in errors.
+	InlineTableName = "<inline>"
 )
 
 // Evallable is an interface for a loaded program unit to provide
@@ -80,6 +89,7 @@ type Program struct {
 func NewProgram(packs TableMap) *Program {
 	ctx := NewRootExecutionContext()
 	ctx.packs = packs
+	ctx.recent = NewRecentPicks()
 	return &Program{
 		packs: packs,
 		ctx:   ctx,
@@ -91,48 +101,216 @@ func (p *Program) PackCount() int {
 	return len(p.packs)
 }
 
+// Packs returns the packs loaded into the program, the root pack first and
+// imports sorted by name.
+func (p *Program) Packs() []*TablePack {
+	root := p.packs[RootPack]
+	result := make([]*TablePack, 0, len(p.packs))
+	for _, v := range p.packs {
+		if v != root {
+			result = append(result, v)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].name < result[j].name
+	})
+	if root != nil {
+		result = append([]*TablePack{root}, result...)
+	}
+	return result
+}
+
 // SetHistory uses the given RollHistory for rolls.
 func (p *Program) SetHistory(h *RollHistory) {
 	p.ctx.SetHistory(h)
 }
 
+// SetRandom uses the given RandomSource for rolls.
+func (p *Program) SetRandom(r RandomSource) {
+	p.ctx.SetRandom(r)
+}
+
+// SetRecentPicks uses the given RecentPicks for tables with the avoid-repeat tag.
+func (p *Program) SetRecentPicks(r *RecentPicks) {
+	p.ctx.SetRecentPicks(r)
+}
+
+// SetLocale sets the default locale used to pick table variants and grammar rules.
+func (p *Program) SetLocale(locale string) {
+	p.ctx.SetLocale(locale)
+}
+
+// Locale returns the default locale of the program.
+func (p *Program) Locale() string {
+	return p.ctx.Locale()
+}
+
 // Eval Evaluates a given Evallable against this program's state (tables+context).
 func (p *Program) Eval(expr Evallable) (*ExpressionResult, error) {
 	return EvaluateExpression(expr, p.ctx.Child())
 }
 
+// EvalWith evaluates a given Evallable like Eval, with the given variables set.
+func (p *Program) EvalWith(expr Evallable, vars map[string]*ExpressionResult) (*ExpressionResult, error) {
+	ctx := p.ctx.Child()
+	for k, v := range vars {
+		ctx.Set(k, v)
+	}
+	return EvaluateExpression(expr, ctx)
+}
+
+// EvalLocale evaluates a given Evallable like Eval, but with the given locale
+// instead of the program's default. An empty locale uses the program default.
+func (p *Program) EvalLocale(expr Evallable, locale string) (*ExpressionResult, error) {
+	ctx := p.ctx.Child()
+	if len(locale) > 0 {
+		ctx.SetLocale(locale)
+	}
+	return EvaluateExpression(expr, ctx)
+}
+
 // Copy returns a deep copy of the Program
 func (p *Program) Copy() *Program {
 	packs := make(TableMap)
+	// The root pack is stored under its own key too, keep one copy for both.
+	copies := make(map[*TablePack]*TablePack)
 	for k, v := range p.packs {
-		packs[k] = v.Copy()
+		if _, ok := copies[v]; !ok {
+			copies[v] = v.Copy()
+		}
+		packs[k] = copies[v]
 	}
-	return NewProgram(packs)
+	result := NewProgram(packs)
+	result.SetLocale(p.Locale())
+	return result
 }
 
 // NewTablePack creates a new TablePack with the given tables.
 func NewTablePack(key string, name string, tables map[string]*Table) *TablePack {
+	for _, t := range tables {
+		t.packKey = key
+	}
 	return &TablePack{
-		key:    key,
-		name:   name,
-		tables: tables,
+		key:      key,
+		name:     name,
+		tables:   tables,
+		variants: make(map[string]map[string]*Table),
 	}
 }
 
 // TablePack represents a single executable tableman source file.
 type TablePack struct {
-	key    string
-	name   string
-	tables map[string]*Table
+	key      string
+	name     string
+	tables   map[string]*Table
+	variants map[string]map[string]*Table
+	// aliases maps the package names used in the pack to pack keys.
+	aliases map[string]string
 }
 
 // Copy deep copies a TablePack
 func (t *TablePack) Copy() *TablePack {
+	// Without an untagged table a variant is the default too, keep one copy
+	// for both so they share decks and other state.
+	copies := make(map[*Table]*Table)
+	copyTable := func(table *Table) *Table {
+		if _, ok := copies[table]; !ok {
+			copies[table] = table.Copy()
+		}
+		return copies[table]
+	}
 	tables := make(map[string]*Table)
 	for k, v := range t.tables {
-		tables[k] = v.Copy()
+		tables[k] = copyTable(v)
+	}
+	result := NewTablePack(t.key, t.name, tables)
+	result.aliases = t.aliases
+	for name, langs := range t.variants {
+		for lang, v := range langs {
+			result.AddVariant(name, lang, copyTable(v))
+		}
+	}
+	return result
+}
+
+// Name returns the name of the pack from its `TablePack:` line.
+func (t *TablePack) Name() string {
+	return t.name
+}
+
+// Tables returns the tables of the pack sorted by name, with the language
+// variants of a table after it.
+func (t *TablePack) Tables() []*Table {
+	names := make([]string, 0, len(t.tables))
+	for name := range t.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	result := make([]*Table, 0, len(names))
+	seen := make(map[*Table]bool)
+	add := func(table *Table) {
+		if !seen[table] {
+			seen[table] = true
+			result = append(result, table)
+		}
+	}
+	for _, name := range names {
+		add(t.tables[name])
+		langs := make([]string, 0, len(t.variants[name]))
+		for lang := range t.variants[name] {
+			langs = append(langs, lang)
+		}
+		sort.Strings(langs)
+		for _, lang := range langs {
+			add(t.variants[name][lang])
+		}
+	}
+	return result
+}
+
+// SetAliases sets the package names the pack uses for itself and its imports,
+// mapped to pack keys. Used to find tables named in strings at runtime.
+func (t *TablePack) SetAliases(aliases map[string]string) {
+	t.aliases = aliases
+}
+
+// AddVariant adds a language variant for the table with the given name.
+// The variant is used instead of the default table when the locale matches.
+func (t *TablePack) AddVariant(name string, lang string, table *Table) {
+	if _, ok := t.variants[name]; !ok {
+		t.variants[name] = make(map[string]*Table)
 	}
-	return NewTablePack(t.key, t.name, tables)
+	table.packKey = t.key
+	t.variants[name][strings.ToLower(lang)] = table
+}
+
+// Table finds the named table like a table call in the locale would.
+func (t *TablePack) Table(name string, locale string) (*Table, bool) {
+	return t.table(name, locale)
+}
+
+// table finds the named table, preferring the variant for the locale.
+// A locale like `de-AT` will fall back to `de` and then the default table.
+func (t *TablePack) table(name string, locale string) (*Table, bool) {
+	if langs, ok := t.variants[name]; ok && len(locale) > 0 {
+		locale = strings.ToLower(locale)
+		if v, ok := langs[locale]; ok {
+			return v, true
+		}
+		if v, ok := langs[baseLanguage(locale)]; ok {
+			return v, true
+		}
+	}
+	v, ok := t.tables[name]
+	return v, ok
+}
+
+// baseLanguage strips the region from a locale, `en-GB` becomes `en`.
+func baseLanguage(locale string) string {
+	if i := strings.IndexAny(locale, "-_"); i >= 0 {
+		return locale[:i]
+	}
+	return locale
 }
 
 // ResultType is an alias for allowed return types from an expression.
@@ -273,6 +451,15 @@ type ExecutionContext struct {
 	values map[string]*ExpressionResult
 	packs  TableMap
 	rand   RandomSource
+	locale string
+	picks  map[*Table]map[int]bool
+	recent *RecentPicks
+	// pack is the key of the pack of the table being evaluated, table names
+	// given to functions are looked up from it.
+	pack string
+	// rowTag limits rolls on rowTagTable to rows with the tag when set.
+	rowTag      string
+	rowTagTable *Table
 }
 
 // NewRootExecutionContext creates an empty ExecutionContext that is ready
@@ -300,6 +487,23 @@ func (ctx *ExecutionContext) SetHistory(h *RollHistory) *ExecutionContext {
 	return ctx
 }
 
+// SetRecentPicks assigns the memory of recent rolls for the avoid-repeat tag.
+func (ctx *ExecutionContext) SetRecentPicks(r *RecentPicks) *ExecutionContext {
+	ctx.recent = r
+	return ctx
+}
+
+// SetLocale sets the locale used for table variants and grammar rules.
+func (ctx *ExecutionContext) SetLocale(locale string) *ExecutionContext {
+	ctx.locale = locale
+	return ctx
+}
+
+// Locale returns the locale for this context, empty if none was set.
+func (ctx *ExecutionContext) Locale() string {
+	return ctx.locale
+}
+
 // Rand is a convenience method to get a random number from the context.
 func (ctx *ExecutionContext) Rand(low int, high int) int {
 	return ctx.rand.Get(low, high)
@@ -318,7 +522,25 @@ func (ctx *ExecutionContext) Child() *ExecutionContext {
 		values:      make(map[string]*ExpressionResult),
 		packs:       ctx.packs,
 		rand:        ctx.rand,
+		locale:      ctx.locale,
+		picks:       ctx.picks,
+		recent:      ctx.recent,
+		pack:        ctx.pack,
+		rowTag:      ctx.rowTag,
+		rowTagTable: ctx.rowTagTable,
+	}
+}
+
+// picked returns the set of row indexes picked by unique rolls on the table
+// during the current evaluation.
+func (ctx *ExecutionContext) picked(t *Table) map[int]bool {
+	if ctx.picks == nil {
+		ctx.picks = make(map[*Table]map[int]bool)
+	}
+	if _, ok := ctx.picks[t]; !ok {
+		ctx.picks[t] = make(map[int]bool)
 	}
+	return ctx.picks[t]
 }
 
 // SetPacks assigns the table packs for this context.
@@ -347,37 +569,64 @@ func (ctx *ExecutionContext) Resolve(key string) (*ExpressionResult, error) {
 //
 // This method contains the main evaluation loop that uses a slice for a program
 // stack to prevent failing from deep call stacks.
+//
+// Runtime errors unwind the stack until an ExpressionEval that can handle errors
+// (like `try`) accepts it, otherwise the error is returned.
 func EvaluateExpression(e Evallable, ctx *ExecutionContext) (*ExpressionResult, error) {
 	if ctx == nil {
 		ctx = NewRootExecutionContext()
 	}
+	root := ctx.Child()
+	// Unique rolls are scoped to the outermost evaluation.
+	if root.picks == nil {
+		root.picks = make(map[*Table]map[int]bool)
+	}
 	stack := make([]ExpressionEval, 0)
-	stack = append(stack, e.Eval().SetContext(ctx.Child()))
+	stack = append(stack, e.Eval().SetContext(root))
 	for len(stack) > 0 {
 		// See if we need to push another resolution node on the current stack.
 		cur := stack[len(stack)-1]
 		if cur.HasNext() {
 			next, err := cur.Next()
 			if err != nil {
-				return nil, err
+				if stack, err = unwind(stack, err); err != nil {
+					return nil, err
+				}
+				continue
 			}
 			stack = append(stack, next)
 			continue
 		}
 		result, err := cur.Resolve()
 		if err != nil {
-			return nil, err
+			if stack, err = unwind(stack, err); err != nil {
+				return nil, err
+			}
+			continue
 		}
 		if len(stack) == 1 {
 			return result, nil
 		}
 		stack = stack[:len(stack)-1]
 		if err = stack[len(stack)-1].Provide(result); err != nil {
-			return nil, err
+			if stack, err = unwind(stack, err); err != nil {
+				return nil, err
+			}
 		}
 	}
 	return nil, fmt.Errorf("this shouldn't happen, you have entered the matrix, have a fresh cookie: 0")
 }
 
+// unwind drops the failed ExpressionEval at the top of the stack and any others
+// above the closest errorHandler that handles the error.
+func unwind(stack []ExpressionEval, err error) ([]ExpressionEval, error) {
+	for i := len(stack) - 2; i >= 0; i-- {
+		if h, ok := stack[i].(errorHandler); ok && h.HandleError(err) {
+			return stack[:i+1], nil
+		}
+	}
+	return nil, err
+}
+
 // TableMap is a type alias for mapping file hash keys to table definitions.
 type TableMap map[string]*TablePack
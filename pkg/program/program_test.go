@@ -57,40 +57,40 @@ func TestCloneProgram(t *testing.T) {
 
 	// Deck draw state. -----------------------------
 	assert.Equal(1, prog.PackCount())
-	assert.Equal(5, prog.packs[RootPack].tables["test"].currentCount)
-	assert.Equal(5, prog.packs[RootPack].tables["test"].rows[0].currentCount)
+	assert.Equal(5, prog.packs[RootPack].tables["test"].DeckRemaining(DefaultDeck))
+	assert.Equal(5, prog.packs[RootPack].tables["test"].deck(DefaultDeck).counts[0])
 	expr, err := NewTableCall(RootPack, "", "test", []Evallable{NewString("deck", true)})
 	assert.NoError(err)
 	// Make an initial call into the table to reduce the number of pulls below max.
 	res, err := prog.Eval(expr)
 	assert.NoError(err)
 	assert.Equal("abc", res.StringVal())
-	assert.Equal(4, prog.packs[RootPack].tables["test"].currentCount)
-	assert.Equal(4, prog.packs[RootPack].tables["test"].rows[0].currentCount)
+	assert.Equal(4, prog.packs[RootPack].tables["test"].DeckRemaining(DefaultDeck))
+	assert.Equal(4, prog.packs[RootPack].tables["test"].deck(DefaultDeck).counts[0])
 
 	// copy and verify new table freshness.
 	p2 := prog.Copy()
 	assert.Equal(1, p2.PackCount())
-	assert.Equal(5, p2.packs[RootPack].tables["test"].currentCount)
-	assert.Equal(5, p2.packs[RootPack].tables["test"].rows[0].currentCount)
+	assert.Equal(5, p2.packs[RootPack].tables["test"].DeckRemaining(DefaultDeck))
+	assert.Equal(5, p2.packs[RootPack].tables["test"].deck(DefaultDeck).counts[0])
 
 	// Pull from the old table to make sure no forward references.
 	res, err = prog.Eval(expr)
 	assert.NoError(err)
 	assert.Equal("abc", res.StringVal())
-	assert.Equal(5, p2.packs[RootPack].tables["test"].currentCount)
-	assert.Equal(5, p2.packs[RootPack].tables["test"].rows[0].currentCount)
-	assert.Equal(3, prog.packs[RootPack].tables["test"].currentCount)
-	assert.Equal(3, prog.packs[RootPack].tables["test"].rows[0].currentCount)
+	assert.Equal(5, p2.packs[RootPack].tables["test"].DeckRemaining(DefaultDeck))
+	assert.Equal(5, p2.packs[RootPack].tables["test"].deck(DefaultDeck).counts[0])
+	assert.Equal(3, prog.packs[RootPack].tables["test"].DeckRemaining(DefaultDeck))
+	assert.Equal(3, prog.packs[RootPack].tables["test"].deck(DefaultDeck).counts[0])
 
 	// Pull from the new table and verify no back references.
 	res, err = p2.Eval(expr)
 	assert.NoError(err)
 	assert.Equal("abc", res.StringVal())
-	assert.Equal(4, p2.packs[RootPack].tables["test"].currentCount)
-	assert.Equal(4, p2.packs[RootPack].tables["test"].rows[0].currentCount)
-	assert.Equal(3, prog.packs[RootPack].tables["test"].currentCount)
-	assert.Equal(3, prog.packs[RootPack].tables["test"].rows[0].currentCount)
+	assert.Equal(4, p2.packs[RootPack].tables["test"].DeckRemaining(DefaultDeck))
+	assert.Equal(4, p2.packs[RootPack].tables["test"].deck(DefaultDeck).counts[0])
+	assert.Equal(3, prog.packs[RootPack].tables["test"].DeckRemaining(DefaultDeck))
+	assert.Equal(3, prog.packs[RootPack].tables["test"].deck(DefaultDeck).counts[0])
 
 	// Variable state ----------------------
 	prog.ctx.Set("foo", NewStringResult("bar"))
@@ -128,3 +128,91 @@ func TestCloneProgram(t *testing.T) {
 	_, err = prog.Eval(expr2)
 	assert.Error(err)
 }
+
+func TestSnapshotRestore(t *testing.T) {
+	assert := assert.New(t)
+
+	rows := []*TableRow{
+		NewTableRow("a", make([]*Range, 0), 1, 3, false, NewString("ace", true)),
+		NewTableRow("k", make([]*Range, 0), 1, 2, false, NewString("king", true)),
+	}
+	pack := NewTablePack("foo", "test-pack", map[string]*Table{"test": NewTable("test", make(map[string]string), rows)})
+	prog := NewProgram(TableMap{RootPack: pack, "foo": pack})
+	prog.SetLocale("de")
+	prog.SetRandom(NewSeededRandSource(42))
+
+	draw, err := NewTableCall(RootPack, "", "test", []Evallable{NewString("deck", true)})
+	assert.NoError(err)
+	named, err := NewTableCall(RootPack, "", "test", []Evallable{NewString("deck:bob", true)})
+	assert.NoError(err)
+	roll := NewRoll(1, 1000)
+	_, err = prog.Eval(draw)
+	assert.NoError(err)
+	_, err = prog.Eval(named)
+	assert.NoError(err)
+	_, err = prog.Eval(roll)
+	assert.NoError(err)
+
+	data, err := prog.Snapshot()
+	assert.NoError(err)
+
+	// The same sequence continues from the snapshot in a fresh copy.
+	expected := make([]string, 0)
+	for i := 0; i < 4; i++ {
+		res, err := prog.Eval(draw)
+		assert.NoError(err)
+		expected = append(expected, res.StringVal())
+		res, err = prog.Eval(roll)
+		assert.NoError(err)
+		expected = append(expected, resultString(res))
+	}
+	p2 := prog.Copy()
+	assert.NoError(p2.Restore(data))
+	assert.Equal("de", p2.Locale())
+	table := p2.packs[RootPack].tables["test"]
+	assert.Equal(4, table.DeckRemaining(DefaultDeck))
+	assert.Equal(4, table.DeckRemaining("bob"))
+	assert.Len(p2.ctx.GetRollHistory(), 1)
+	for i := 0; i < 4; i++ {
+		res, err := p2.Eval(draw)
+		assert.NoError(err)
+		assert.Equal(expected[2*i], res.StringVal())
+		res, err = p2.Eval(roll)
+		assert.NoError(err)
+		assert.Equal(expected[2*i+1], resultString(res))
+	}
+
+	// Snapshots only restore into matching programs.
+	other := NewTablePack("bar", "other-pack", map[string]*Table{"test": NewTable("test", make(map[string]string), rows[:1])})
+	assert.Error(NewProgram(TableMap{RootPack: other}).Restore(data))
+	assert.Error(p2.Restore([]byte(`{"version": 99}`)))
+	assert.Error(p2.Restore([]byte(`{"version": 1, "packs": {"foo": {"test": {"decks": {"": {"counts": [4, 0], "discards": [0, 0], "top": -1}}}}}}`)))
+	assert.NoError(p2.CheckSnapshot(data))
+	assert.Error(p2.CheckSnapshot([]byte(`{"version": 99}`)))
+
+	// Programs without a seeded source don't save a random state.
+	data, err = NewProgram(TableMap{RootPack: pack}).Snapshot()
+	assert.NoError(err)
+	assert.NotContains(string(data), `"random"`)
+}
+
+func TestSeededRandSource(t *testing.T) {
+	assert := assert.New(t)
+
+	r := NewSeededRandSource(7)
+	counts := make([]int, 3)
+	for i := 0; i < 3000; i++ {
+		v := r.Get(2, 5)
+		assert.True(v >= 2 && v < 5)
+		counts[v-2]++
+	}
+	for _, c := range counts {
+		assert.InDelta(1000, c, 150)
+	}
+
+	// The same state gives the same sequence.
+	state := r.State()
+	first := []int{r.Get(0, 100), r.Get(0, 100), r.Get(0, 100)}
+	r.SetState(state)
+	assert.Equal(first, []int{r.Get(0, 100), r.Get(0, 100), r.Get(0, 100)})
+}
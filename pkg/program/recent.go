@@ -0,0 +1,116 @@
+package program
+
+import (
+	"strconv"
+	"sync"
+)
+
+const (
+	// AvoidRepeatTag is the table tag that stops Roll and WeightedRoll from
+	// returning any of the last N rows rolled on the table, e.g. `~ avoid-repeat: 5`.
+	AvoidRepeatTag = "avoid-repeat"
+)
+
+// RecentPicks remembers the last rows rolled on tables with the avoid-repeat tag.
+// Tables are identified by pack key and name so the memory survives program copies.
+//
+// Thread safe.
+type RecentPicks struct {
+	picks    map[string][]int
+	accessMu sync.Mutex
+}
+
+// NewRecentPicks creates a new empty RecentPicks object.
+func NewRecentPicks() *RecentPicks {
+	return &RecentPicks{
+		picks: make(map[string][]int),
+	}
+}
+
+// recent returns a copy of the recently rolled row indexes for a table.
+func (r *RecentPicks) recent(id string) []int {
+	r.accessMu.Lock()
+	defer r.accessMu.Unlock()
+	return append([]int{}, r.picks[id]...)
+}
+
+// add records a rolled row, keeping only the last `limit` rows for the table.
+func (r *RecentPicks) add(id string, row int, limit int) {
+	r.accessMu.Lock()
+	defer r.accessMu.Unlock()
+	picks := append(r.picks[id], row)
+	if len(picks) > limit {
+		picks = picks[len(picks)-limit:]
+	}
+	r.picks[id] = picks
+}
+
+// all returns a copy of every table's recent picks.
+func (r *RecentPicks) all() map[string][]int {
+	r.accessMu.Lock()
+	defer r.accessMu.Unlock()
+	result := make(map[string][]int)
+	for k, v := range r.picks {
+		result[k] = append([]int{}, v...)
+	}
+	return result
+}
+
+// set replaces every table's recent picks.
+func (r *RecentPicks) set(picks map[string][]int) {
+	r.accessMu.Lock()
+	defer r.accessMu.Unlock()
+	r.picks = make(map[string][]int)
+	for k, v := range picks {
+		r.picks[k] = append([]int{}, v...)
+	}
+}
+
+// avoidRepeat returns how many recent rows to avoid, 0 if the tag isn't set.
+func (t *Table) avoidRepeat() int {
+	n, err := strconv.Atoi(t.tags[AvoidRepeatTag])
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
+}
+
+// recentID identifies the table for RecentPicks.
+func (t *Table) recentID() string {
+	id := t.packKey + "." + t.name
+	if lang, ok := t.tags[LangTag]; ok {
+		id += ":" + lang
+	}
+	return id
+}
+
+// candidates returns the rows that can be rolled, leaving out recent rolls if
+// the table has the avoid-repeat tag. All tagged rows are returned if every row
+// is recent.
+func (t *Table) candidates(ctx *ExecutionContext) []int {
+	rows := t.tagged(ctx)
+	result := make([]int, 0, len(rows))
+	recent := make(map[int]bool)
+	if t.avoidRepeat() > 0 && ctx != nil && ctx.recent != nil {
+		for _, i := range ctx.recent.recent(t.recentID()) {
+			recent[i] = true
+		}
+	}
+	for _, i := range rows {
+		if !recent[i] {
+			result = append(result, i)
+		}
+	}
+	if len(result) == 0 {
+		return rows
+	}
+	return result
+}
+
+// rolled records a rolled row for the avoid-repeat tag and returns its value.
+func (t *Table) rolled(ctx *ExecutionContext, i int, mode CallMode) Evallable {
+	if n := t.avoidRepeat(); n > 0 && ctx.recent != nil {
+		ctx.recent.add(t.recentID(), i, n)
+	}
+	return t.rows[i].pick(mode)
+}
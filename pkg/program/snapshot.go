@@ -0,0 +1,232 @@
+package program
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+const (
+	// SnapshotVersion is the version of the snapshot format written by Snapshot.
+	SnapshotVersion = 1
+)
+
+// Snapshot is the mutable state of a Program: deck state, random state,
+// roll history and locale. The random state is only kept for programs using a
+// SeededRandSource. Tables are keyed by pack key so state can only be
+// restored into a program compiled from the same sources.
+type Snapshot struct {
+	Version int                               `json:"version"`
+	Packs   map[string]map[string]*TableState `json:"packs"`
+	Random  string                            `json:"random,omitempty"`
+	History []string                          `json:"history"`
+	Locale  string                            `json:"locale,omitempty"`
+	Recent  map[string][]int                  `json:"recent,omitempty"`
+}
+
+// TableState is the state of all decks of one table, keyed by deck name.
+type TableState struct {
+	Decks map[string]*DeckState `json:"decks"`
+}
+
+// DeckState is the state of a single deck. Counts and discards are per row.
+type DeckState struct {
+	Counts   []int `json:"counts"`
+	Discards []int `json:"discards"`
+	Top      int   `json:"top"`
+}
+
+// Snapshot serializes all mutable state of the program to JSON.
+func (p *Program) Snapshot() ([]byte, error) {
+	snap := &Snapshot{
+		Version: SnapshotVersion,
+		Packs:   make(map[string]map[string]*TableState),
+		History: p.ctx.GetRollHistory(),
+		Locale:  p.ctx.locale,
+	}
+	for _, pack := range p.packs {
+		tables := make(map[string]*TableState)
+		pack.eachTable(func(id string, t *Table) {
+			tables[id] = t.state()
+		})
+		snap.Packs[pack.key] = tables
+	}
+	if p.ctx.recent != nil {
+		snap.Recent = p.ctx.recent.all()
+	}
+	if r, ok := p.ctx.rand.(*SeededRandSource); ok {
+		snap.Random = strconv.FormatUint(r.State(), 16)
+	}
+	return json.Marshal(snap)
+}
+
+// Restore replaces the mutable state of the program with a JSON snapshot
+// from Snapshot. Nothing is changed if the snapshot doesn't match the program.
+func (p *Program) Restore(data []byte) error {
+	apply, err := p.readSnapshot(data)
+	if err != nil {
+		return err
+	}
+	apply()
+	return nil
+}
+
+// CheckSnapshot returns the error Restore would return for a snapshot without
+// changing the program, so state for several programs can be checked before
+// any of it is restored.
+func (p *Program) CheckSnapshot(data []byte) error {
+	_, err := p.readSnapshot(data)
+	return err
+}
+
+// readSnapshot checks a snapshot matches the program and returns a function
+// that restores it.
+func (p *Program) readSnapshot(data []byte) (func(), error) {
+	snap := &Snapshot{}
+	if err := json.Unmarshal(data, snap); err != nil {
+		return nil, fmt.Errorf("could not read snapshot: %w", err)
+	}
+	if snap.Version != SnapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d, expected %d", snap.Version, SnapshotVersion)
+	}
+	packs := make(map[string]*TablePack)
+	for _, pack := range p.packs {
+		packs[pack.key] = pack
+	}
+	restore := make([]func(), 0)
+	for key, tables := range snap.Packs {
+		pack, ok := packs[key]
+		if !ok {
+			return nil, fmt.Errorf("snapshot has state for a table pack that isn't loaded (%s)", key)
+		}
+		for id, state := range tables {
+			t, ok := pack.tableByID(id)
+			if !ok {
+				return nil, fmt.Errorf("snapshot has state for table '%s' missing from pack '%s'", id, pack.name)
+			}
+			if err := t.checkState(state); err != nil {
+				return nil, err
+			}
+			t, state := t, state
+			restore = append(restore, func() { t.setState(state) })
+		}
+	}
+	var random uint64
+	if len(snap.Random) > 0 {
+		var err error
+		if random, err = strconv.ParseUint(snap.Random, 16, 64); err != nil {
+			return nil, fmt.Errorf("could not read snapshot random state: %w", err)
+		}
+	}
+
+	return func() {
+		for _, fn := range restore {
+			fn()
+		}
+		if len(snap.Random) > 0 {
+			r, ok := p.ctx.rand.(*SeededRandSource)
+			if !ok {
+				r = NewSeededRandSource(0)
+				p.ctx.rand = r
+			}
+			r.SetState(random)
+		}
+		p.ctx.setRolls(snap.History)
+		if p.ctx.recent != nil {
+			p.ctx.recent.set(snap.Recent)
+		}
+		p.ctx.locale = snap.Locale
+	}, nil
+}
+
+// eachTable calls fn for every table in the pack including language variants,
+// with an ID of the table name, or `<name>:<lang>` for variants.
+func (t *TablePack) eachTable(fn func(id string, t *Table)) {
+	for name, table := range t.tables {
+		fn(name, table)
+	}
+	for name, langs := range t.variants {
+		for lang, table := range langs {
+			fn(name+":"+lang, table)
+		}
+	}
+}
+
+// tableByID finds a table by an ID given to eachTable.
+func (t *TablePack) tableByID(id string) (*Table, bool) {
+	var result *Table
+	t.eachTable(func(tableID string, table *Table) {
+		if tableID == id {
+			result = table
+		}
+	})
+	return result, result != nil
+}
+
+func (t *Table) state() *TableState {
+	t.deckMu.Lock()
+	defer t.deckMu.Unlock()
+	result := &TableState{
+		Decks: make(map[string]*DeckState),
+	}
+	for name, d := range t.decks {
+		result.Decks[name] = &DeckState{
+			Counts:   append([]int{}, d.counts...),
+			Discards: append([]int{}, d.discards...),
+			Top:      d.top,
+		}
+	}
+	return result
+}
+
+func (t *Table) checkState(state *TableState) error {
+	names := make([]string, 0, len(state.Decks))
+	for name := range state.Decks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		d := state.Decks[name]
+		if len(d.Counts) != len(t.rows) || len(d.Discards) != len(t.rows) {
+			return fmt.Errorf("snapshot of %s has %d rows, expected %d", t.deckName(name), len(d.Counts), len(t.rows))
+		}
+		for i, r := range t.rows {
+			if d.Counts[i] < 0 || d.Discards[i] < 0 || d.Counts[i]+d.Discards[i] > r.count {
+				return fmt.Errorf("snapshot of %s has bad counts for row %d", t.deckName(name), i+1)
+			}
+		}
+		if d.Top >= len(t.rows) || (d.Top >= 0 && d.Counts[d.Top] == 0) {
+			return fmt.Errorf("snapshot of %s has a bad top card", t.deckName(name))
+		}
+	}
+	return nil
+}
+
+func (t *Table) setState(state *TableState) {
+	t.deckMu.Lock()
+	defer t.deckMu.Unlock()
+	t.decks = make(map[string]*deck)
+	for name, s := range state.Decks {
+		d := &deck{
+			counts:   append([]int{}, s.Counts...),
+			discards: append([]int{}, s.Discards...),
+			top:      s.Top,
+		}
+		for i := range d.counts {
+			d.remaining += d.counts[i]
+			d.discarded += d.discards[i]
+		}
+		if d.top < 0 {
+			d.top = -1
+		}
+		t.decks[name] = d
+	}
+}
+
+// setRolls replaces the roll history contents.
+func (h *RollHistory) setRolls(rolls []string) {
+	h.accessMu.Lock()
+	defer h.accessMu.Unlock()
+	h.rollResults = append(make([]string, 0, len(rolls)), rolls...)
+}
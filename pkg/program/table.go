@@ -4,23 +4,26 @@ import (
 	"fmt"
 	"strconv"
 	"sync"
-
-	"github.com/k0kubun/pp"
 )
 
 // Table is a program unit that can randomly and deterministically return
 // row Evallable objects. The core of tableman.
 type Table struct {
-	name         string
-	tags         map[string]string
-	rows         []*TableRow
-	rowsByLabel  map[string]*TableRow
-	rowsByRange  []*Range
-	totalWeight  int
-	totalCount   int
-	currentCount int
-	defaultRow   int
-	deckMu       sync.Mutex
+	name        string
+	tags        map[string]string
+	rows        []*TableRow
+	rowsByLabel map[string]*TableRow
+	rowsByRange []*Range
+	totalWeight int
+	totalCount  int
+	defaultRow  int
+	decks       map[string]*deck
+	deckMu      sync.Mutex
+	packKey     string
+	// dynamic is whether any row has a condition or weight expression.
+	dynamic bool
+	dice    *Roll
+	markov  *markov
 }
 
 // Copy deep copies a Table
@@ -29,32 +32,73 @@ func (t *Table) Copy() *Table {
 	for _, r := range t.rows {
 		newRows = append(newRows, r.Copy())
 	}
-	return NewTable(t.name, t.tags, newRows)
+	result := NewTable(t.name, t.tags, newRows).SetDice(t.dice)
+	result.markov = t.markov
+	return result
+}
+
+// NewInlineTable creates an anonymous table for an inline choice like
+// `[ "a" | w=3 "b" ]`, the returned Evallable rolls it by weight.
+func NewInlineTable(rows []*TableRow) Evallable {
+	table := NewTable(InlineTableName, make(map[string]string), rows)
+	return &inlineTable{table: table, roll: table.WeightedRoll()}
+}
+
+// inlineTable is a weighted roll on an inline table, the table is kept so
+// compiled programs can be encoded.
+type inlineTable struct {
+	table *Table
+	roll  Evallable
+}
+
+// Eval implementation for Evallable interface.
+func (i *inlineTable) Eval() ExpressionEval {
+	return i.roll.Eval()
+}
+
+// Rows returns copies of the table rows in order.
+func (t *Table) Rows() []*TableRow {
+	result := make([]*TableRow, 0, len(t.rows))
+	for _, r := range t.rows {
+		result = append(result, r.Copy())
+	}
+	return result
+}
+
+// Tags returns a copy of the table tags.
+func (t *Table) Tags() map[string]string {
+	result := make(map[string]string, len(t.tags))
+	for k, v := range t.tags {
+		result[k] = v
+	}
+	return result
 }
 
 // NewTable creates a new table object.
 func NewTable(name string, tags map[string]string, rows []*TableRow) *Table {
 	result := &Table{
-		name:         name,
-		tags:         tags,
-		rows:         rows,
-		rowsByLabel:  make(map[string]*TableRow),
-		rowsByRange:  make([]*Range, 0),
-		totalWeight:  0,
-		totalCount:   0,
-		currentCount: 0,
-		defaultRow:   -1,
+		name:        name,
+		tags:        tags,
+		rows:        rows,
+		rowsByLabel: make(map[string]*TableRow),
+		rowsByRange: make([]*Range, 0),
+		totalWeight: 0,
+		totalCount:  0,
+		defaultRow:  -1,
+		decks:       make(map[string]*deck),
 	}
 	for i, r := range result.rows {
 		if len(r.label) > 0 {
 			result.rowsByLabel[r.Label()] = r
 		}
 		result.totalCount += r.Count()
-		result.currentCount += r.Count()
 		result.totalWeight += r.Weight()
 		if r.isDefault {
 			result.defaultRow = i
 		}
+		if r.when != nil || r.weightExpr != nil {
+			result.dynamic = true
+		}
 		for _, rng := range r.Ranges() {
 			rng.setRow(r)
 			result.rowsByRange = append(result.rowsByRange, rng)
@@ -64,35 +108,94 @@ func NewTable(name string, tags map[string]string, rows []*TableRow) *Table {
 }
 
 // Roll randomly on the table treating each row with equal weight.
+// Tables with the avoid-repeat tag skip rows rolled recently and rows
+// with a failing `when` condition are skipped. Markov tables generate a name.
 func (t *Table) Roll() Evallable {
+	if t.markov != nil {
+		return t.markovRoll()
+	}
+	if t.dynamic {
+		return &dynamicRoll{table: t}
+	}
 	return &rowFuture{
 		fn: func(ctx *ExecutionContext) Evallable {
-			index := ctx.Rand(0, len(t.rows))
-			return t.rows[index].Value()
+			rows := t.candidates(ctx)
+			if len(rows) == 0 {
+				return &failedValue{err: t.noTaggedRows(ctx)}
+			}
+			return t.rolled(ctx, rows[ctx.Rand(0, len(rows))], RollMode)
 		},
 	}
 }
 
 // WeightedRoll randomly rolls on the table using the defined row weights to
 // decide which rows to return. Rows without set weights are treated as w=1.
+// Tables with the avoid-repeat tag skip rows rolled recently, weight
+// expressions and `when` conditions are evaluated before the roll.
+// Markov tables generate a name.
 func (t *Table) WeightedRoll() Evallable {
+	if t.markov != nil {
+		return t.markovRoll()
+	}
+	if t.dynamic {
+		return &dynamicRoll{table: t, weighted: true}
+	}
 	return &rowFuture{
 		fn: func(ctx *ExecutionContext) Evallable {
-			roll := ctx.Rand(0, t.totalWeight)
-			i := 0
-			for {
-				cur := t.rows[i].Weight
-				pp.Println(roll, cur)
+			rows := t.candidates(ctx)
+			if len(rows) == 0 {
+				return &failedValue{err: t.noTaggedRows(ctx)}
+			}
+			total := 0
+			for _, i := range rows {
+				total += t.rows[i].Weight()
+			}
+			if total == 0 {
+				rows = t.tagged(ctx)
+				for _, i := range rows {
+					total += t.rows[i].Weight()
+				}
+			}
+			roll := ctx.Rand(0, total)
+			for _, i := range rows {
 				if t.rows[i].Weight() > roll {
-					return t.rows[i].Value()
+					return t.rolled(ctx, i, WeightedMode)
 				}
 				roll -= t.rows[i].Weight()
-				i++
 			}
+			return t.rolled(ctx, rows[len(rows)-1], WeightedMode)
 		},
 	}
 }
 
+// UniqueRoll randomly picks count rows with equal weight, skipping rows already
+// picked by unique rolls on this table during the current evaluation.
+// An error is returned if there aren't enough rows left.
+func (t *Table) UniqueRoll(ctx *ExecutionContext, count int) ([]Evallable, error) {
+	if count < 1 {
+		return nil, fmt.Errorf("must pick at least 1 unique row from table '%s', asked for %d", t.name, count)
+	}
+	picked := ctx.picked(t)
+	available := make([]int, 0, len(t.rows))
+	for i := range t.rows {
+		if !picked[i] {
+			available = append(available, i)
+		}
+	}
+	if len(available) < count {
+		return nil, fmt.Errorf("table '%s' has %d unique rows left, asked for %d", t.name, len(available), count)
+	}
+	result := make([]Evallable, 0, count)
+	for len(result) < count {
+		j := ctx.Rand(0, len(available))
+		i := available[j]
+		available = append(available[:j], available[j+1:]...)
+		picked[i] = true
+		result = append(result, t.rows[i].pick(UniqueMode))
+	}
+	return result, nil
+}
+
 // LabelRoll fetches a row directly from the table using the passed label.
 // If no label was defined on the table, the default row will be returned.
 // If no default row was specified, an error will be returned.
@@ -100,63 +203,67 @@ func (t *Table) LabelRoll(key string) (Evallable, error) {
 	r, ok := t.rowsByLabel[key]
 	if !ok {
 		if t.defaultRow >= 0 {
-			return t.rows[t.defaultRow].Value(), nil
+			return t.rows[t.defaultRow].pick(LabelMode), nil
 		}
 		return nil, fmt.Errorf("in table '%s' no row labelled '%s' and no default row", t.name, key)
 	}
-	return r.Value(), nil
+	return r.pick(LabelMode), nil
 }
 
 // DeckDraw will treat the table as a deck of cards using the count value (default 1) to
-// choose a row from the deck.
+// choose a row from the default deck.
 //
-// This is the only stateful draw from the table, once all counts have been exhausted
-// an error will be returned if DeckDraw is called. Reset the counts by calling `Shuffle()`.
+// This is a stateful draw from the table, once all counts have been exhausted
+// an error will be returned if DeckDraw is called. Reset the counts by calling `Shuffle()`
+// or set the `auto-reshuffle` tag on the table. See DeckDrawN for named decks.
 func (t *Table) DeckDraw() (Evallable, error) {
 	t.deckMu.Lock()
 	defer t.deckMu.Unlock()
-	if t.currentCount == 0 {
-		return nil, fmt.Errorf("deck draw called too many times without shuffle on table '%s'", t.name)
+	if err := t.fill(t.deck(DefaultDeck), DefaultDeck); err != nil {
+		return nil, err
 	}
 
 	return &rowFuture{
 		fn: func(ctx *ExecutionContext) Evallable {
-			roll := ctx.Rand(0, t.currentCount)
-			for _, r := range t.rows {
-				if r.currentCount == 0 {
-					continue
-				}
-				if roll < r.currentCount {
-					r.currentCount--
-					t.currentCount--
-					return r.Value()
-				}
-				roll -= r.currentCount
+			rows, err := t.DeckDrawN(ctx, DefaultDeck, 1)
+			if err != nil {
+				return &failedValue{err: err}
 			}
-			return nil
+			return rows[0]
 		},
 	}, nil
 }
 
-// Shuffle resets all counts for DeckDraw calls.
+// Shuffle resets all counts of the default deck for DeckDraw calls.
 func (t *Table) Shuffle() {
-	for _, r := range t.rows {
-		r.currentCount = r.count
-	}
-	t.currentCount = t.totalCount
+	t.DeckShuffle(DefaultDeck)
 }
 
 // IndexRoll returns the row defined for the given index.
 // If no index machtes, the default row will be used.
 // If no default was defined, an error will be returned.
 func (t *Table) IndexRoll(key int) (Evallable, error) {
+	return t.indexPick(key, IndexMode)
+}
+
+// indexPick picks the row for an index, counted under the given mode.
+func (t *Table) indexPick(key int, mode CallMode) (Evallable, error) {
+	row, err := t.indexRow(key)
+	if err != nil {
+		return nil, err
+	}
+	return row.pick(mode), nil
+}
+
+// indexRow finds the row for an index like IndexRoll without picking it.
+func (t *Table) indexRow(key int) (*TableRow, error) {
 	for _, rng := range t.rowsByRange {
 		if rng.inRange(key) {
-			return rng.getRow().Value(), nil
+			return rng.getRow(), nil
 		}
 	}
 	if t.defaultRow >= 0 {
-		return t.rows[t.defaultRow].Value(), nil
+		return t.rows[t.defaultRow], nil
 	}
 	return nil, fmt.Errorf("in table '%s' no index %d and no default row set", t.name, key)
 }
@@ -224,40 +331,147 @@ func (r *rowFuture) Resolve() (*ExpressionResult, error) {
 	return nil, fmt.Errorf("can't resolve table row future")
 }
 
+// failedValue is an Evallable that fails with an error when evaluated.
+type failedValue struct {
+	err error
+}
+
+func (f *failedValue) Eval() ExpressionEval {
+	return f
+}
+
+func (f *failedValue) SetContext(ctx *ExecutionContext) ExpressionEval {
+	return f
+}
+
+func (f *failedValue) HasNext() bool {
+	return false
+}
+
+func (f *failedValue) Next() (ExpressionEval, error) {
+	return nil, f.err
+}
+
+func (f *failedValue) Provide(res *ExpressionResult) error {
+	return f.err
+}
+
+func (f *failedValue) Resolve() (*ExpressionResult, error) {
+	return nil, f.err
+}
+
 // TableRow is an Evallable row for a tableman table.
 type TableRow struct {
-	label        string
-	rangeVal     []*Range
-	weight       int
-	count        int
-	currentCount int
-	isDefault    bool
-	value        Evallable
+	label     string
+	rangeVal  []*Range
+	weight    int
+	count     int
+	isDefault bool
+	value     Evallable
+	// Evaluated at roll time by Roll and WeightedRoll when set.
+	weightExpr Evallable
+	when       Evallable
+	tags       []string
+	source     *RowSource
+	// hits counts picks for coverage, nil when coverage is off.
+	hits *rowHits
+}
+
+// RowSource is the source text of a row, kept to describe tables in exports.
+type RowSource struct {
+	Value  string
+	Weight string
+	When   string
 }
 
 // NewTableRow creates a new TableRow object.
 func NewTableRow(label string, rangeVal []*Range, weight int, count int, isDefault bool, value Evallable) *TableRow {
 	return &TableRow{
-		label:        label,
-		rangeVal:     rangeVal,
-		weight:       weight,
-		count:        count,
-		currentCount: count,
-		isDefault:    isDefault,
-		value:        value,
+		label:     label,
+		rangeVal:  rangeVal,
+		weight:    weight,
+		count:     count,
+		isDefault: isDefault,
+		value:     value,
 	}
 }
 
 // Copy deep copies a TableRow
 func (r *TableRow) Copy() *TableRow {
+	ranges := make([]*Range, 0, len(r.rangeVal))
+	for _, rng := range r.rangeVal {
+		ranges = append(ranges, NewRange(rng.low, rng.high))
+	}
 	return NewTableRow(
 		r.label,
-		r.rangeVal,
+		ranges,
 		r.weight,
 		r.count,
 		r.isDefault,
 		r.value,
-	)
+	).SetWeightExpr(r.weightExpr).SetCondition(r.when).SetTags(r.tags).SetSource(r.source).shareHits(r)
+}
+
+// SetWeight sets a fixed row weight, replacing any weight expression.
+func (r *TableRow) SetWeight(weight int) *TableRow {
+	r.weight = weight
+	r.weightExpr = nil
+	return r
+}
+
+// SetWeightExpr sets an expression that gives the row weight at roll time.
+func (r *TableRow) SetWeightExpr(e Evallable) *TableRow {
+	r.weightExpr = e
+	return r
+}
+
+// SetCondition sets an expression that must give a non-zero integer at roll
+// time for the row to be rolled.
+func (r *TableRow) SetCondition(e Evallable) *TableRow {
+	r.when = e
+	return r
+}
+
+// SetTags sets the tags rolls can be filtered on with `tag=`.
+func (r *TableRow) SetTags(tags []string) *TableRow {
+	r.tags = tags
+	return r
+}
+
+// SetSource sets the source text of the row.
+func (r *TableRow) SetSource(source *RowSource) *TableRow {
+	r.source = source
+	return r
+}
+
+// Source returns the source text of the row, nil if it isn't known.
+func (r *TableRow) Source() *RowSource {
+	return r.source
+}
+
+// Tags returns the tags of the row.
+func (r *TableRow) Tags() []string {
+	return append([]string{}, r.tags...)
+}
+
+// Dynamic returns whether the row weight is an expression evaluated at roll time.
+func (r *TableRow) Dynamic() bool {
+	return r.weightExpr != nil
+}
+
+// Conditional returns whether the row has a `when` condition.
+func (r *TableRow) Conditional() bool {
+	return r.when != nil
+}
+
+// HasTag returns whether the row was tagged with the given tag.
+func (r *TableRow) HasTag(tag string) bool {
+	for _, t := range r.tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
 }
 
 // Default returns whether the row is a default value.
@@ -270,7 +484,7 @@ func (r *TableRow) Label() string {
 	return r.label
 }
 
-// Count returns the current DeckDraw count for the row.
+// Count returns the number of cards the row has in a deck.
 func (r *TableRow) Count() int {
 	return r.count
 }
@@ -371,6 +585,16 @@ func NewRange(low int, high int) *Range {
 	}
 }
 
+// Low returns the lowest index of the range.
+func (r *Range) Low() int {
+	return r.low
+}
+
+// High returns the highest index of the range.
+func (r *Range) High() int {
+	return r.high
+}
+
 func (r *Range) setRow(row *TableRow) {
 	r.row = row
 }
@@ -2,6 +2,12 @@ package program
 
 import (
 	"fmt"
+	"strings"
+)
+
+const (
+	deckPrefix = "deck:"
+	deckOps    = "shuffle/no-shuffle/draw/peek/remaining/discards/reshuffle/discard/return"
 )
 
 // TableCall is an Evallable for calls to a table.
@@ -10,6 +16,8 @@ type TableCall struct {
 	packageName string
 	tableName   string
 	params      []Evallable
+	// tag limits rolls to rows tagged with its value when set.
+	tag Evallable
 }
 
 // NewTableCall creates a new table call Evallable.
@@ -18,37 +26,60 @@ func NewTableCall(
 	packageName string,
 	tableName string,
 	params []Evallable,
+) (Evallable, error) {
+	return NewFilteredTableCall(packageKey, packageName, tableName, params, nil)
+}
+
+// NewFilteredTableCall creates a new table call Evallable that only rolls rows
+// tagged with the result of tag, `!t(roll, tag="x")`. A nil tag doesn't filter.
+func NewFilteredTableCall(
+	packageKey string,
+	packageName string,
+	tableName string,
+	params []Evallable,
+	tag Evallable,
 ) (Evallable, error) {
 	if len(packageKey) == 0 {
 		packageKey = RootPack
 	}
-	if len(params) > 2 {
-		return nil, fmt.Errorf("table call cannot have more than 2 parameters")
+	if len(params) > 3 {
+		return nil, fmt.Errorf("table call cannot have more than 3 parameters")
 	}
 	return &TableCall{
 		packageKey:  packageKey,
 		packageName: packageName,
 		tableName:   tableName,
 		params:      params,
+		tag:         tag,
 	}, nil
 }
 
 // Eval implementation for Evallable interface.
 func (c *TableCall) Eval() ExpressionEval {
+	evals := c.params
+	if c.tag != nil {
+		evals = append(append(make([]Evallable, 0, len(c.params)+1), c.params...), c.tag)
+	}
 	return &tableCallEval{
 		def:        c,
-		results:    make([]*ExpressionResult, 0, len(c.params)),
+		evals:      evals,
+		results:    make([]*ExpressionResult, 0, len(evals)),
 		index:      0,
 		paramCount: len(c.params),
+		evalCount:  len(evals),
 	}
 }
 
 type tableCallEval struct {
 	ctx         *ExecutionContext
 	def         *TableCall
+	evals       []Evallable
 	results     []*ExpressionResult
 	tableResult *ExpressionResult
+	table       *Table
+	rowTag      string
 	paramCount  int
+	evalCount   int
 	index       int
 }
 
@@ -58,14 +89,23 @@ func (t *tableCallEval) SetContext(ctx *ExecutionContext) ExpressionEval {
 }
 
 func (t *tableCallEval) HasNext() bool {
-	return t.index <= t.paramCount
+	return t.index <= t.evalCount
 }
 
 func (t *tableCallEval) Next() (ExpressionEval, error) {
-	if t.index == t.paramCount {
+	if t.index == t.evalCount {
 		return t.callTable()
 	}
-	return t.def.params[t.index].Eval().SetContext(t.ctx), nil
+	return t.evals[t.index].Eval().SetContext(t.ctx), nil
+}
+
+// rowCtx creates the context rows of the called table are evaluated in.
+func (t *tableCallEval) rowCtx() *ExecutionContext {
+	ctx := t.ctx.Child()
+	ctx.pack = t.def.packageKey
+	ctx.rowTag = t.rowTag
+	ctx.rowTagTable = t.table
+	return ctx
 }
 
 func (t *tableCallEval) callTable() (ExpressionEval, error) {
@@ -73,21 +113,35 @@ func (t *tableCallEval) callTable() (ExpressionEval, error) {
 	if !ok {
 		return nil, fmt.Errorf("could not access table pack '%s'", t.def.packageName)
 	}
-	table, ok := pack.tables[t.def.tableName]
+	table, ok := pack.table(t.def.tableName, t.ctx.Locale())
 	if !ok {
 		return nil, fmt.Errorf("package '%s' has no table '%s'", t.def.packageName, t.def.tableName)
 	}
+	t.table = table
+	if t.def.tag != nil {
+		tag := t.results[t.paramCount]
+		if !tag.MatchType(StringResult) {
+			return nil, fmt.Errorf("row tag filter must be a string value")
+		}
+		t.rowTag = tag.StringVal()
+		if t.paramCount > 0 {
+			mode := t.results[0].StringVal()
+			if !t.results[0].MatchType(StringResult) || (mode != "roll" && mode != "weighted") {
+				return nil, fmt.Errorf("row tag filters can only be used with roll and weighted rolls")
+			}
+		}
+	}
 	if t.paramCount == 0 {
-		return table.Roll().Eval().SetContext(t.ctx.Child()), nil
+		return table.Roll().Eval().SetContext(t.rowCtx()), nil
 	}
 	if !t.results[0].MatchType(StringResult) {
-		return nil, fmt.Errorf("roll type must be a string value one of: roll/weighted/index/label/deck")
+		return nil, fmt.Errorf("roll type must be a string value one of: roll/weighted/index/label/deck/unique/dice")
 	}
 	switch t.results[0].StringVal() {
 	case "roll":
-		return table.Roll().Eval().SetContext(t.ctx.Child()), nil
+		return table.Roll().Eval().SetContext(t.rowCtx()), nil
 	case "weighted":
-		return table.WeightedRoll().Eval().SetContext(t.ctx.Child()), nil
+		return table.WeightedRoll().Eval().SetContext(t.rowCtx()), nil
 	case "index":
 		if t.paramCount != 2 {
 			return nil, fmt.Errorf("index rolls require 2 parameters: '!t(index, <number>)'")
@@ -99,7 +153,7 @@ func (t *tableCallEval) callTable() (ExpressionEval, error) {
 		if err != nil {
 			return nil, err
 		}
-		return row.Eval().SetContext(t.ctx.Child()), nil
+		return row.Eval().SetContext(t.rowCtx()), nil
 	case "label":
 		if t.paramCount != 2 {
 			return nil, fmt.Errorf("label rolls require 2 parameters: '!t(label, <string>)")
@@ -111,36 +165,144 @@ func (t *tableCallEval) callTable() (ExpressionEval, error) {
 		if err != nil {
 			return nil, err
 		}
-		return row.Eval().SetContext(t.ctx.Child()), nil
-	case "deck":
+		return row.Eval().SetContext(t.rowCtx()), nil
+	case "dice":
+		if t.paramCount > 1 {
+			return nil, fmt.Errorf("dice rolls don't take parameters: '!t(dice)'")
+		}
+		roll, err := table.DiceRoll()
+		if err != nil {
+			return nil, err
+		}
+		return roll.Eval().SetContext(t.rowCtx()), nil
+	case "unique":
+		count := 1
 		if t.paramCount == 2 {
-			shuf := t.results[1]
-			if !shuf.MatchType(StringResult) || (shuf.StringVal() != "shuffle" && shuf.strVal != "no-shuffle") {
-				return nil, fmt.Errorf("if passing 2 parameters to a deck roll the 2nd parameter should be 'shuffle' or 'no-shuffle'")
+			if !t.results[1].MatchType(IntResult) {
+				return nil, fmt.Errorf("unique rolls must have a number for the second parameter")
 			}
+			count = t.results[1].IntVal()
 		}
 		if t.paramCount > 2 {
-			return nil, fmt.Errorf("too many parameters for deck roll, should be 1 or 2")
+			return nil, fmt.Errorf("too many parameters for unique roll, should be 1 or 2")
+		}
+		rows, err := table.UniqueRoll(t.ctx, count)
+		if err != nil {
+			return nil, err
+		}
+		return joinRows(rows).Eval().SetContext(t.rowCtx()), nil
+	}
+	if mode := t.results[0].StringVal(); mode == "deck" || strings.HasPrefix(mode, deckPrefix) {
+		return t.callDeck(table, strings.TrimPrefix(strings.TrimPrefix(mode, "deck"), ":"))
+	}
+	return nil, fmt.Errorf("roll type must be a string value one of: roll/weighted/index/label/deck/unique/dice")
+}
+
+// callDeck runs a deck operation on the named deck of a table.
+//
+//  !t(deck)                     draw a card
+//  !t(deck, shuffle)            shuffle every card back in and draw
+//  !t(deck, no-shuffle)         draw a card
+//  !t(deck, draw, <count>?)     draw cards, joined with ", "
+//  !t(deck, peek)               the next card, without drawing it
+//  !t(deck, remaining)          number of cards left in the deck
+//  !t(deck, discards)           number of cards in the discard pile
+//  !t(deck, reshuffle)          shuffle the discard pile back in
+//  !t(deck, discard, <label>)   move a drawn card to the discard pile
+//  !t(deck, return, <label>)    put a drawn or discarded card back in the deck
+//
+// `deck:<name>` instead of `deck` uses a separate deck with its own state.
+func (t *tableCallEval) callDeck(table *Table, name string) (ExpressionEval, error) {
+	op := "draw"
+	args := make([]*ExpressionResult, 0)
+	if t.paramCount > 1 {
+		if !t.results[1].MatchType(StringResult) {
+			return nil, fmt.Errorf("deck operation must be a string value one of: %s", deckOps)
+		}
+		op = t.results[1].StringVal()
+		args = t.results[2:]
+	}
+	switch op {
+	case "shuffle", "no-shuffle", "peek", "remaining", "discards", "reshuffle":
+		if len(args) > 0 {
+			return nil, fmt.Errorf("too many parameters for deck %s, should be 1 or 2", op)
+		}
+	case "draw":
+		if len(args) > 0 && !args[0].MatchType(IntResult) {
+			return nil, fmt.Errorf("deck draw count must be a number")
+		}
+	case "discard", "return":
+		if len(args) != 1 || !args[0].MatchType(StringResult) {
+			return nil, fmt.Errorf("deck %s requires a label: '!t(deck, %s, <label>)'", op, op)
+		}
+	default:
+		return nil, fmt.Errorf("deck operation must be a string value one of: %s", deckOps)
+	}
+
+	var result Evallable
+	switch op {
+	case "shuffle", "no-shuffle", "draw":
+		if op == "shuffle" {
+			table.DeckShuffle(name)
 		}
-		if t.paramCount == 2 && t.results[1].strVal == "shuffle" {
-			table.Shuffle()
+		count := 1
+		if len(args) > 0 {
+			count = args[0].IntVal()
 		}
-		row, err := table.DeckDraw()
+		rows, err := table.DeckDrawN(t.ctx, name, count)
 		if err != nil {
 			return nil, err
 		}
-		return row.Eval().SetContext(t.ctx.Child()), nil
+		result = joinRows(rows)
+	case "peek":
+		row, err := table.DeckPeek(t.ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		result = row
+	case "remaining":
+		result = NewNumber(table.DeckRemaining(name))
+	case "discards":
+		result = NewNumber(table.DeckDiscards(name))
+	case "reshuffle":
+		table.DeckReshuffle(name)
+		result = NewString("", false)
+	case "discard":
+		if err := table.DeckDiscard(name, args[0].StringVal()); err != nil {
+			return nil, err
+		}
+		result = NewString("", false)
+	case "return":
+		if err := table.DeckReturn(name, args[0].StringVal()); err != nil {
+			return nil, err
+		}
+		result = NewString("", false)
+	}
+	return result.Eval().SetContext(t.rowCtx()), nil
+}
+
+// joinRows joins multiple rows into one comma separated value.
+func joinRows(rows []Evallable) Evallable {
+	if len(rows) == 1 {
+		return rows[0]
+	}
+	items := make([]Evallable, 0, len(rows)*2-1)
+	for i, r := range rows {
+		if i > 0 {
+			items = append(items, NewString(", ", false))
+		}
+		items = append(items, r)
 	}
-	return nil, fmt.Errorf("roll type must be a string value one of: roll/weighted/index/label/deck")
+	return NewListExpression(items)
 }
 
 func (t *tableCallEval) Provide(res *ExpressionResult) error {
-	if t.index == t.paramCount {
+	if t.index == t.evalCount {
 		t.tableResult = res
 		t.index++
 		return nil
 	}
-	if t.index > t.paramCount {
+	if t.index > t.evalCount {
 		return fmt.Errorf("too many sub-expression results applied to table call")
 	}
 	t.results = append(t.results, res)
@@ -149,7 +311,7 @@ func (t *tableCallEval) Provide(res *ExpressionResult) error {
 }
 
 func (t *tableCallEval) Resolve() (*ExpressionResult, error) {
-	if t.index == t.paramCount+1 {
+	if t.index == t.evalCount+1 {
 		return t.tableResult, nil
 	}
 	return nil, fmt.Errorf("can't resolve table call, not all sub-expressions evaluated")
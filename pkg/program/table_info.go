@@ -0,0 +1,154 @@
+package program
+
+import (
+	"fmt"
+	"strings"
+)
+
+// tagged returns the rows matching the tag filter of the context, all rows if
+// there is no filter for the table.
+func (t *Table) tagged(ctx *ExecutionContext) []int {
+	filter := ctx != nil && len(ctx.rowTag) > 0 && ctx.rowTagTable == t
+	result := make([]int, 0, len(t.rows))
+	for i, r := range t.rows {
+		if !filter || r.HasTag(ctx.rowTag) {
+			result = append(result, i)
+		}
+	}
+	return result
+}
+
+func (t *Table) noTaggedRows(ctx *ExecutionContext) error {
+	return fmt.Errorf("table '%s' has no rows tagged '%s'", t.name, ctx.rowTag)
+}
+
+// Labels returns the row labels of the table in row order.
+func (t *Table) Labels() []string {
+	result := make([]string, 0, len(t.rows))
+	for _, r := range t.rows {
+		if len(r.label) > 0 {
+			result = append(result, r.label)
+		}
+	}
+	return result
+}
+
+// lookupTable finds a table from a name like `Table` or `pkg.Table`, with the
+// package names of the pack being evaluated.
+func (ctx *ExecutionContext) lookupTable(name string) (*Table, bool) {
+	if ctx == nil || ctx.packs == nil {
+		return nil, false
+	}
+	current := ctx.pack
+	if len(current) == 0 {
+		current = RootPack
+	}
+	pack, ok := ctx.packs[current]
+	if !ok {
+		return nil, false
+	}
+	tableName := name
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		key, ok := pack.aliases[name[:i]]
+		if !ok {
+			return nil, false
+		}
+		if pack, ok = ctx.packs[key]; !ok {
+			return nil, false
+		}
+		tableName = name[i+1:]
+	}
+	return pack.table(tableName, ctx.Locale())
+}
+
+func tableInfo(ctx *ExecutionContext, fn string, name string) (*Table, error) {
+	t, ok := ctx.lookupTable(name)
+	if !ok {
+		return nil, fmt.Errorf("%s: no table named '%s'", fn, name)
+	}
+	return t, nil
+}
+
+func tagResolve(ctx *ExecutionContext, results []*ExpressionResult) (*ExpressionResult, error) {
+	t, err := tableInfo(ctx, "tag", results[0].StringVal())
+	if err != nil {
+		return nil, err
+	}
+	v, _ := t.Tag(results[1].StringVal())
+	return NewStringResult(v), nil
+}
+
+func rowsResolve(ctx *ExecutionContext, results []*ExpressionResult) (*ExpressionResult, error) {
+	t, err := tableInfo(ctx, "rows", results[0].StringVal())
+	if err != nil {
+		return nil, err
+	}
+	return NewIntResult(t.RowCount()), nil
+}
+
+func labelsResolve(ctx *ExecutionContext, results []*ExpressionResult) (*ExpressionResult, error) {
+	t, err := tableInfo(ctx, "labels", results[0].StringVal())
+	if err != nil {
+		return nil, err
+	}
+	return NewStringResult(strings.Join(t.Labels(), ", ")), nil
+}
+
+func weightResolve(ctx *ExecutionContext, results []*ExpressionResult) (*ExpressionResult, error) {
+	t, err := tableInfo(ctx, "weight", results[0].StringVal())
+	if err != nil {
+		return nil, err
+	}
+	if !t.dynamic {
+		return NewIntResult(t.TotalWeight()), nil
+	}
+	total, err := t.dynamicWeight(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return NewIntResult(total), nil
+}
+
+// dynamicWeight evaluates the row conditions and weight expressions of a table
+// like a weighted roll does, and returns the total weight of the rows that
+// pass their condition.
+func (t *Table) dynamicWeight(ctx *ExecutionContext) (int, error) {
+	total := 0
+	for i, r := range t.rows {
+		if r.when != nil {
+			res, err := EvaluateExpression(r.when, ctx.Child())
+			if err != nil {
+				return 0, err
+			}
+			if !res.MatchType(IntResult) {
+				return 0, fmt.Errorf("row %d of table '%s' needs an integer for its condition and weight", i+1, t.name)
+			}
+			if !res.BoolVal() {
+				continue
+			}
+		}
+		if r.weightExpr == nil {
+			total += r.weight
+			continue
+		}
+		res, err := EvaluateExpression(r.weightExpr, ctx.Child())
+		if err != nil {
+			return 0, err
+		}
+		if !res.MatchType(IntResult) {
+			return 0, fmt.Errorf("row %d of table '%s' needs an integer for its condition and weight", i+1, t.name)
+		}
+		if res.IntVal() < 0 {
+			return 0, fmt.Errorf("row %d of table '%s' has a negative weight %d", i+1, t.name, res.IntVal())
+		}
+		total += res.IntVal()
+	}
+	return total, nil
+}
+
+func existsResolve(ctx *ExecutionContext, results []*ExpressionResult) (*ExpressionResult, error) {
+	if _, ok := ctx.lookupTable(results[0].StringVal()); ok {
+		return NewIntResult(1), nil
+	}
+	return NewIntResult(0), nil
+}
@@ -1,6 +1,9 @@
 package program
 
-import "math/rand"
+import (
+	"math/rand"
+	"sync"
+)
 
 // RandomSource is a customizable randoom source for replacing for tests or seeding.
 type RandomSource interface {
@@ -35,6 +38,11 @@ func (r *TestingRandSource) AddMore(vals ...int) {
 	r.vals = append(r.vals, vals...)
 }
 
+// Remaining returns how many values are left.
+func (r *TestingRandSource) Remaining() int {
+	return len(r.vals)
+}
+
 // NewTestRandSource creates a new random source for testing pre-populated
 // with the passsed values.
 func NewTestRandSource(val ...int) *TestingRandSource {
@@ -42,3 +50,59 @@ func NewTestRandSource(val ...int) *TestingRandSource {
 		vals: val,
 	}
 }
+
+// SeededRandSource is a RandomSource with a state that can be saved and
+// restored, so a program can continue with the same random sequence. Programs
+// use DefaultRandSource unless given one with SetRandom, so only programs using
+// a SeededRandSource keep their random sequence in snapshots.
+//
+// Thread safe.
+type SeededRandSource struct {
+	state uint64
+	mu    sync.Mutex
+}
+
+// NewSeededRandSource creates a new random source starting from the given seed.
+func NewSeededRandSource(seed int64) *SeededRandSource {
+	return &SeededRandSource{
+		state: uint64(seed),
+	}
+}
+
+// Get implementation for RandomSource.
+func (r *SeededRandSource) Get(low int, high int) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := uint64(high - low)
+	// The lowest 2^64 mod n values are drawn again so every result is equally
+	// likely, a plain modulo would favor the low end of the range.
+	limit := -n % n
+	for {
+		if z := r.next(); z >= limit {
+			return int(z%n) + low
+		}
+	}
+}
+
+// next returns the next splitmix64 value, the caller holds the lock.
+func (r *SeededRandSource) next() uint64 {
+	r.state += 0x9e3779b97f4a7c15
+	z := r.state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+// State returns the current state of the random source.
+func (r *SeededRandSource) State() uint64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.state
+}
+
+// SetState restores a state returned by State.
+func (r *SeededRandSource) SetState(state uint64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.state = state
+}
@@ -0,0 +1,223 @@
+package tracery
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/wingerjc/tableman-golang/pkg/parser"
+	"github.com/wingerjc/tableman-golang/pkg/program"
+)
+
+// funcModifiers maps functions to the Tracery modifiers that do the same.
+var funcModifiers = map[string]string{
+	"capitalize":     "capitalize",
+	"capitalize_all": "capitalizeAll",
+	"plural":         "s",
+	"a":              "a",
+	"an":             "a",
+}
+
+// rollTags are table tags that change how a table rolls.
+var rollTags = []string{program.AvoidRepeatTag, program.MarkovTag, program.LangTag}
+
+// Export converts the tables of a pack to a Tracery grammar.
+//
+// Rows become rules, plain table calls and variables become `#symbol#` tags and
+// expression variables become push and pop actions. Inline choices become new
+// symbols. Parts Tracery can't express are dropped and returned as diagnostics.
+func Export(code string) ([]byte, []*Diagnostic, error) {
+	p, err := parser.GetParser()
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := p.Parse(code)
+	if err != nil {
+		return nil, nil, err
+	}
+	ex := &exporter{
+		grammar: make(map[string][]string),
+		tables:  make(map[string]bool),
+	}
+	for _, t := range file.Tables {
+		if t != nil {
+			ex.tables[t.Header.Name] = true
+		}
+	}
+	for _, t := range file.Tables {
+		if t != nil {
+			ex.table(t)
+		}
+	}
+	data, err := json.MarshalIndent(ex.grammar, "", "  ")
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, ex.diags, nil
+}
+
+type exporter struct {
+	grammar map[string][]string
+	tables  map[string]bool
+	choices int
+	diags   []*Diagnostic
+}
+
+func (ex *exporter) diag(where string, msg string) {
+	ex.diags = append(ex.diags, &Diagnostic{Where: where, Message: msg})
+}
+
+func (ex *exporter) table(t *parser.Table) {
+	name := t.Header.Name
+	if _, ok := ex.grammar[name]; ok {
+		ex.diag(name, "is defined more than once, only the first is exported")
+		return
+	}
+	if t.Header.Extends != nil {
+		ex.diag(name, fmt.Sprintf("extends '%s', which can't be exported", t.Header.Extends.FullName()))
+		return
+	}
+	if t.Generator != nil {
+		ex.diag(name, "generator rows can't be exported")
+		return
+	}
+	for _, tag := range t.Header.Tags {
+		for _, rt := range rollTags {
+			if tag.Key.String() == rt {
+				ex.diag(name, fmt.Sprintf("tag '%s' changes rolls and can't be exported, it was dropped", rt))
+			}
+		}
+	}
+	rules := make([]string, 0, len(t.Rows))
+	for i, r := range t.Rows {
+		where := fmt.Sprintf("%s row %d", name, i+1)
+		if r.When != nil || r.WeightExpr != nil {
+			ex.diag(where, "conditions and weight expressions can't be exported, they were dropped")
+		}
+		rules = append(rules, ex.items(where, r.Values))
+	}
+	ex.grammar[name] = rules
+}
+
+// items converts row items to a Tracery rule.
+func (ex *exporter) items(where string, items []*parser.RowItem) string {
+	var b strings.Builder
+	for _, i := range items {
+		switch {
+		case i.Expression != nil:
+			b.WriteString(ex.expression(where, i.Expression))
+		case i.Inline != nil:
+			b.WriteString(ex.inline(where, i.Inline))
+		default:
+			b.WriteString(escape(i.String()))
+		}
+	}
+	return b.String()
+}
+
+// expression converts an expression, variables are pushed before the value
+// and popped after it.
+func (ex *exporter) expression(where string, e *parser.Expression) string {
+	var b strings.Builder
+	for _, v := range e.Vars {
+		value := ex.value(where, v.AssignedValue)
+		if strings.Contains(value, ",") {
+			ex.diag(where, fmt.Sprintf("the value of '@%s' has commas, Tracery splits it into several rules", v.VarName.Name))
+		}
+		fmt.Fprintf(&b, "[%s:%s]", v.VarName.Name, value)
+	}
+	b.WriteString(ex.value(where, e.Value))
+	for _, v := range e.Vars {
+		fmt.Fprintf(&b, "[%s:POP]", v.VarName.Name)
+	}
+	return b.String()
+}
+
+func (ex *exporter) value(where string, v *parser.ValueExpr) string {
+	switch v.GetType() {
+	case parser.LabelExprT:
+		return escape(v.Label.String())
+	case parser.NumExprT:
+		return strconv.Itoa(*v.Num)
+	case parser.VarExprT:
+		return "#" + v.Variable.Name + "#"
+	case parser.InlineExprT:
+		return ex.inline(where, v.Inline)
+	case parser.TableExprT:
+		return ex.tableCall(where, v.Call)
+	case parser.FuncExprT:
+		return ex.function(where, v.Call)
+	}
+	ex.diag(where, "dice rolls can't be exported, they were dropped")
+	return ""
+}
+
+func (ex *exporter) tableCall(where string, c *parser.Call) string {
+	name := c.Name.FullName()
+	if len(c.Name.PackageName()) > 0 {
+		ex.diag(where, fmt.Sprintf("'%s' is in another pack and can't be exported, it was dropped", name))
+		return ""
+	}
+	if !ex.tables[name] {
+		ex.diag(where, fmt.Sprintf("table '%s' isn't defined", name))
+	}
+	if len(c.Params) > 0 {
+		ex.diag(where, fmt.Sprintf("parameters to '%s' can't be exported, it's called as a plain roll", name))
+	}
+	return "#" + name + "#"
+}
+
+func (ex *exporter) function(where string, c *parser.Call) string {
+	name := c.Name.FullName()
+	if name == "concat" {
+		var b strings.Builder
+		for _, p := range c.Params {
+			b.WriteString(ex.value(where, p.Value))
+		}
+		return b.String()
+	}
+	modifier, ok := funcModifiers[name]
+	if !ok || len(c.Params) != 1 {
+		ex.diag(where, fmt.Sprintf("function '%s' can't be exported, it was dropped", name))
+		return ""
+	}
+	inner := ex.value(where, c.Params[0].Value)
+	if !isTag(inner) {
+		ex.diag(where, fmt.Sprintf("'%s' only works on a table call or variable in Tracery, it was dropped", name))
+		return inner
+	}
+	return inner[:len(inner)-1] + "." + modifier + "#"
+}
+
+// inline converts an inline choice to a new symbol, weights repeat the rule.
+func (ex *exporter) inline(where string, t *parser.InlineTable) string {
+	name := ""
+	for name == "" || ex.tables[name] {
+		ex.choices++
+		name = fmt.Sprintf("choice-%d", ex.choices)
+	}
+	ex.tables[name] = true
+	rules := make([]string, 0, len(t.Options))
+	for _, o := range t.Options {
+		rule := ex.items(where, o.Values)
+		rules = append(rules, rule)
+		for i := 1; i < o.Weight; i++ {
+			rules = append(rules, rule)
+		}
+	}
+	ex.grammar[name] = rules
+	return "#" + name + "#"
+}
+
+// isTag is whether a rule is a single `#symbol#` tag.
+func isTag(rule string) bool {
+	return len(rule) > 2 && strings.HasPrefix(rule, "#") && strings.HasSuffix(rule, "#") &&
+		strings.Count(rule, "#") == 2 && !strings.Contains(rule, "[")
+}
+
+// escape escapes the characters Tracery treats as syntax in text.
+func escape(text string) string {
+	r := strings.NewReplacer(`\`, `\\`, `#`, `\#`, `[`, `\[`, `]`, `\]`)
+	return r.Replace(text)
+}
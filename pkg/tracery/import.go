@@ -0,0 +1,381 @@
+package tracery
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	compiler "github.com/wingerjc/tableman-golang/pkg/compile"
+	"github.com/wingerjc/tableman-golang/pkg/program"
+)
+
+// modifierFuncs maps Tracery modifiers to the functions that do the same.
+var modifierFuncs = map[string]string{
+	"capitalize":    "capitalize",
+	"capitalizeAll": "capitalize_all",
+	"s":             "plural",
+	"a":             "a",
+}
+
+var invalidNameChars = regexp.MustCompile(`[^a-zA-Z0-9\-_]`)
+
+// Import converts a Tracery grammar to the source of a table pack named packName.
+//
+// Each symbol becomes a table with a row for each rule, `#symbol#` becomes a
+// table call and modifiers become function calls. Pushed values are variables
+// set for the rest of the rule, so they are seen by the tables it calls. Parts
+// that can't be converted exactly are returned as diagnostics.
+func Import(data []byte, packName string) (string, []*Diagnostic, error) {
+	raw := make(map[string]interface{})
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return "", nil, fmt.Errorf("could not read tracery grammar: %w", err)
+	}
+	symbols := make(map[string][]string)
+	for k, v := range raw {
+		switch val := v.(type) {
+		case string:
+			symbols[k] = []string{val}
+		case []interface{}:
+			rules := make([]string, 0, len(val))
+			for _, r := range val {
+				s, ok := r.(string)
+				if !ok {
+					return "", nil, fmt.Errorf("symbol '%s' must only have string rules", k)
+				}
+				rules = append(rules, s)
+			}
+			symbols[k] = rules
+		default:
+			return "", nil, fmt.Errorf("symbol '%s' must be a string or a list of strings", k)
+		}
+	}
+
+	im := newImporter(symbols)
+	order := make([]string, 0, len(symbols))
+	for k := range symbols {
+		if k != "origin" {
+			order = append(order, k)
+		}
+	}
+	sort.Strings(order)
+	if _, ok := symbols["origin"]; ok {
+		order = append([]string{"origin"}, order...)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "TablePack: %s\n", packName)
+	for _, k := range order {
+		fmt.Fprintf(&b, "\nTableDef: %s\n", im.names[k])
+		if len(symbols[k]) == 0 {
+			im.diag(k, "has no rules, an empty row was added")
+			b.WriteString("\"\"\n")
+		}
+		for i, rule := range symbols[k] {
+			b.WriteString(im.row(fmt.Sprintf("%s rule %d", k, i+1), rule))
+			b.WriteString("\n")
+		}
+	}
+	return b.String(), im.diags, nil
+}
+
+// Compile converts a Tracery grammar with Import and compiles the pack.
+func Compile(c *compiler.Compiler, data []byte, packName string) (*program.Program, []*Diagnostic, error) {
+	code, diags, err := Import(data, packName)
+	if err != nil {
+		return nil, nil, err
+	}
+	prog, err := c.CompileString(code)
+	if err != nil {
+		return nil, diags, fmt.Errorf("could not compile converted grammar: %w", err)
+	}
+	return prog, diags, nil
+}
+
+// Push states of a key within a rule.
+const (
+	notPushed = iota
+	pushed
+	pushEnded
+)
+
+type importer struct {
+	symbols map[string][]string
+	// pushed is every key pushed anywhere in the grammar.
+	pushed map[string]bool
+	// names are the table and variable names for symbols and keys.
+	names map[string]string
+	diags []*Diagnostic
+}
+
+func newImporter(symbols map[string][]string) *importer {
+	im := &importer{
+		symbols: symbols,
+		pushed:  make(map[string]bool),
+		names:   make(map[string]string),
+	}
+	keys := make([]string, 0, len(symbols))
+	for k, rules := range symbols {
+		keys = append(keys, k)
+		for _, r := range rules {
+			im.collectPushes(r)
+		}
+	}
+	for k := range im.pushed {
+		if _, ok := symbols[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	used := make(map[string]bool)
+	for _, k := range keys {
+		name := invalidNameChars.ReplaceAllString(k, "-")
+		if len(name) == 0 || !isLetter(name[0]) {
+			name = "s" + name
+		}
+		for base, i := name, 2; used[name]; i++ {
+			name = fmt.Sprintf("%s-%d", base, i)
+		}
+		if name != k {
+			im.diag(k, fmt.Sprintf("renamed to '%s'", name))
+		}
+		used[name] = true
+		im.names[k] = name
+	}
+	return im
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func (im *importer) diag(where string, msg string) {
+	im.diags = append(im.diags, &Diagnostic{Where: where, Message: msg})
+}
+
+// collectPushes records every key pushed in a rule, errors are reported later.
+func (im *importer) collectPushes(rule string) {
+	sections, err := parseRule(rule)
+	if err != nil {
+		return
+	}
+	actions := make([]*action, 0)
+	for _, s := range sections {
+		switch s.kind {
+		case actionSection:
+			if a, err := parseAction(s.raw); err == nil {
+				actions = append(actions, a)
+			}
+		case tagSection:
+			if t, err := parseTag(s.raw); err == nil {
+				actions = append(actions, t.actions...)
+			}
+		}
+	}
+	for _, a := range actions {
+		if a.pop {
+			continue
+		}
+		im.pushed[a.key] = true
+		for _, r := range a.rules {
+			im.collectPushes(r)
+		}
+	}
+}
+
+// ruleState tracks the variables pushed so far in a rule.
+type ruleState struct {
+	where string
+	keys  map[string]int
+	// early are keys used before they're pushed in the rule.
+	early map[string]bool
+	vars  []string
+}
+
+// row converts a rule to the source of a table row.
+func (im *importer) row(where string, rule string) string {
+	sections, err := parseRule(rule)
+	if err != nil {
+		im.diag(where, fmt.Sprintf("%s, kept as text", err.Error()))
+		return im.text(where, rule)
+	}
+	state := &ruleState{
+		where: where,
+		keys:  make(map[string]int),
+		early: make(map[string]bool),
+	}
+	items := make([]string, 0)
+	for _, s := range sections {
+		switch s.kind {
+		case textSection:
+			items = append(items, im.text(where, s.raw))
+		case actionSection:
+			a, err := parseAction(s.raw)
+			if err != nil {
+				im.diag(where, fmt.Sprintf("%s, it was dropped", err.Error()))
+				continue
+			}
+			im.act(state, a)
+		case tagSection:
+			t, err := parseTag(s.raw)
+			if err != nil {
+				im.diag(where, fmt.Sprintf("%s, it was dropped", err.Error()))
+				continue
+			}
+			for _, a := range t.actions {
+				im.act(state, a)
+			}
+			if len(t.symbol) > 0 {
+				items = append(items, im.ref(state, t))
+			}
+			// Actions in a tag only last for the tag.
+			for _, a := range t.actions {
+				if !a.pop && state.keys[a.key] == pushed {
+					state.keys[a.key] = pushEnded
+				}
+			}
+		}
+	}
+
+	if len(state.vars) == 0 {
+		if len(items) == 0 {
+			return `""`
+		}
+		rowItems := make([]string, 0, len(items))
+		for _, i := range items {
+			rowItems = append(rowItems, rowItem(i))
+		}
+		return strings.Join(rowItems, " ")
+	}
+	return fmt.Sprintf("{%s; %s}", strings.Join(state.vars, ", "), joinValues(items))
+}
+
+// act adds a variable for a push or ends it for a pop.
+func (im *importer) act(state *ruleState, a *action) {
+	if a.pop {
+		if state.keys[a.key] != pushed {
+			im.diag(state.where, fmt.Sprintf("pops '%s' which isn't pushed earlier in the rule, the pop was dropped", a.key))
+			return
+		}
+		state.keys[a.key] = pushEnded
+		return
+	}
+	if state.keys[a.key] != notPushed {
+		im.diag(state.where, fmt.Sprintf("pushes '%s' more than once, the last value is used for the whole rule", a.key))
+	}
+	if state.early[a.key] {
+		im.diag(state.where, fmt.Sprintf("uses '%s' before pushing it, the pushed value is used", a.key))
+		delete(state.early, a.key)
+	}
+	values := make([]string, 0, len(a.rules))
+	for _, r := range a.rules {
+		values = append(values, im.value(state, r))
+	}
+	value := values[0]
+	if len(values) > 1 {
+		im.diag(state.where, fmt.Sprintf("pushes %d rules for '%s', one is picked when it's pushed instead of each time it's used",
+			len(values), a.key))
+		options := make([]string, 0, len(values))
+		for _, v := range values {
+			options = append(options, rowItem(v))
+		}
+		value = fmt.Sprintf("[ %s ]", strings.Join(options, " | "))
+	}
+	state.vars = append(state.vars, fmt.Sprintf("@%s=%s", im.names[a.key], value))
+	state.keys[a.key] = pushed
+}
+
+// value converts a pushed rule to a value expression, actions in it are dropped.
+func (im *importer) value(state *ruleState, rule string) string {
+	sections, err := parseRule(rule)
+	if err != nil {
+		im.diag(state.where, fmt.Sprintf("%s, kept as text", err.Error()))
+		return im.text(state.where, rule)
+	}
+	items := make([]string, 0)
+	for _, s := range sections {
+		switch s.kind {
+		case textSection:
+			items = append(items, im.text(state.where, s.raw))
+		case actionSection:
+			im.diag(state.where, fmt.Sprintf("actions in pushed rules aren't supported, '[%s]' was dropped", s.raw))
+		case tagSection:
+			t, err := parseTag(s.raw)
+			if err != nil {
+				im.diag(state.where, fmt.Sprintf("%s, it was dropped", err.Error()))
+				continue
+			}
+			if len(t.actions) > 0 {
+				im.diag(state.where, fmt.Sprintf("actions in pushed rules aren't supported, they were dropped from '#%s#'", s.raw))
+			}
+			if len(t.symbol) > 0 {
+				items = append(items, im.ref(state, t))
+			}
+		}
+	}
+	return joinValues(items)
+}
+
+// ref converts a tag to a value expression for its symbol with modifiers.
+func (im *importer) ref(state *ruleState, t *tag) string {
+	name := im.names[t.symbol]
+	_, isSymbol := im.symbols[t.symbol]
+	var v string
+	switch {
+	case state.keys[t.symbol] == pushed:
+		v = "@" + name
+	case state.keys[t.symbol] == pushEnded:
+		im.diag(state.where, fmt.Sprintf("uses '%s' after its push ends, the pushed value is used", t.symbol))
+		v = "@" + name
+	case im.pushed[t.symbol] && isSymbol:
+		state.early[t.symbol] = true
+		v = fmt.Sprintf("try(@%s, !%s())", name, name)
+	case im.pushed[t.symbol]:
+		state.early[t.symbol] = true
+		v = "@" + name
+	case isSymbol:
+		v = fmt.Sprintf("!%s()", name)
+	default:
+		im.diag(state.where, fmt.Sprintf("symbol '%s' isn't defined", t.symbol))
+		return im.text(state.where, fmt.Sprintf("((%s))", t.symbol))
+	}
+	for _, m := range t.modifiers {
+		fn, ok := modifierFuncs[m]
+		if !ok {
+			im.diag(state.where, fmt.Sprintf("modifier '.%s' isn't supported and was dropped", m))
+			continue
+		}
+		v = fmt.Sprintf("%s(%s)", fn, v)
+	}
+	return v
+}
+
+// text converts plain text to a string literal, table strings can't contain
+// double quotes so they are replaced.
+func (im *importer) text(where string, raw string) string {
+	if strings.Contains(raw, `"`) {
+		im.diag(where, `double quotes in text were replaced with single quotes`)
+		raw = strings.ReplaceAll(raw, `"`, "'")
+	}
+	return `"` + raw + `"`
+}
+
+// rowItem wraps a value expression as a row item, strings can be used as they are.
+func rowItem(value string) string {
+	if strings.HasPrefix(value, `"`) {
+		return value
+	}
+	return "{" + value + "}"
+}
+
+// joinValues joins value expressions into one.
+func joinValues(items []string) string {
+	switch len(items) {
+	case 0:
+		return `""`
+	case 1:
+		return items[0]
+	}
+	return fmt.Sprintf("concat(%s)", strings.Join(items, ", "))
+}
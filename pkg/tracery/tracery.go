@@ -0,0 +1,160 @@
+// Package tracery converts Tracery grammars to table packs and back.
+//
+// A Tracery grammar is a JSON object of symbols, each with a list of rules.
+// Rules are text with `#symbol#` tags, optionally with modifiers like
+// `#animal.a#`, and `[key:rule]` actions that push a value for a key until
+// it's popped with `[key:POP]`.
+package tracery
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Diagnostic is a part of a grammar or pack that couldn't be converted exactly.
+type Diagnostic struct {
+	// Where is the symbol or table, and row, the problem is in.
+	Where   string
+	Message string
+}
+
+// String formats the diagnostic for printing.
+func (d *Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s", d.Where, d.Message)
+}
+
+type sectionType int
+
+const (
+	textSection sectionType = iota
+	tagSection
+	actionSection
+)
+
+// section is a piece of a Tracery rule, plain text, a `#tag#` or an `[action]`.
+type section struct {
+	kind sectionType
+	raw  string
+}
+
+// parseRule splits a rule into sections like Tracery does. Escapes are kept in
+// tags and actions so they can be parsed again, and removed from text.
+func parseRule(rule string) ([]*section, error) {
+	sections := make([]*section, 0)
+	current := make([]rune, 0)
+	flush := func(kind sectionType) {
+		if kind != textSection || len(current) > 0 {
+			sections = append(sections, &section{kind: kind, raw: string(current)})
+		}
+		current = make([]rune, 0)
+	}
+	depth := 0
+	inTag := false
+	escaped := false
+	for _, c := range rule {
+		if escaped {
+			current = append(current, c)
+			escaped = false
+			continue
+		}
+		switch {
+		case c == '\\':
+			escaped = true
+			if depth > 0 || inTag {
+				current = append(current, c)
+			}
+		case c == '[':
+			if depth == 0 && !inTag {
+				flush(textSection)
+			} else {
+				current = append(current, c)
+			}
+			depth++
+		case c == ']':
+			depth--
+			if depth < 0 {
+				return nil, fmt.Errorf("unopened ']' in rule '%s'", rule)
+			}
+			if depth == 0 && !inTag {
+				flush(actionSection)
+			} else {
+				current = append(current, c)
+			}
+		case c == '#' && depth == 0:
+			if inTag {
+				flush(tagSection)
+			} else {
+				flush(textSection)
+			}
+			inTag = !inTag
+		default:
+			current = append(current, c)
+		}
+	}
+	if inTag {
+		return nil, fmt.Errorf("unclosed '#' in rule '%s'", rule)
+	}
+	if depth > 0 {
+		return nil, fmt.Errorf("unclosed '[' in rule '%s'", rule)
+	}
+	flush(textSection)
+	return sections, nil
+}
+
+// tag is a parsed `#[action]symbol.modifier#` tag.
+type tag struct {
+	actions   []*action
+	symbol    string
+	modifiers []string
+}
+
+func parseTag(raw string) (*tag, error) {
+	sections, err := parseRule(raw)
+	if err != nil {
+		return nil, err
+	}
+	result := &tag{}
+	for _, s := range sections {
+		switch s.kind {
+		case actionSection:
+			a, err := parseAction(s.raw)
+			if err != nil {
+				return nil, err
+			}
+			result.actions = append(result.actions, a)
+		case textSection:
+			if len(result.symbol) > 0 {
+				return nil, fmt.Errorf("tag '#%s#' has text after the symbol", raw)
+			}
+			parts := strings.Split(s.raw, ".")
+			result.symbol = parts[0]
+			result.modifiers = parts[1:]
+		default:
+			return nil, fmt.Errorf("tag '#%s#' can't contain tags", raw)
+		}
+	}
+	return result, nil
+}
+
+// action is a parsed `[key:rule]` or `[key:POP]` action.
+type action struct {
+	key   string
+	rules []string
+	pop   bool
+}
+
+func parseAction(raw string) (*action, error) {
+	i := strings.Index(raw, ":")
+	if i < 0 {
+		return nil, fmt.Errorf("action '[%s]' isn't a push or pop", raw)
+	}
+	result := &action{key: raw[:i]}
+	value := raw[i+1:]
+	if value == "POP" {
+		result.pop = true
+		return result, nil
+	}
+	// Tracery splits pushed rules on every comma.
+	result.rules = strings.Split(value, ",")
+	return result, nil
+}
@@ -0,0 +1,179 @@
+package tracery
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	compiler "github.com/wingerjc/tableman-golang/pkg/compile"
+)
+
+func evalString(code string, c *compiler.Compiler, data []byte, assert *assert.Assertions) string {
+	prog, _, err := Compile(c, data, "story")
+	if !assert.NoError(err) {
+		return ""
+	}
+	expr, err := c.CompileExpression(code)
+	assert.NoError(err)
+	result, err := prog.Eval(expr)
+	if !assert.NoError(err) {
+		return ""
+	}
+	return result.StringVal()
+}
+
+func messages(diags []*Diagnostic) []string {
+	result := make([]string, 0, len(diags))
+	for _, d := range diags {
+		result = append(result, d.String())
+	}
+	return result
+}
+
+func TestParseRule(t *testing.T) {
+	assert := assert.New(t)
+
+	sections, err := parseRule(`a \#b #[x:#y#]z.s# [p:#q#, r]`)
+	assert.NoError(err)
+	assert.Len(sections, 4)
+	assert.Equal("a #b ", sections[0].raw)
+	assert.Equal(tagSection, sections[1].kind)
+	assert.Equal(" ", sections[2].raw)
+	assert.Equal(actionSection, sections[3].kind)
+
+	tg, err := parseTag(sections[1].raw)
+	assert.NoError(err)
+	assert.Equal("z", tg.symbol)
+	assert.Equal([]string{"s"}, tg.modifiers)
+	assert.Len(tg.actions, 1)
+	assert.Equal([]string{"#y#"}, tg.actions[0].rules)
+
+	a, err := parseAction(sections[3].raw)
+	assert.NoError(err)
+	assert.Equal("p", a.key)
+	assert.Len(a.rules, 2)
+	a, err = parseAction("p:POP")
+	assert.NoError(err)
+	assert.True(a.pop)
+
+	for _, bad := range []string{"#a", "[a:b", "a]", "[noColon]"} {
+		sections, err := parseRule(bad)
+		if err == nil {
+			_, err = parseAction(sections[0].raw)
+		}
+		assert.Error(err, bad)
+	}
+}
+
+func TestImport(t *testing.T) {
+	assert := assert.New(t)
+	c, err := compiler.NewCompiler()
+	assert.NoError(err)
+
+	grammar := []byte(`{
+		"origin": ["#[hero:#name#][pet:#animal#]story#"],
+		"story": ["#hero.capitalize# met #animal.a#, #pet.s.capitalize# \\#1"],
+		"name": "yuuma",
+		"animal": ["owl"]
+	}`)
+	result := evalString(`{!origin()}`, c, grammar, assert)
+	assert.Equal("Yuuma met an owl, Owls #1", result)
+
+	// A pushed symbol that's also defined falls back to its table.
+	grammar = []byte(`{
+		"origin": ["[who:Lena]#greet#"],
+		"greet": ["hi #who.capitalizeAll#"],
+		"who": ["old jo"]
+	}`)
+	result = evalString(`{!origin()}`, c, grammar, assert)
+	assert.Equal("hi Lena", result)
+	result = evalString(`{!greet()}`, c, grammar, assert)
+	assert.Equal("hi Old Jo", result)
+
+	// Pushes last for the whole rule.
+	grammar = []byte(`{
+		"origin": ["#who# and [who:Lena]#who#, #[who:Arjun]who# #who#"],
+		"who": ["Jo"]
+	}`)
+	result = evalString(`{!origin()}`, c, grammar, assert)
+	assert.Equal("Arjun and Arjun, Arjun Arjun", result)
+	_, diags, err := Import(grammar, "story")
+	assert.NoError(err)
+	assert.Equal([]string{
+		"origin rule 1: uses 'who' before pushing it, the pushed value is used",
+		"origin rule 1: pushes 'who' more than once, the last value is used for the whole rule",
+		"origin rule 1: uses 'who' after its push ends, the pushed value is used",
+	}, messages(diags))
+
+	grammar = []byte(`{
+		"origin": ["#x.ed# \"#missing#\" [y:a,b]#y#[z:POP]"],
+		"x": [],
+		"bad name": ["a"]
+	}`)
+	code, diags, err := Import(grammar, "story")
+	assert.NoError(err)
+	_, err = c.CompileString(code)
+	assert.NoError(err)
+	assert.Equal([]string{
+		"bad name: renamed to 'bad-name'",
+		"origin rule 1: modifier '.ed' isn't supported and was dropped",
+		"origin rule 1: double quotes in text were replaced with single quotes",
+		"origin rule 1: symbol 'missing' isn't defined",
+		"origin rule 1: double quotes in text were replaced with single quotes",
+		"origin rule 1: pushes 2 rules for 'y', one is picked when it's pushed instead of each time it's used",
+		"origin rule 1: pops 'z' which isn't pushed earlier in the rule, the pop was dropped",
+		"x: has no rules, an empty row was added",
+	}, messages(diags))
+
+	_, _, err = Import([]byte(`["a"]`), "story")
+	assert.Error(err)
+	_, _, err = Import([]byte(`{"origin": [1]}`), "story")
+	assert.Error(err)
+}
+
+func TestExport(t *testing.T) {
+	assert := assert.New(t)
+
+	code := `TablePack: story
+
+TableDef: origin
+{@hero=!name(); concat("Then ", capitalize(@hero), " met ", a(!animal()))} "#1"
+"a " [ "red" | w=2 "blue" ] " door"
+
+TableDef: name
+w=3: "yuuma"
+when {1}: {plural(!animal(roll, tag="big"))}
+
+TableDef: animal
+~ avoid-repeat: 1
+"owl"
+{ 1d6? } {upper(!name())} {!other.thing()} {a("owl")}`
+	data, diags, err := Export(code)
+	assert.NoError(err)
+	grammar := make(map[string][]string)
+	assert.NoError(json.Unmarshal(data, &grammar))
+	assert.Equal(map[string][]string{
+		"origin":   {`[hero:#name#]Then #hero.capitalize# met #animal.a#[hero:POP]\#1`, "a #choice-1# door"},
+		"choice-1": {"red", "blue", "blue"},
+		"name":     {"yuuma", "#animal.s#"},
+		"animal":   {"owl", "owl"},
+	}, grammar)
+	assert.Equal([]string{
+		"name row 2: conditions and weight expressions can't be exported, they were dropped",
+		"name row 2: parameters to 'animal' can't be exported, it's called as a plain roll",
+		"animal: tag 'avoid-repeat' changes rolls and can't be exported, it was dropped",
+		"animal row 2: dice rolls can't be exported, they were dropped",
+		"animal row 2: function 'upper' can't be exported, it was dropped",
+		"animal row 2: 'other.thing' is in another pack and can't be exported, it was dropped",
+		"animal row 2: 'a' only works on a table call or variable in Tracery, it was dropped",
+	}, messages(diags))
+
+	// Exported grammars import again.
+	c, err := compiler.NewCompiler()
+	assert.NoError(err)
+	result := evalString(`{!origin()}`, c, data, assert)
+	assert.Contains([]string{"Then Yuuma met an owl#1", "Then Owls met an owl#1", "a red door", "a blue door"}, result)
+
+	_, _, err = Export("TablePack: story\nTableDef: \"bad\"")
+	assert.Error(err)
+}